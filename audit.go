@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+// runAudit lists audit events, for the "audit" command.
+func runAudit(database *data.Database, args []string) error {
+	var query data.AuditQuery
+	var action string
+	var since time.Duration
+
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	flags.StringVar(&query.Me, "me", "", "Only list events for this user")
+	flags.StringVar(&query.ClientID, "client-id", "", "Only list events for this client")
+	flags.StringVar(&action, "action", "", "Only list events with this action")
+	flags.DurationVar(&since, "since", 0, "Only list events that happened within this duration")
+	flags.IntVar(&query.Limit, "limit", 100, "Most events to list, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if action != "" {
+		query.Actions = []string{action}
+	}
+	if since > 0 {
+		query.Since = time.Now().UTC().Add(-since)
+	}
+
+	events, err := database.AuditEvents(query)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		fmt.Printf("%s %s %s\n  me: %s\n  client_id: %s\n  provider: %s\n  ip: %s\n  user_agent: %s\n",
+			event.CreatedAt.Format(time.RFC3339),
+			event.Action,
+			event.Outcome,
+			event.Me,
+			event.ClientID,
+			event.Provider,
+			event.IP,
+			event.UserAgent)
+	}
+
+	return nil
+}
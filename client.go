@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/random"
+)
+
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// runClient manages the registered clients, for the "client" command.
+func runClient(database *data.Database, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected one of: add, list, remove")
+	}
+
+	switch args[0] {
+	case "add":
+		var scopes, redirectURIs stringsFlag
+
+		flags := flag.NewFlagSet("client add", flag.ContinueOnError)
+		flags.Var(&scopes, "scope", "Scope the client is allowed, can be repeated")
+		flags.Var(&redirectURIs, "redirect-uri", "Redirect URI the client is allowed, can be repeated")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New("expected a client_id")
+		}
+
+		secret, err := database.RegisterClient(random.String, flags.Arg(0), scopes, redirectURIs)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("client_secret:", secret)
+		fmt.Println("This will not be shown again, so make sure to put it somewhere safe.")
+
+	case "list":
+		clients, err := database.RegisteredClients()
+		if err != nil {
+			return err
+		}
+
+		for _, client := range clients {
+			fmt.Printf("%s\n  scope: %s\n  redirect_uri: %s\n",
+				client.ID,
+				strings.Join(client.Scopes, " "),
+				strings.Join(client.RedirectURIs, " "))
+		}
+
+	case "remove":
+		if len(args) != 2 {
+			return errors.New("expected a client_id")
+		}
+
+		return database.RemoveClient(args[1])
+
+	default:
+		return errors.New("expected one of: add, list, remove")
+	}
+
+	return nil
+}
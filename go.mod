@@ -1,6 +1,6 @@
 module hawx.me/code/relme-auth
 
-go 1.15
+go 1.16
 
 require (
 	github.com/BurntSushi/toml v0.3.1
@@ -9,7 +9,8 @@ require (
 	github.com/golang/protobuf v1.4.3 // indirect
 	github.com/gorilla/context v1.1.1
 	github.com/gorilla/sessions v1.2.1
-	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/lib/pq v1.9.0
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/peterhellberg/link v1.1.0
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/net v0.0.0-20201224014010-6772e930b67b
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+// importSQLite copies everything from the sqlite database at path into the
+// database at dsn, for the "import-sqlite" command.
+func importSQLite(dsn, path string, httpClient *http.Client, expiry data.Expiry) error {
+	if path == "" {
+		return errors.New("expected the path to a sqlite database")
+	}
+
+	src, err := data.Open(path, httpClient, nil, expiry)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := data.Open(dsn, httpClient, nil, expiry)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	return dst.Copy(src)
+}
@@ -6,6 +6,25 @@ import "github.com/BurntSushi/toml"
 type Config struct {
 	Flickr *Strategy `toml:"flickr"`
 	GitHub *Strategy `toml:"github"`
+
+	// Scopes lists the scopes that clients may request. If empty any scope may
+	// be requested.
+	Scopes []Scope `toml:"scope"`
+
+	// Clients lists restrictions on specific clients.
+	Clients []Client `toml:"client"`
+
+	// Discovery controls how the links on a user's profile are verified.
+	Discovery Discovery `toml:"discovery"`
+}
+
+// Discovery has options for verifying the links found on a profile.
+type Discovery struct {
+	// Concurrency is the number of links that are verified at once.
+	Concurrency int `toml:"concurrency"`
+
+	// Timeout is the number of seconds verifying a single link may take.
+	Timeout int `toml:"timeout"`
 }
 
 // Strategy has configuration required for an OAuth/OAuth 2.0 service.
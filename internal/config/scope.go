@@ -0,0 +1,91 @@
+package config
+
+// Scope is a permission that a client can request.
+type Scope struct {
+	Name        string `toml:"name"`
+	Description string `toml:"description"`
+
+	// Sensitive scopes are highlighted to the user when requested.
+	Sensitive bool `toml:"sensitive"`
+}
+
+// Client has configuration for a specific client_id.
+type Client struct {
+	// ID is the client_id, it should be given in normalised form, that is with a
+	// lowercase host and a path of at least "/".
+	ID string `toml:"id"`
+
+	// Scopes lists the scopes the client may request. If empty the client may
+	// request any known scope.
+	Scopes []string `toml:"scopes"`
+}
+
+// FilterScopes returns the details of each requested scope that the client is
+// allowed to request. Scopes that are unknown, or that the client is not allowed,
+// are removed.
+func (c Config) FilterScopes(clientID string, requested []string) []Scope {
+	allowed := c.clientScopes(clientID)
+
+	var scopes []Scope
+	seen := map[string]struct{}{}
+
+	for _, name := range requested {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		if allowed != nil {
+			if _, ok := allowed[name]; !ok {
+				continue
+			}
+		}
+
+		scope, ok := c.scope(name)
+		if !ok {
+			continue
+		}
+
+		scopes = append(scopes, scope)
+	}
+
+	return scopes
+}
+
+func (c Config) scope(name string) (Scope, bool) {
+	if len(c.Scopes) == 0 {
+		return Scope{Name: name}, true
+	}
+
+	for _, scope := range c.Scopes {
+		if scope.Name == name {
+			return scope, true
+		}
+	}
+
+	return Scope{}, false
+}
+
+func (c Config) clientScopes(clientID string) map[string]struct{} {
+	for _, client := range c.Clients {
+		if client.ID == clientID && len(client.Scopes) > 0 {
+			allowed := map[string]struct{}{}
+			for _, name := range client.Scopes {
+				allowed[name] = struct{}{}
+			}
+			return allowed
+		}
+	}
+
+	return nil
+}
+
+// ScopeNames returns the name of each scope.
+func ScopeNames(scopes []Scope) []string {
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = scope.Name
+	}
+
+	return names
+}
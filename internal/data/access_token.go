@@ -0,0 +1,141 @@
+package data
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/jwt"
+)
+
+var (
+	// ErrTokenExpired is returned when a JWT access token is past its expiry.
+	ErrTokenExpired = errors.New("token has expired")
+
+	// ErrTokenRevoked is returned when a JWT access token has been revoked.
+	ErrTokenRevoked = errors.New("token has been revoked")
+)
+
+const accessTokenType = "at+jwt"
+
+// accessTokenClaims follow the JWT profile for access tokens in RFC 9068.
+type accessTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	ClientID  string `json:"client_id"`
+	Audience  string `json:"aud,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	JWTID     string `json:"jti"`
+
+	Confirmation *confirmationClaim `json:"cnf,omitempty"`
+}
+
+type confirmationClaim struct {
+	Thumbprint string `json:"jkt"`
+}
+
+// SignToken returns a JWT access token for the token, signed with the current
+// signing key. The ShortToken is used as the "jti" claim so that revoking the
+// token works the same as for opaque tokens.
+func (d *Database) SignToken(issuer string, token Token) (string, time.Time, error) {
+	key, err := d.currentSigningKey()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := token.CreatedAt.Add(d.expiry.AccessToken)
+
+	claims := accessTokenClaims{
+		Issuer:    issuer,
+		Subject:   token.Me,
+		ClientID:  token.ClientID,
+		Audience:  token.Resource,
+		Scope:     token.Scope,
+		IssuedAt:  token.CreatedAt.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+		JWTID:     token.ShortToken,
+	}
+	if token.DPoPThumbprint != "" {
+		claims.Confirmation = &confirmationClaim{Thumbprint: token.DPoPThumbprint}
+	}
+
+	signed, err := jwt.Sign(key.Key, jwt.Header{Type: accessTokenType, KeyID: key.ID}, claims)
+
+	return signed, expiresAt, err
+}
+
+func (d *Database) accessToken(t string) (token Token, err error) {
+	keys, err := d.signingKeys()
+	if err != nil {
+		return
+	}
+
+	var claims accessTokenClaims
+	header, err := jwt.Parse(t, func(header jwt.Header) (*ecdsa.PublicKey, error) {
+		for _, key := range keys {
+			if key.ID == header.KeyID {
+				return &key.Key.PublicKey, nil
+			}
+		}
+		return nil, jwt.ErrInvalidSignature
+	}, &claims)
+	if err != nil {
+		return
+	}
+	if header.Type != accessTokenType {
+		return token, jwt.ErrMalformed
+	}
+
+	token = Token{
+		ShortToken: claims.JWTID,
+		Me:         claims.Subject,
+		ClientID:   claims.ClientID,
+		Scope:      claims.Scope,
+		Resource:   claims.Audience,
+		CreatedAt:  time.Unix(claims.IssuedAt, 0).UTC(),
+		ExpiresAt:  time.Unix(claims.ExpiresAt, 0).UTC(),
+	}
+	if claims.Confirmation != nil {
+		token.DPoPThumbprint = claims.Confirmation.Thumbprint
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		return Token{}, ErrTokenExpired
+	}
+
+	revoked, err := d.tokenRevoked(claims.JWTID)
+	if err != nil {
+		return Token{}, err
+	}
+	if revoked {
+		return Token{}, ErrTokenRevoked
+	}
+
+	return token, nil
+}
+
+func (d *Database) tokenRevoked(jti string) (revoked bool, err error) {
+	row := d.db.QueryRow(`SELECT COUNT(*) > 0 FROM revoked_token WHERE JTI = ?`, jti)
+	err = row.Scan(&revoked)
+
+	return
+}
+
+// denyTokens adds the tokens matching the where clause to the list of revoked
+// tokens, so that JWT access tokens that have already been issued are no longer
+// accepted. The entries are only needed until any token could have expired.
+func (d *Database) denyTokens(db execer, where string, args ...interface{}) error {
+	if d.expiry.AccessToken == 0 {
+		return nil
+	}
+
+	args = append([]interface{}{time.Now().UTC().Add(d.expiry.AccessToken)}, args...)
+
+	_, err := db.Exec(`INSERT INTO revoked_token(JTI, ExpiresAt) SELECT ShortToken, ? FROM token WHERE `+where+`
+    ON CONFLICT (JTI) DO UPDATE SET ExpiresAt = excluded.ExpiresAt`,
+		args...)
+
+	return err
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestAccessToken(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{AccessToken: time.Hour, SigningKey: time.Hour})
+	defer db.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	token := Token{
+		ShortToken:    "abcde",
+		LongTokenHash: "xyz",
+		Me:            "http://john.doe.example.com",
+		ClientID:      "http://client.example.com",
+		Scope:         "create media",
+		CreatedAt:     now,
+	}
+	assert(db.CreateToken(token)).Must.Nil()
+
+	signed, expiresAt, err := db.SignToken("http://localhost", token)
+	assert(err).Must.Nil()
+	assert(expiresAt).Equal(now.Add(time.Hour))
+
+	found, err := db.Token(signed)
+	assert(err).Must.Nil()
+	assert(found.ShortToken).Equal("abcde")
+	assert(found.Me).Equal("http://john.doe.example.com")
+	assert(found.ClientID).Equal("http://client.example.com")
+	assert(found.Scope).Equal("create media")
+	assert(found.CreatedAt).Equal(now)
+	assert(found.ExpiresAt).Equal(now.Add(time.Hour))
+
+	keys, err := db.PublicKeys()
+	assert(err).Nil()
+	assert(keys.Keys).Len(1)
+
+	assert(db.RevokeToken("abcde")).Nil()
+
+	_, err = db.Token(signed)
+	assert(err).Equal(ErrTokenRevoked)
+}
+
+func TestAccessTokenWhenExpired(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{AccessToken: time.Hour, SigningKey: time.Hour})
+	defer db.Close()
+
+	signed, _, err := db.SignToken("http://localhost", Token{
+		ShortToken: "abcde",
+		Me:         "http://john.doe.example.com",
+		CreatedAt:  time.Now().Add(-2 * time.Hour),
+	})
+	assert(err).Must.Nil()
+
+	_, err = db.Token(signed)
+	assert(err).Equal(ErrTokenExpired)
+}
+
+func TestAccessTokenAfterForget(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{AccessToken: time.Hour, SigningKey: time.Hour})
+	defer db.Close()
+
+	token := Token{
+		ShortToken: "abcde",
+		Me:         "http://john.doe.example.com",
+		CreatedAt:  time.Now(),
+	}
+	assert(db.CreateToken(token)).Must.Nil()
+
+	signed, _, err := db.SignToken("http://localhost", token)
+	assert(err).Must.Nil()
+
+	assert(db.Forget("http://john.doe.example.com")).Nil()
+
+	_, err = db.Token(signed)
+	assert(err).Equal(ErrTokenRevoked)
+}
+
+func TestRotateSigningKeys(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{AccessToken: time.Hour, SigningKey: time.Hour})
+	defer db.Close()
+
+	assert(db.RotateSigningKeys()).Nil()
+	assert(db.RotateSigningKeys()).Nil()
+
+	keys, _ := db.signingKeys()
+	assert(keys).Len(1)
+
+	old, _ := newSigningKey()
+	old.CreatedAt = time.Now().UTC().Add(-90 * time.Minute)
+	assert(db.createSigningKey(old)).Must.Nil()
+
+	expired, _ := newSigningKey()
+	expired.CreatedAt = time.Now().UTC().Add(-3 * time.Hour)
+	assert(db.createSigningKey(expired)).Must.Nil()
+
+	assert(db.RotateSigningKeys()).Nil()
+
+	keys, _ = db.signingKeys()
+	if assert(keys).Len(2) {
+		assert(keys[1].ID).Equal(old.ID)
+	}
+}
+
+func TestAccessTokenWithDPoP(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{AccessToken: time.Hour, SigningKey: time.Hour})
+	defer db.Close()
+
+	signed, _, err := db.SignToken("http://localhost", Token{
+		ShortToken:     "abcde",
+		Me:             "http://john.doe.example.com",
+		CreatedAt:      time.Now(),
+		DPoPThumbprint: "thumb",
+	})
+	assert(err).Must.Nil()
+
+	found, err := db.Token(signed)
+	assert(err).Must.Nil()
+	assert(found.DPoPThumbprint).Equal("thumb")
+}
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/random"
+)
+
+// The actions that are audited.
+const (
+	// AuditLogin is a user signing-in with a provider.
+	AuditLogin = "login"
+
+	// AuditContinue is a user signing-in by continuing a previous login.
+	AuditContinue = "continue"
+
+	// AuditTokenCreate is a client being issued a token.
+	AuditTokenCreate = "token_create"
+
+	// AuditTokenRevoke is a token being revoked.
+	AuditTokenRevoke = "token_revoke"
+
+	// AuditForget is a user asking for their data to be removed.
+	AuditForget = "forget"
+
+	// AuditLogout is a user signing-out a browser.
+	AuditLogout = "logout"
+
+	// AuditExport is a user downloading their data.
+	AuditExport = "export"
+)
+
+// AuditSuccess is the Outcome of an action that succeeded, any other Outcome
+// describes why the action failed.
+const AuditSuccess = "success"
+
+// AuditEvent records something that happened to a user's authentication, or
+// their tokens.
+type AuditEvent struct {
+	ID        string
+	Action    string
+	Me        string
+	ClientID  string
+	Provider  string
+	IP        string
+	UserAgent string
+	Outcome   string
+	CreatedAt time.Time
+}
+
+// Audit records the event.
+func (d *Database) Audit(event AuditEvent) error {
+	id, err := random.String(16)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := d.encryptAll(event.IP, event.UserAgent)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(`INSERT INTO audit(ID, Action, Me, ClientID, Provider, IP, UserAgent, Outcome, CreatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id,
+		event.Action,
+		event.Me,
+		event.ClientID,
+		event.Provider,
+		encrypted[0],
+		encrypted[1],
+		event.Outcome,
+		event.CreatedAt)
+
+	return err
+}
+
+// RemoteIP returns the IP address the request came from.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// AuditQuery filters the events returned by AuditEvents. Empty fields match
+// any event.
+type AuditQuery struct {
+	Me       string
+	ClientID string
+	Since    time.Time
+
+	// Actions, if given, only matches events with one of the actions.
+	Actions []string
+
+	// Limit is the most events to return, or 0 for no limit.
+	Limit int
+}
+
+// AuditEvents returns the events matching query, newest first.
+func (d *Database) AuditEvents(query AuditQuery) (events []AuditEvent, err error) {
+	var where []string
+	var args []interface{}
+
+	if query.Me != "" {
+		where = append(where, `Me = ?`)
+		args = append(args, query.Me)
+	}
+	if query.ClientID != "" {
+		where = append(where, `ClientID = ?`)
+		args = append(args, query.ClientID)
+	}
+	if len(query.Actions) > 0 {
+		where = append(where, `Action IN (?`+strings.Repeat(`, ?`, len(query.Actions)-1)+`)`)
+		for _, action := range query.Actions {
+			args = append(args, action)
+		}
+	}
+	if !query.Since.IsZero() {
+		where = append(where, `CreatedAt >= ?`)
+		args = append(args, query.Since)
+	}
+
+	sql := `SELECT ID, Action, Me, ClientID, Provider, IP, UserAgent, Outcome, CreatedAt FROM audit`
+	if len(where) > 0 {
+		sql += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	sql += ` ORDER BY CreatedAt DESC`
+	if query.Limit > 0 {
+		sql += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := d.db.Query(sql, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event AuditEvent
+		if err = rows.Scan(
+			&event.ID,
+			&event.Action,
+			&event.Me,
+			&event.ClientID,
+			&event.Provider,
+			&event.IP,
+			&event.UserAgent,
+			&event.Outcome,
+			&event.CreatedAt,
+		); err != nil {
+			return
+		}
+		if err = d.decryptAll(&event.IP, &event.UserAgent); err != nil {
+			return
+		}
+
+		events = append(events, event)
+	}
+
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestAuditEvents(t *testing.T) {
+	eachDatabase(t, Expiry{}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+		for i, event := range []AuditEvent{
+			{Action: AuditLogin, Me: "http://john.doe.example.com", ClientID: "http://client.example.com", Provider: "github", Outcome: AuditSuccess},
+			{Action: AuditTokenCreate, Me: "http://john.doe.example.com", ClientID: "http://client.example.com", Outcome: AuditSuccess},
+			{Action: AuditTokenCreate, Me: "http://jane.doe.example.com", ClientID: "http://other.example.com", Outcome: "invalid_grant"},
+		} {
+			event.IP = "192.0.2.1"
+			event.UserAgent = "test/1.0"
+			event.CreatedAt = now.Add(time.Duration(i) * time.Minute)
+
+			assert(db.Audit(event)).Must.Nil()
+		}
+
+		events, err := db.AuditEvents(AuditQuery{})
+		assert(err).Must.Nil()
+		assert(events).Len(3)
+		assert(events[0].Me).Equal("http://jane.doe.example.com")
+		assert(events[0].Outcome).Equal("invalid_grant")
+		assert(events[0].IP).Equal("192.0.2.1")
+		assert(events[0].UserAgent).Equal("test/1.0")
+		assert(events[0].CreatedAt.Equal(now.Add(2 * time.Minute))).True()
+
+		events, err = db.AuditEvents(AuditQuery{Me: "http://john.doe.example.com"})
+		assert(err).Must.Nil()
+		assert(events).Len(2)
+		assert(events[0].Action).Equal(AuditTokenCreate)
+		assert(events[1].Action).Equal(AuditLogin)
+		assert(events[1].Provider).Equal("github")
+
+		events, err = db.AuditEvents(AuditQuery{Actions: []string{AuditTokenCreate}, ClientID: "http://client.example.com"})
+		assert(err).Must.Nil()
+		assert(events).Len(1)
+
+		events, err = db.AuditEvents(AuditQuery{Since: now.Add(time.Minute), Limit: 1})
+		assert(err).Must.Nil()
+		assert(events).Len(1)
+		assert(events[0].Me).Equal("http://jane.doe.example.com")
+
+		err = db.Forget("http://john.doe.example.com")
+		assert(err).Must.Nil()
+
+		events, err = db.AuditEvents(AuditQuery{})
+		assert(err).Must.Nil()
+		assert(events).Len(1)
+	})
+}
@@ -15,6 +15,7 @@ type Client struct {
 	ID          string
 	RedirectURI string
 	Name        string
+	Logo        string
 	UpdatedAt   time.Time
 	expiresAt   time.Time
 }
@@ -23,7 +24,27 @@ func (c Client) Expired() bool {
 	return time.Now().After(c.expiresAt)
 }
 
+// Client returns the app's information, checking that the redirectURI is
+// allowed. A registered client may only use the redirect URIs it was
+// registered with.
 func (d *Database) Client(clientID, redirectURI string) (Client, error) {
+	if registered, err := d.RegisteredClient(clientID); err != ErrUnknownClient {
+		if err != nil {
+			return Client{}, err
+		}
+		if !registered.allowsRedirectURI(redirectURI) {
+			return Client{}, errors.New("bad redirect_uri")
+		}
+
+		return Client{
+			ID:          registered.ID,
+			RedirectURI: redirectURI,
+			Name:        registered.ID,
+			UpdatedAt:   registered.CreatedAt,
+			expiresAt:   time.Now().Add(d.expiry.Client),
+		}, nil
+	}
+
 	client, err := d.findClient(clientID, redirectURI)
 	if err != nil || client.Expired() {
 		client, err = d.queryClient(clientID, redirectURI)
@@ -36,21 +57,23 @@ func (d *Database) Client(clientID, redirectURI string) (Client, error) {
 }
 
 func (d *Database) cacheClient(client Client) error {
-	_, err := d.db.Exec(`INSERT OR REPLACE INTO client(ClientID, RedirectURI, Name, CreatedAt) VALUES(?, ?, ?, ?)`,
+	_, err := d.db.Exec(`INSERT INTO client(ClientID, RedirectURI, Name, Logo, CreatedAt) VALUES(?, ?, ?, ?, ?)
+    ON CONFLICT (ClientID) DO UPDATE SET RedirectURI = excluded.RedirectURI, Name = excluded.Name, Logo = excluded.Logo, CreatedAt = excluded.CreatedAt`,
 		client.ID,
 		client.RedirectURI,
 		client.Name,
+		client.Logo,
 		client.UpdatedAt)
 
 	return err
 }
 
 func (d *Database) findClient(clientID, redirectURI string) (client Client, err error) {
-	row := d.db.QueryRow(`SELECT ClientID, RedirectURI, Name, CreatedAt FROM client WHERE ClientID = ? AND RedirectURI = ?`,
+	row := d.db.QueryRow(`SELECT ClientID, RedirectURI, Name, Logo, CreatedAt FROM client WHERE ClientID = ? AND RedirectURI = ?`,
 		clientID,
 		redirectURI)
 
-	err = row.Scan(&client.ID, &client.RedirectURI, &client.Name, &client.UpdatedAt)
+	err = row.Scan(&client.ID, &client.RedirectURI, &client.Name, &client.Logo, &client.UpdatedAt)
 	client.expiresAt = client.UpdatedAt.Add(d.expiry.Client)
 
 	return
@@ -98,6 +121,7 @@ func (d *Database) queryClient(clientID, redirectURI string) (client Client, err
 	app, okerr := microformats.ParseApp(clientInfoResp.Body, parsedClientID)
 	if okerr == nil {
 		client.Name = app.Name
+		client.Logo = app.Logo
 	}
 
 	if !redirectOK {
@@ -20,6 +20,7 @@ func TestClient(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		callCount++
 		w.Write([]byte(`<div class="h-x-app">
+  <img class="u-logo" src="/logo.png" />
   <h1 class="p-name">My App</h1>
 </div>`))
 	}))
@@ -39,6 +40,7 @@ func TestClient(t *testing.T) {
 	assert(client.ID).Equal(s.URL)
 	assert(client.RedirectURI).Equal(s.URL + "/callback")
 	assert(client.Name).Equal("My App")
+	assert(client.Logo).Equal(s.URL + "/logo.png")
 	assert(client.UpdatedAt).WithinDuration(time.Now(), time.Second)
 	assert(client.expiresAt).WithinDuration(time.Now().Add(time.Hour), time.Second)
 	assert(callCount).Equal(1)
@@ -21,6 +21,14 @@ type Code struct {
 	Scope               string
 	CreatedAt           time.Time
 	ExpiresAt           time.Time
+
+	// Resource is only set when a token is being issued for a ticket or another
+	// token, it is the URL that the token grants access to.
+	Resource string
+
+	// ParentToken is only set when a token is being issued in exchange for
+	// another token, it is the ShortToken of that token.
+	ParentToken string
 }
 
 // Expired returns true if the Code was created over 60 seconds ago.
@@ -47,9 +55,11 @@ func (c Code) VerifyChallenge(verifier string) (bool, error) {
 	}
 }
 
+// CreateCode sets the code for the session started by me. Only a hash of the
+// code is stored, like the long part of a token.
 func (d *Database) CreateCode(me, code string, createdAt time.Time) error {
 	_, err := d.db.Exec(`UPDATE session SET Code = ?, CreatedAt = ? WHERE Me = ?`,
-		code,
+		hashToken(code),
 		createdAt,
 		me)
 
@@ -57,11 +67,10 @@ func (d *Database) CreateCode(me, code string, createdAt time.Time) error {
 }
 
 func (d *Database) Code(c string) (code Code, err error) {
-	row := d.db.QueryRow(`SELECT Code, ResponseType, Me, ClientID, RedirectURI, CodeChallenge, CodeChallengeMethod, Scope, CreatedAt FROM session WHERE Code = ?`,
-		c)
+	row := d.db.QueryRow(`SELECT ResponseType, Me, ClientID, RedirectURI, CodeChallenge, CodeChallengeMethod, Scope, CreatedAt FROM session WHERE Code = ?`,
+		hashToken(c))
 
 	err = row.Scan(
-		&code.Code,
 		&code.ResponseType,
 		&code.Me,
 		&code.ClientID,
@@ -74,8 +83,13 @@ func (d *Database) Code(c string) (code Code, err error) {
 		return
 	}
 
+	if err = d.decryptAll(&code.CodeChallenge); err != nil {
+		return
+	}
+
+	code.Code = c
 	code.ExpiresAt = code.CreatedAt.Add(d.expiry.Code)
 
-	_, err = d.db.Exec(`DELETE FROM session WHERE Code = ?`, c)
+	_, err = d.db.Exec(`DELETE FROM session WHERE Code = ?`, hashToken(c))
 	return
 }
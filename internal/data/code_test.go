@@ -159,3 +159,32 @@ func TestCodeVerifyChallenge(t *testing.T) {
 		})
 	}
 }
+
+func TestCodeIsStoredHashed(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{Code: time.Hour})
+	defer db.Close()
+
+	now := time.Now()
+
+	err := db.CreateSession(Session{
+		ResponseType: "code",
+		Me:           "http://john.doe.example.com",
+		ClientID:     "http://client.example.com",
+		RedirectURI:  "http://client.example.com/callback",
+		CreatedAt:    now,
+	})
+	assert(err).Must.Nil()
+
+	err = db.CreateCode("http://john.doe.example.com", "abcde", now)
+	assert(err).Must.Nil()
+
+	var stored string
+	err = db.db.QueryRow(`SELECT Code FROM session WHERE Me = ?`, "http://john.doe.example.com").Scan(&stored)
+	assert(err).Must.Nil()
+	assert(stored).Equal(hashToken("abcde"))
+
+	_, err = db.Code(stored)
+	assert(err).Equal(sql.ErrNoRows)
+}
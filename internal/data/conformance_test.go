@@ -0,0 +1,197 @@
+package data
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+// eachDatabase runs f against an in-memory SQLite database and, if
+// RELME_AUTH_TEST_POSTGRES is set to a postgres:// URL, against that database
+// after emptying it. Both are opened with expiry.
+func eachDatabase(t *testing.T, expiry Expiry, f func(t *testing.T, db *Database)) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, expiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		f(t, db)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("RELME_AUTH_TEST_POSTGRES")
+		if dsn == "" {
+			t.Skip("RELME_AUTH_TEST_POSTGRES not set")
+		}
+
+		db, err := Open(dsn, http.DefaultClient, &fakeCookieStore{}, expiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		for i := len(tables) - 1; i >= 0; i-- {
+			if _, err := db.db.Exec(`DELETE FROM ` + tables[i].name); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		f(t, db)
+	})
+}
+
+func TestConformanceReplaceSession(t *testing.T) {
+	eachDatabase(t, Expiry{}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+		err := db.CreateSession(Session{
+			ResponseType: "code",
+			Me:           "http://john.doe.example.com",
+			ClientID:     "http://client.example.com",
+			RedirectURI:  "http://client.example.com/callback",
+			State:        "abcde",
+			CreatedAt:    now,
+		})
+		assert(err).Must.Nil()
+
+		err = db.CreateCode("http://john.doe.example.com", "1234", now)
+		assert(err).Must.Nil()
+
+		err = db.CreateSession(Session{
+			ResponseType: "id",
+			Me:           "http://john.doe.example.com",
+			ClientID:     "http://other.example.com",
+			RedirectURI:  "http://other.example.com/callback",
+			State:        "fghij",
+			CreatedAt:    now.Add(time.Minute),
+		})
+		assert(err).Must.Nil()
+
+		session, err := db.Session("http://john.doe.example.com")
+		assert(err).Must.Nil()
+		assert(session.ResponseType).Equal("id")
+		assert(session.ClientID).Equal("http://other.example.com")
+		assert(session.State).Equal("fghij")
+		assert(session.CreatedAt.Equal(now.Add(time.Minute))).True()
+
+		_, err = db.Code("1234")
+		assert(err).Equal(sql.ErrNoRows)
+	})
+}
+
+func TestConformanceCacheProfile(t *testing.T) {
+	eachDatabase(t, Expiry{}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+		err := db.CacheProfile(Profile{
+			Me:        "http://john.doe.example.com",
+			UpdatedAt: now,
+			Methods: []Method{
+				{Provider: "someone", Profile: "http://someone.example.com/john.doe"},
+				{Provider: "else", Profile: "http://else.example.com/john.doe"},
+			},
+		})
+		assert(err).Must.Nil()
+
+		err = db.CacheProfile(Profile{
+			Me:        "http://john.doe.example.com",
+			UpdatedAt: now.Add(time.Hour),
+			Methods: []Method{
+				{Provider: "other", Profile: "http://other.example.com/john.doe"},
+			},
+		})
+		assert(err).Must.Nil()
+
+		profile, err := db.Profile("http://john.doe.example.com")
+		assert(err).Must.Nil()
+		assert(profile.UpdatedAt.Equal(now.Add(time.Hour))).True()
+		assert(profile.Methods).Equal([]Method{
+			{Provider: "other", Profile: "http://other.example.com/john.doe"},
+		})
+
+		err = db.Forget("http://john.doe.example.com")
+		assert(err).Must.Nil()
+
+		_, err = db.Profile("http://john.doe.example.com")
+		assert(err).Equal(sql.ErrNoRows)
+	})
+}
+
+func TestCopy(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	src, err := Open("file:copysrc?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
+	assert(err).Must.Nil()
+	defer src.Close()
+
+	dst, err := Open("file:copydst?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
+	assert(err).Must.Nil()
+	defer dst.Close()
+
+	now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	err = src.CacheProfile(Profile{
+		Me:        "http://john.doe.example.com",
+		UpdatedAt: now,
+		Methods: []Method{
+			{Provider: "someone", Profile: "http://someone.example.com/john.doe"},
+		},
+	})
+	assert(err).Must.Nil()
+
+	err = src.CreateToken(Token{
+		ShortToken:    "abcde",
+		LongTokenHash: "xyz",
+		Me:            "http://john.doe.example.com",
+		ClientID:      "http://client.example.com",
+		Scope:         "create",
+		CreatedAt:     now,
+	})
+	assert(err).Must.Nil()
+
+	err = dst.Copy(src)
+	assert(err).Must.Nil()
+
+	profile, err := dst.Profile("http://john.doe.example.com")
+	assert(err).Must.Nil()
+	assert(profile.UpdatedAt).Equal(now)
+	assert(profile.Methods).Equal([]Method{
+		{Provider: "someone", Profile: "http://someone.example.com/john.doe"},
+	})
+
+	tokens, err := dst.Tokens("http://john.doe.example.com")
+	assert(err).Must.Nil()
+	assert(tokens).Len(1)
+	assert(tokens[0].ShortToken).Equal("abcde")
+	assert(tokens[0].Scope).Equal("create")
+	assert(tokens[0].CreatedAt).Equal(now)
+}
+
+func TestNumberedPlaceholders(t *testing.T) {
+	testCases := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT a FROM b WHERE c = ?", "SELECT a FROM b WHERE c = $1"},
+		{"INSERT INTO b(c, d) VALUES (?, ?)", "INSERT INTO b(c, d) VALUES ($1, $2)"},
+		{"SELECT a FROM b WHERE c = '?' AND d = ?", "SELECT a FROM b WHERE c = '?' AND d = $1"},
+		{`SELECT "a?" FROM b WHERE c = ?`, `SELECT "a?" FROM b WHERE c = $1`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			assert.Equal(t, tc.want, numberedPlaceholders(tc.query))
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// DeviceAuthorization tracks a request from a device, that can't receive a
+// redirect, for an access token. The user approves the request on another
+// device by entering the UserCode, while the device polls using the DeviceCode.
+type DeviceAuthorization struct {
+	// DeviceCode is only stored hashed, so is only set when the request is read
+	// by PollDeviceAuthorization.
+	DeviceCode   string
+	UserCode     string
+	ClientID     string
+	Scope        string
+	Me           string
+	Denied       bool
+	CreatedAt    time.Time
+	LastPolledAt time.Time
+	ExpiresAt    time.Time
+}
+
+func (a DeviceAuthorization) Expired() bool {
+	return time.Now().After(a.ExpiresAt)
+}
+
+// Approved returns true if a user has signed-in to approve the request.
+func (a DeviceAuthorization) Approved() bool {
+	return a.Me != "" && !a.Denied
+}
+
+// NormalizeUserCode removes any characters a user may have added when typing a
+// user code, such as separators or lowercase letters.
+func NormalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return -1
+	}, userCode)
+}
+
+func (d *Database) CreateDeviceAuthorization(auth DeviceAuthorization) error {
+	_, err := d.db.Exec(`
+    INSERT INTO device_authorization(DeviceCode, UserCode, ClientID, Scope, Me, Denied, CreatedAt, LastPolledAt)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+  `,
+		hashToken(auth.DeviceCode),
+		auth.UserCode,
+		auth.ClientID,
+		auth.Scope,
+		"",
+		false,
+		auth.CreatedAt,
+		auth.CreatedAt)
+
+	return err
+}
+
+func (d *Database) scanDeviceAuthorization(row *sql.Row) (auth DeviceAuthorization, err error) {
+	err = row.Scan(
+		&auth.DeviceCode,
+		&auth.UserCode,
+		&auth.ClientID,
+		&auth.Scope,
+		&auth.Me,
+		&auth.Denied,
+		&auth.CreatedAt,
+		&auth.LastPolledAt)
+	auth.ExpiresAt = auth.CreatedAt.Add(d.expiry.DeviceCode)
+
+	return
+}
+
+// DeviceAuthorization returns the request for the user code, so that the user
+// can approve or deny it.
+func (d *Database) DeviceAuthorization(userCode string) (DeviceAuthorization, error) {
+	row := d.db.QueryRow(`
+    SELECT DeviceCode, UserCode, ClientID, Scope, Me, Denied, CreatedAt, LastPolledAt
+    FROM device_authorization
+    WHERE UserCode = ?`,
+		NormalizeUserCode(userCode))
+
+	auth, err := d.scanDeviceAuthorization(row)
+	auth.DeviceCode = ""
+	return auth, err
+}
+
+func (d *Database) ApproveDeviceAuthorization(userCode, me string) error {
+	_, err := d.db.Exec(`UPDATE device_authorization SET Me = ? WHERE UserCode = ? AND Denied = ?`,
+		me,
+		NormalizeUserCode(userCode),
+		false)
+
+	return err
+}
+
+func (d *Database) DenyDeviceAuthorization(userCode string) error {
+	_, err := d.db.Exec(`UPDATE device_authorization SET Denied = ? WHERE UserCode = ?`,
+		true,
+		NormalizeUserCode(userCode))
+
+	return err
+}
+
+// PollDeviceAuthorization returns the request for the device code as it was
+// before this poll, then records the time of the poll. If the request has been
+// approved it is removed, so that only one token can be issued for it, and any
+// other poll for it returns sql.ErrNoRows.
+func (d *Database) PollDeviceAuthorization(deviceCode string) (auth DeviceAuthorization, err error) {
+	row := d.db.QueryRow(`
+    SELECT DeviceCode, UserCode, ClientID, Scope, Me, Denied, CreatedAt, LastPolledAt
+    FROM device_authorization
+    WHERE DeviceCode = ?`,
+		hashToken(deviceCode))
+
+	auth, err = d.scanDeviceAuthorization(row)
+	if err != nil {
+		return
+	}
+	auth.DeviceCode = deviceCode
+
+	if auth.Approved() {
+		// only the poll that removes the request may use it, as another may have
+		// read it at the same time
+		result, err := d.db.Exec(`DELETE FROM device_authorization WHERE DeviceCode = ?`, hashToken(deviceCode))
+		if err != nil {
+			return DeviceAuthorization{}, err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return DeviceAuthorization{}, err
+		}
+		if n == 0 {
+			return DeviceAuthorization{}, sql.ErrNoRows
+		}
+
+		return auth, nil
+	}
+
+	_, err = d.db.Exec(`UPDATE device_authorization SET LastPolledAt = ? WHERE DeviceCode = ?`,
+		time.Now().UTC(),
+		hashToken(deviceCode))
+	return
+}
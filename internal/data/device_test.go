@@ -0,0 +1,123 @@
+package data
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestDeviceAuthorization(t *testing.T) {
+	eachDatabase(t, Expiry{DeviceCode: time.Minute}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		now := time.Now().UTC()
+
+		err := db.CreateDeviceAuthorization(DeviceAuthorization{
+			DeviceCode: "device",
+			UserCode:   "BCDFGHJK",
+			ClientID:   "http://client.example.com/",
+			Scope:      "create",
+			CreatedAt:  now,
+		})
+		assert(err).Must.Nil()
+
+		var stored string
+		assert(db.db.QueryRow(`SELECT DeviceCode FROM device_authorization`).Scan(&stored)).Must.Nil()
+		assert(stored != "device").True()
+
+		auth, err := db.DeviceAuthorization("bcdf-ghjk")
+		assert(err).Must.Nil()
+		assert(auth.DeviceCode).Equal("")
+		assert(auth.ClientID).Equal("http://client.example.com/")
+		assert(auth.Scope).Equal("create")
+		assert(auth.Expired()).False()
+		assert(auth.Approved()).False()
+
+		auth, err = db.PollDeviceAuthorization("device")
+		assert(err).Must.Nil()
+		assert(auth.DeviceCode).Equal("device")
+		assert(auth.Approved()).False()
+		assert(auth.LastPolledAt).WithinDuration(now, 10*time.Millisecond)
+
+		assert(db.ApproveDeviceAuthorization("BCDF-GHJK", "https://me.example.com/")).Must.Nil()
+
+		auth, err = db.PollDeviceAuthorization("device")
+		assert(err).Must.Nil()
+		assert(auth.Approved()).True()
+		assert(auth.Me).Equal("https://me.example.com/")
+
+		_, err = db.PollDeviceAuthorization("device")
+		assert(err).Equal(sql.ErrNoRows)
+	})
+}
+
+func TestDeviceAuthorizationPolledConcurrently(t *testing.T) {
+	eachDatabase(t, Expiry{DeviceCode: time.Minute}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		err := db.CreateDeviceAuthorization(DeviceAuthorization{
+			DeviceCode: "device",
+			UserCode:   "BCDFGHJK",
+			ClientID:   "http://client.example.com/",
+			CreatedAt:  time.Now().UTC(),
+		})
+		assert(err).Must.Nil()
+		assert(db.ApproveDeviceAuthorization("BCDFGHJK", "https://me.example.com/")).Must.Nil()
+
+		var wg sync.WaitGroup
+		approved := make(chan bool, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				auth, err := db.PollDeviceAuthorization("device")
+				approved <- err == nil && auth.Approved()
+			}()
+		}
+		wg.Wait()
+		close(approved)
+
+		successes := 0
+		for ok := range approved {
+			if ok {
+				successes++
+			}
+		}
+		assert(successes <= 1).True()
+
+		_, err = db.PollDeviceAuthorization("device")
+		assert(err).Equal(sql.ErrNoRows)
+	})
+}
+
+func TestDeviceAuthorizationWhenDenied(t *testing.T) {
+	eachDatabase(t, Expiry{DeviceCode: time.Minute}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		err := db.CreateDeviceAuthorization(DeviceAuthorization{
+			DeviceCode: "device",
+			UserCode:   "BCDFGHJK",
+			ClientID:   "http://client.example.com/",
+			CreatedAt:  time.Now().UTC(),
+		})
+		assert(err).Must.Nil()
+
+		assert(db.DenyDeviceAuthorization("BCDFGHJK")).Must.Nil()
+		assert(db.ApproveDeviceAuthorization("BCDFGHJK", "https://me.example.com/")).Must.Nil()
+
+		auth, err := db.PollDeviceAuthorization("device")
+		assert(err).Must.Nil()
+		assert(auth.Denied).True()
+		assert(auth.Approved()).False()
+	})
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	assert(NormalizeUserCode("bcdf-ghjk")).Equal("BCDFGHJK")
+	assert(NormalizeUserCode(" BCDF GHJK ")).Equal("BCDFGHJK")
+}
@@ -0,0 +1,139 @@
+package data
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// dialect describes the differences between the databases that can be used.
+// Queries are written for SQLite, using "?" placeholders and the types below,
+// and are rewritten when needed.
+type dialect struct {
+	driver string
+
+	// rebind rewrites the placeholders in a query.
+	rebind func(query string) string
+
+	// types maps the column types used in the schema to those the database
+	// understands.
+	types *strings.Replacer
+
+	// legacyVersion returns the number of statements that had been applied by
+	// the schema versioning used before migrations, and clearLegacyVersion
+	// removes it once the migrations have taken over.
+	legacyVersion      func(db *sql.DB) (int, error)
+	clearLegacyVersion func(tx sqlTx) error
+}
+
+var sqliteDialect = dialect{
+	driver: "sqlite3",
+	rebind: func(query string) string { return query },
+	types:  strings.NewReplacer(),
+	legacyVersion: func(db *sql.DB) (int, error) {
+		var version int
+		err := db.QueryRow("PRAGMA user_version").Scan(&version)
+		return version, err
+	},
+	clearLegacyVersion: func(tx sqlTx) error {
+		_, err := tx.Exec("PRAGMA user_version = 0")
+		return err
+	},
+}
+
+var postgresDialect = dialect{
+	driver: "postgres",
+	rebind: numberedPlaceholders,
+	types: strings.NewReplacer(
+		"DATETIME", "TIMESTAMP WITH TIME ZONE",
+		"BLOB", "BYTEA",
+	),
+	// the schema versioning used before migrations was only ever used with
+	// SQLite
+	legacyVersion:      func(db *sql.DB) (int, error) { return 0, nil },
+	clearLegacyVersion: func(tx sqlTx) error { return nil },
+}
+
+// dialectFor returns the dialect to use for the dsn given to Open. A URL
+// starting postgres:// or postgresql:// uses PostgreSQL, anything else is the
+// path to a SQLite database.
+func dialectFor(dsn string) dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return postgresDialect
+	}
+
+	return sqliteDialect
+}
+
+// numberedPlaceholders replaces each "?" in query, that is not within quotes,
+// with "$1", "$2", and so on.
+func numberedPlaceholders(query string) string {
+	var b strings.Builder
+	var quote rune
+	n := 0
+
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// execer is implemented by sqlDB and sqlTx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlDB wraps a *sql.DB so that queries are rebound for the dialect.
+type sqlDB struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db sqlDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) Begin() (sqlTx, error) {
+	tx, err := db.DB.Begin()
+	return sqlTx{Tx: tx, dialect: db.dialect}, err
+}
+
+// sqlTx wraps a *sql.Tx so that queries are rebound for the dialect.
+type sqlTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx sqlTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx sqlTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx sqlTx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.Tx.Prepare(tx.dialect.rebind(query))
+}
@@ -0,0 +1,270 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrNoEncryptionKey is returned when reading a value that was encrypted with
+	// a key that has not been given.
+	ErrNoEncryptionKey = errors.New("value was encrypted with an unknown key")
+
+	// ErrBadEncryptionKey is returned when an encryption key is not 32 bytes.
+	ErrBadEncryptionKey = errors.New("encryption key must be 32 bytes")
+)
+
+// encryptedPrefix starts every encrypted value, so that values written before
+// a key was configured can still be read.
+const encryptedPrefix = "enc:"
+
+// keyring encrypts values with its current key, and decrypts values with
+// whichever of its keys they name.
+type keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+func newKeyring(keys [][]byte) (*keyring, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	ring := &keyring{keys: map[string]cipher.AEAD{}}
+	for i, key := range keys {
+		if len(key) != 32 {
+			return nil, ErrBadEncryptionKey
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := keyID(key)
+		ring.keys[id] = aead
+		if i == 0 {
+			ring.current = id
+		}
+	}
+
+	return ring, nil
+}
+
+// keyID identifies a key without revealing it.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+
+	return hex.EncodeToString(sum[:4])
+}
+
+// SetEncryptionKeys sets the keys used to encrypt sensitive columns. New values
+// are encrypted with the first key, the others are only used to read values
+// written before the keys were rotated. With no keys, values are stored as
+// they are.
+func (d *Database) SetEncryptionKeys(keys [][]byte) error {
+	ring, err := newKeyring(keys)
+	if err != nil {
+		return err
+	}
+
+	d.keys = ring
+	return nil
+}
+
+// encrypt returns the value to store for plaintext.
+func (d *Database) encrypt(plaintext string) (string, error) {
+	if d.keys == nil || plaintext == "" {
+		return plaintext, nil
+	}
+
+	aead := d.keys.keys[d.keys.current]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+
+	return encryptedPrefix + d.keys.current + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt returns the plaintext for a stored value.
+func (d *Database) decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	if d.keys == nil {
+		return "", ErrNoEncryptionKey
+	}
+	aead, ok := d.keys.keys[parts[0]]
+	if !ok {
+		return "", ErrNoEncryptionKey
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// decryptAll replaces each value with its plaintext.
+func (d *Database) decryptAll(values ...*string) error {
+	for _, value := range values {
+		plaintext, err := d.decrypt(*value)
+		if err != nil {
+			return err
+		}
+		*value = plaintext
+	}
+
+	return nil
+}
+
+// encryptAll returns the values to store for each plaintext.
+func (d *Database) encryptAll(plaintexts ...string) ([]string, error) {
+	values := make([]string, len(plaintexts))
+	for i, plaintext := range plaintexts {
+		value, err := d.encrypt(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+// Reencrypt rewrites every encrypted column with the current key, so that
+// older keys can be removed. Values stored before a key was configured are
+// encrypted too. It returns the number of values rewritten.
+func (d *Database) Reencrypt() (int64, error) {
+	if d.keys == nil {
+		return 0, ErrNoEncryptionKey
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for _, t := range tables {
+		if len(t.encrypted) == 0 {
+			continue
+		}
+
+		n, err := d.reencryptTable(tx, t)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		count += n
+	}
+
+	return count, tx.Commit()
+}
+
+func (d *Database) reencryptTable(tx sqlTx, t table) (int64, error) {
+	columns := append(append([]string{}, t.key...), t.encrypted...)
+
+	rows, err := tx.Query(`SELECT ` + strings.Join(columns, ", ") + ` FROM ` + t.name)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	var updates [][]interface{}
+	for rows.Next() {
+		keys := make([]string, len(t.key))
+		values := make([]sql.NullString, len(t.encrypted))
+		dest := make([]interface{}, 0, len(columns))
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		args := make([]interface{}, 0, len(columns))
+		for _, value := range values {
+			if !value.Valid {
+				args = append(args, nil)
+				continue
+			}
+
+			plaintext, err := d.decrypt(value.String)
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+			encrypted, err := d.encrypt(plaintext)
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+			args = append(args, encrypted)
+			count++
+		}
+		for _, key := range keys {
+			args = append(args, key)
+		}
+		updates = append(updates, args)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	set := make([]string, len(t.encrypted))
+	for i, column := range t.encrypted {
+		set[i] = column + ` = ?`
+	}
+	where := make([]string, len(t.key))
+	for i, column := range t.key {
+		where[i] = column + ` = ?`
+	}
+	query := `UPDATE ` + t.name + ` SET ` + strings.Join(set, ", ") + ` WHERE ` + strings.Join(where, " AND ")
+
+	for _, args := range updates {
+		if _, err := tx.Exec(query, args...); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
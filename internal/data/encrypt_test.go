@@ -0,0 +1,123 @@
+package data
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func storedState(db *Database, me string) (state string, err error) {
+	err = db.db.QueryRow(`SELECT State FROM session WHERE Me = ?`, me).Scan(&state)
+	return
+}
+
+func TestEncryptedSession(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
+	defer db.Close()
+
+	err := db.SetEncryptionKeys([][]byte{newKey})
+	assert(err).Must.Nil()
+
+	err = db.CreateSession(Session{
+		Me:            "http://john.doe.example.com",
+		CodeChallenge: "some-base64",
+		State:         "abcde",
+		CreatedAt:     time.Now(),
+	})
+	assert(err).Must.Nil()
+
+	err = db.SetProvider("http://john.doe.example.com", "someone", "http://someone.example.com/john.doe")
+	assert(err).Must.Nil()
+
+	state, err := storedState(db, "http://john.doe.example.com")
+	assert(err).Must.Nil()
+	assert(strings.HasPrefix(state, "enc:")).True()
+	assert(strings.Contains(state, "abcde")).False()
+
+	session, err := db.Session("http://john.doe.example.com")
+	assert(err).Must.Nil()
+	assert(session.CodeChallenge).Equal("some-base64")
+	assert(session.State).Equal("abcde")
+	assert(session.ProfileURI).Equal("http://someone.example.com/john.doe")
+
+	err = db.SetEncryptionKeys(nil)
+	assert(err).Must.Nil()
+
+	_, err = db.Session("http://john.doe.example.com")
+	assert(err).Equal(ErrNoEncryptionKey)
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
+	defer db.Close()
+
+	err := db.CreateSession(Session{Me: "http://plain.example.com", State: "plain", CreatedAt: time.Now()})
+	assert(err).Must.Nil()
+
+	err = db.SetEncryptionKeys([][]byte{oldKey})
+	assert(err).Must.Nil()
+
+	err = db.CreateSession(Session{Me: "http://old.example.com", State: "old", CreatedAt: time.Now()})
+	assert(err).Must.Nil()
+
+	err = db.CacheProfile(Profile{
+		Me:        "http://old.example.com",
+		UpdatedAt: time.Now(),
+		Methods:   []Method{{Provider: "someone", Profile: "http://someone.example.com/old"}},
+	})
+	assert(err).Must.Nil()
+
+	err = db.SetEncryptionKeys([][]byte{newKey, oldKey})
+	assert(err).Must.Nil()
+
+	session, err := db.Session("http://old.example.com")
+	assert(err).Must.Nil()
+	assert(session.State).Equal("old")
+
+	session, err = db.Session("http://plain.example.com")
+	assert(err).Must.Nil()
+	assert(session.State).Equal("plain")
+
+	count, err := db.Reencrypt()
+	assert(err).Must.Nil()
+	assert(count > 0).True()
+
+	state, err := storedState(db, "http://plain.example.com")
+	assert(err).Must.Nil()
+	assert(strings.HasPrefix(state, "enc:"+keyID(newKey)+":")).True()
+
+	err = db.SetEncryptionKeys([][]byte{newKey})
+	assert(err).Must.Nil()
+
+	session, err = db.Session("http://old.example.com")
+	assert(err).Must.Nil()
+	assert(session.State).Equal("old")
+
+	session, err = db.Session("http://plain.example.com")
+	assert(err).Must.Nil()
+	assert(session.State).Equal("plain")
+
+	profile, err := db.Profile("http://old.example.com")
+	assert(err).Must.Nil()
+	assert(profile.Methods[0].Profile).Equal("http://someone.example.com/old")
+}
+
+func TestSetEncryptionKeysWithBadKey(t *testing.T) {
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
+	defer db.Close()
+
+	assert.Equal(t, ErrBadEncryptionKey, db.SetEncryptionKeys([][]byte{[]byte("short")}))
+}
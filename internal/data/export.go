@@ -0,0 +1,90 @@
+package data
+
+import (
+	"strings"
+	"time"
+)
+
+// Export is all of the data stored for a user.
+type Export struct {
+	Me        string    `json:"me"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Tables has the user's rows from each table that has a column for them,
+	// as a map of column name to value. Columns that hold secrets are left out,
+	// and encrypted columns are decrypted.
+	Tables map[string][]map[string]interface{} `json:"tables"`
+}
+
+// Export returns all data stored for me, from every table that has a column for
+// it. These are the same rows that Forget removes.
+func (d *Database) Export(me string) (Export, error) {
+	export := Export{
+		Me:        me,
+		CreatedAt: time.Now().UTC(),
+		Tables:    map[string][]map[string]interface{}{},
+	}
+
+	for _, t := range tables {
+		if t.me == "" {
+			continue
+		}
+
+		rows, err := d.exportTable(t, me)
+		if err != nil {
+			return Export{}, err
+		}
+		export.Tables[t.name] = rows
+	}
+
+	return export, nil
+}
+
+func (d *Database) exportTable(t table, me string) ([]map[string]interface{}, error) {
+	var columns []string
+	for _, column := range t.columns {
+		if !containsString(t.secret, column) {
+			columns = append(columns, column)
+		}
+	}
+
+	rows, err := d.db.Query(`SELECT `+strings.Join(columns, ", ")+` FROM `+t.name+` WHERE `+t.me+` = ?`, me)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	exported := []map[string]interface{}{}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			value := values[i]
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+
+			if s, ok := value.(string); ok && containsString(t.encrypted, column) {
+				plaintext, err := d.decrypt(s)
+				if err != nil {
+					return nil, err
+				}
+				value = plaintext
+			}
+
+			row[column] = value
+		}
+		exported = append(exported, row)
+	}
+
+	return exported, rows.Err()
+}
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestExport(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
+	defer db.Close()
+	assert(db.SetEncryptionKeys([][]byte{[]byte("0123456789abcdef0123456789abcdef")})).Must.Nil()
+
+	now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	for _, me := range []string{"http://john.doe.example.com", "http://someone.example.com"} {
+		err := db.CacheProfile(Profile{
+			Me:        me,
+			UpdatedAt: now,
+			Methods:   []Method{{Provider: "github", Profile: "https://github.com/john"}},
+		})
+		assert(err).Must.Nil()
+
+		err = db.CreateToken(Token{ShortToken: me, LongTokenHash: "secret", Me: me, ClientID: "http://client.example.com", Scope: "create", CreatedAt: now})
+		assert(err).Must.Nil()
+
+		err = db.Audit(AuditEvent{Action: AuditLogin, Me: me, IP: "192.0.2.1", Outcome: AuditSuccess, CreatedAt: now})
+		assert(err).Must.Nil()
+	}
+
+	export, err := db.Export("http://john.doe.example.com")
+	assert(err).Must.Nil()
+	assert(export.Me).Equal("http://john.doe.example.com")
+
+	for _, t := range tables {
+		_, ok := export.Tables[t.name]
+		assert(ok).Equal(t.me != "")
+	}
+
+	if assert(export.Tables["method"]).Len(1) {
+		assert(export.Tables["method"][0]["Provider"]).Equal("github")
+		assert(export.Tables["method"][0]["Profile"]).Equal("https://github.com/john")
+	}
+
+	if assert(export.Tables["token"]).Len(1) {
+		assert(export.Tables["token"][0]["ShortToken"]).Equal("http://john.doe.example.com")
+		assert(export.Tables["token"][0]["Scope"]).Equal("create")
+		_, ok := export.Tables["token"][0]["LongTokenHash"]
+		assert(ok).False()
+	}
+
+	if assert(export.Tables["audit"]).Len(1) {
+		assert(export.Tables["audit"][0]["IP"]).Equal("192.0.2.1")
+	}
+
+	assert(export.Tables["session"]).Len(0)
+}
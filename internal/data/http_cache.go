@@ -0,0 +1,78 @@
+package data
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// CachedResponse is a response to a GET request that can be used again while it
+// is fresh, or revalidated with the ETag and LastModified values after.
+type CachedResponse struct {
+	Key          string
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	ETag         string
+	LastModified string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// Fresh returns true if the response can be used without revalidating it.
+func (r CachedResponse) Fresh() bool {
+	return time.Now().Before(r.ExpiresAt)
+}
+
+func (d *Database) CacheResponse(response CachedResponse) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(`INSERT INTO http_cache(Key, StatusCode, Header, Body, ETag, LastModified, CreatedAt, ExpiresAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT (Key) DO UPDATE SET
+      StatusCode = excluded.StatusCode,
+      Header = excluded.Header,
+      Body = excluded.Body,
+      ETag = excluded.ETag,
+      LastModified = excluded.LastModified,
+      CreatedAt = excluded.CreatedAt,
+      ExpiresAt = excluded.ExpiresAt`,
+		response.Key,
+		response.StatusCode,
+		string(header),
+		response.Body,
+		response.ETag,
+		response.LastModified,
+		response.CreatedAt,
+		response.ExpiresAt)
+
+	return err
+}
+
+// CachedResponse returns the response stored for key. Responses that were
+// stored longer ago than the HTTPCache expiry are not returned, even if they
+// could be revalidated.
+func (d *Database) CachedResponse(key string) (response CachedResponse, err error) {
+	row := d.db.QueryRow(`SELECT Key, StatusCode, Header, Body, ETag, LastModified, CreatedAt, ExpiresAt FROM http_cache WHERE Key = ? AND CreatedAt > ?`,
+		key,
+		time.Now().UTC().Add(-d.expiry.HTTPCache))
+
+	var header string
+	if err = row.Scan(
+		&response.Key,
+		&response.StatusCode,
+		&header,
+		&response.Body,
+		&response.ETag,
+		&response.LastModified,
+		&response.CreatedAt,
+		&response.ExpiresAt,
+	); err != nil {
+		return
+	}
+
+	err = json.Unmarshal([]byte(header), &response.Header)
+	return
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestCachedResponse(t *testing.T) {
+	eachDatabase(t, Expiry{HTTPCache: time.Hour}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		now := time.Now().UTC()
+
+		err := db.CacheResponse(CachedResponse{
+			Key:          "https://example.com/ text/html",
+			StatusCode:   http.StatusOK,
+			Header:       http.Header{"Content-Type": {"text/html"}},
+			Body:         []byte("<p>hey</p>"),
+			ETag:         `"abc"`,
+			LastModified: "Mon, 02 Jan 2006 15:04:05 GMT",
+			CreatedAt:    now,
+			ExpiresAt:    now.Add(time.Minute),
+		})
+		assert(err).Must.Nil()
+
+		response, err := db.CachedResponse("https://example.com/ text/html")
+		assert(err).Must.Nil()
+		assert(response.StatusCode).Equal(http.StatusOK)
+		assert(response.Header.Get("Content-Type")).Equal("text/html")
+		assert(string(response.Body)).Equal("<p>hey</p>")
+		assert(response.ETag).Equal(`"abc"`)
+		assert(response.LastModified).Equal("Mon, 02 Jan 2006 15:04:05 GMT")
+		assert(response.CreatedAt).WithinDuration(now, 10*time.Millisecond)
+		assert(response.Fresh()).True()
+
+		_, err = db.CachedResponse("https://example.com/ application/json")
+		assert(err).Equal(sql.ErrNoRows)
+	})
+}
+
+func TestCachedResponseWhenTooOld(t *testing.T) {
+	eachDatabase(t, Expiry{HTTPCache: time.Hour}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		now := time.Now().UTC()
+
+		err := db.CacheResponse(CachedResponse{
+			Key:        "https://example.com/old",
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			ETag:       `"abc"`,
+			CreatedAt:  now.Add(-2 * time.Hour),
+			ExpiresAt:  now.Add(-2 * time.Hour),
+		})
+		assert(err).Must.Nil()
+
+		_, err = db.CachedResponse("https://example.com/old")
+		assert(err).Equal(sql.ErrNoRows)
+	})
+}
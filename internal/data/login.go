@@ -10,35 +10,49 @@ import (
 
 var ErrNoLogin = errors.New("no login exists")
 
+// Login is a browser that has signed-in to relme-auth. A user can have a Login
+// for each browser they use.
 type Login struct {
+	// ID is a hash of the ID stored in the browser's cookie.
 	ID        string
 	Me        string
+	IP        string
+	UserAgent string
 	CreatedAt time.Time
 	expiresAt time.Time
+
+	// Current is true if the Login is for the browser that made the request.
+	Current bool
 }
 
 func (l Login) Expired() bool {
 	return time.Now().After(l.expiresAt)
 }
 
-// Login returns a user's profile URL (i.e. 'me' value), if they have recently
-// logged in with relme-auth.
-func (d *Database) Login(r *http.Request) (string, error) {
+// loginID returns the ID stored in the request's cookie, if there is one.
+func (d *Database) loginID(r *http.Request) (string, bool) {
 	cookie, _ := d.cookies.Get(r, "relme-auth")
 
 	id, ok := cookie.Values["login_id"]
 	if !ok {
-		return "", ErrNoLogin
+		return "", false
 	}
 
 	loginID, ok := id.(string)
+	return loginID, ok
+}
+
+// Login returns a user's profile URL (i.e. 'me' value), if they have recently
+// logged in with relme-auth.
+func (d *Database) Login(r *http.Request) (string, error) {
+	loginID, ok := d.loginID(r)
 	if !ok {
 		return "", ErrNoLogin
 	}
 
 	var login Login
 
-	row := d.db.QueryRow(`SELECT ID, Me, CreatedAt FROM login WHERE ID = ?`, loginID)
+	row := d.db.QueryRow(`SELECT ID, Me, CreatedAt FROM login WHERE ID = ?`, hashToken(loginID))
 	if err := row.Scan(&login.ID, &login.Me, &login.CreatedAt); err != nil {
 		return "", ErrNoLogin
 	}
@@ -51,15 +65,31 @@ func (d *Database) Login(r *http.Request) (string, error) {
 	return login.Me, nil
 }
 
+// SaveLogin records that me has logged in, setting a cookie with the login's
+// ID. Only a hash of the ID is stored, like the long part of a token. Any login
+// the browser already had is replaced.
 func (d *Database) SaveLogin(w http.ResponseWriter, r *http.Request, me string) error {
 	loginID, err := random.String(20)
 	if err != nil {
 		return err
 	}
 
-	_, err = d.db.Exec(`INSERT OR REPLACE INTO login(ID, Me, CreatedAt) VALUES (?, ?, ?)`,
-		loginID,
+	if previousID, ok := d.loginID(r); ok {
+		if _, err := d.db.Exec(`DELETE FROM login WHERE ID = ?`, hashToken(previousID)); err != nil {
+			return err
+		}
+	}
+
+	encrypted, err := d.encryptAll(RemoteIP(r), r.UserAgent())
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(`INSERT INTO login(ID, Me, IP, UserAgent, CreatedAt) VALUES (?, ?, ?, ?, ?)`,
+		hashToken(loginID),
 		me,
+		encrypted[0],
+		encrypted[1],
 		time.Now().UTC())
 	if err != nil {
 		return err
@@ -69,3 +99,45 @@ func (d *Database) SaveLogin(w http.ResponseWriter, r *http.Request, me string)
 	cookie.Values["login_id"] = loginID
 	return cookie.Save(r, w)
 }
+
+// Logins returns the unexpired logins for me, newest first. The login for the
+// browser that made the request, if any, is marked as Current.
+func (d *Database) Logins(r *http.Request, me string) (logins []Login, err error) {
+	currentID, hasCurrent := d.loginID(r)
+	if hasCurrent {
+		currentID = hashToken(currentID)
+	}
+
+	rows, err := d.db.Query(`SELECT ID, Me, IP, UserAgent, CreatedAt FROM login WHERE Me = ? AND CreatedAt > ? ORDER BY CreatedAt DESC`,
+		me,
+		time.Now().UTC().Add(-d.expiry.Login))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var login Login
+		if err = rows.Scan(&login.ID, &login.Me, &login.IP, &login.UserAgent, &login.CreatedAt); err != nil {
+			return
+		}
+		if err = d.decryptAll(&login.IP, &login.UserAgent); err != nil {
+			return
+		}
+
+		login.expiresAt = login.CreatedAt.Add(d.expiry.Login)
+		login.Current = hasCurrent && login.ID == currentID
+		logins = append(logins, login)
+	}
+
+	err = rows.Err()
+	return
+}
+
+// RemoveLogin signs out the browser with the login, so that it must sign-in
+// again. The id is the ID of a Login returned by Logins.
+func (d *Database) RemoveLogin(me, id string) error {
+	_, err := d.db.Exec(`DELETE FROM login WHERE Me = ? AND ID = ?`, me, id)
+
+	return err
+}
@@ -40,3 +40,48 @@ func TestLogin(t *testing.T) {
 	_, err = http.DefaultClient.Do(req)
 	assert(err).Must.Nil()
 }
+
+func TestLogins(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	cookies := sessions.NewCookieStore([]byte("hey"))
+
+	db, _ := Open("file:logins?mode=memory&cache=shared", http.DefaultClient, cookies, Expiry{Login: time.Hour})
+	defer db.Close()
+
+	login := func(userAgent string) *http.Cookie {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+
+		err := db.SaveLogin(w, r, "http://its.me/")
+		assert(err).Must.Nil()
+
+		return w.Result().Cookies()[0]
+	}
+
+	first := login("first")
+	login("second")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(first)
+
+	logins, err := db.Logins(r, "http://its.me/")
+	assert(err).Must.Nil()
+	assert(logins).Must.Len(2)
+	assert(logins[0].UserAgent).Equal("second")
+	assert(logins[0].Current).False()
+	assert(logins[1].UserAgent).Equal("first")
+	assert(logins[1].IP).Equal("192.0.2.1")
+	assert(logins[1].Current).True()
+
+	err = db.RemoveLogin("http://its.me/", logins[1].ID)
+	assert(err).Must.Nil()
+
+	_, err = db.Login(r)
+	assert(err).Equal(ErrNoLogin)
+
+	logins, err = db.Logins(r, "http://its.me/")
+	assert(err).Must.Nil()
+	assert(logins).Len(1)
+}
@@ -0,0 +1,322 @@
+package data
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database has had migrations applied
+// that are not known, so was probably last used by a newer version.
+var ErrSchemaTooNew = errors.New("database schema is newer than this version supports")
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads the files in migrations/, which are named
+// "VERSION_NAME.up.sql" and "VERSION_NAME.down.sql". Versions must start at 1
+// and have no gaps, and each must have both an up and a down file.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		base := entry.Name()
+		direction := ""
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", base)
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(base, "."+direction+".sql"), "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected migration file %s", base)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected migration file %s", base)
+		}
+
+		contents, err := migrationFiles.ReadFile(path.Join("migrations", base))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(contents)
+		} else {
+			m.down = string(contents)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("missing migration %d", i+1)
+		}
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d must have an up and a down file", m.version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// MigrationStatus describes a migration, and whether it has been applied.
+type MigrationStatus struct {
+	Version int
+	Name    string
+
+	// AppliedAt is when the migration was applied, or the zero time if it has
+	// not been.
+	AppliedAt time.Time
+
+	// Unknown is true when the migration has been applied to the database, but
+	// is not one that this version knows about.
+	Unknown bool
+}
+
+func (s MigrationStatus) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+// Migrations lists every known migration, followed by any that have been
+// applied but are unknown.
+func (d *Database) Migrations() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		statuses = append(statuses, MigrationStatus{
+			Version:   m.version,
+			Name:      m.name,
+			AppliedAt: applied[m.version],
+		})
+		delete(applied, m.version)
+	}
+
+	var unknown []MigrationStatus
+	for version, appliedAt := range applied {
+		unknown = append(unknown, MigrationStatus{
+			Version:   version,
+			AppliedAt: appliedAt,
+			Unknown:   true,
+		})
+	}
+	sort.Slice(unknown, func(i, j int) bool {
+		return unknown[i].Version < unknown[j].Version
+	})
+
+	return append(statuses, unknown...), nil
+}
+
+// MigrateUp applies, in order, each migration that has not yet been. Each
+// migration is applied in its own transaction.
+func (d *Database) MigrateUp() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for version := range applied {
+		if version > len(migrations) {
+			return ErrSchemaTooNew
+		}
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		if err := d.applyMigration(m.version, m.up, true); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown reverts the most recently applied migration. It returns the
+// migration that was reverted, or false if there were none to revert.
+func (d *Database) MigrateDown() (MigrationStatus, bool, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return MigrationStatus{}, false, err
+	}
+
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return MigrationStatus{}, false, err
+	}
+
+	latest := 0
+	for version := range applied {
+		if version > latest {
+			latest = version
+		}
+	}
+
+	if latest == 0 {
+		return MigrationStatus{}, false, nil
+	}
+	if latest > len(migrations) {
+		return MigrationStatus{}, false, ErrSchemaTooNew
+	}
+
+	m := migrations[latest-1]
+	if err := d.applyMigration(m.version, m.down, false); err != nil {
+		return MigrationStatus{}, false, fmt.Errorf("migration %d_%s: %w", m.version, m.name, err)
+	}
+
+	return MigrationStatus{Version: m.version, Name: m.name}, true, nil
+}
+
+func (d *Database) applyMigration(version int, stmts string, up bool) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(d.db.dialect.types.Replace(stmts)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if up {
+		_, err = tx.Exec(`INSERT INTO schema_migrations(Version, AppliedAt) VALUES (?, ?)`, version, time.Now().UTC())
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE Version = ?`, version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// appliedMigrations returns when each migration that has been applied was. If
+// the database was versioned before migrations existed it is brought up to
+// date first, and those migrations are recorded as applied.
+func (d *Database) appliedMigrations() (map[int]time.Time, error) {
+	if _, err := d.db.Exec(d.db.dialect.types.Replace(`
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+      Version   INTEGER PRIMARY KEY,
+      AppliedAt DATETIME
+    )
+  `)); err != nil {
+		return nil, err
+	}
+
+	if err := d.adoptLegacyVersion(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Query(`SELECT Version, AppliedAt FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// adoptLegacyVersion records the migrations matching the schema version kept
+// before migrations existed. That version counted the statements applied after
+// creating the tables, which are now migrations 2 onwards. The tables were
+// created with "IF NOT EXISTS" each time the database was opened, so the first
+// migration is run again to add any that are missing.
+func (d *Database) adoptLegacyVersion() error {
+	version, err := d.db.dialect.legacyVersion(d.db.DB)
+	if err != nil || version == 0 {
+		return err
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if version+1 > len(migrations) {
+		return ErrSchemaTooNew
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(d.db.dialect.types.Replace(migrations[0].up)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, m := range migrations[:version+1] {
+		if _, err := tx.Exec(`INSERT INTO schema_migrations(Version, AppliedAt) VALUES (?, ?)`, m.version, now); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := d.db.dialect.clearLegacyVersion(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"net/http"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	migrations, err := loadMigrations()
+	assert(err).Must.Nil()
+	assert(len(migrations) > 0).True()
+
+	for i, m := range migrations {
+		assert(m.version).Equal(i + 1)
+		assert(m.name != "").True()
+		assert(m.up != "").True()
+		assert(m.down != "").True()
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	eachDatabase(t, Expiry{}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		migrations, err := db.Migrations()
+		assert(err).Must.Nil()
+		for _, m := range migrations {
+			assert(m.Applied()).True()
+			assert(m.Unknown).False()
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			reverted, ok, err := db.MigrateDown()
+			assert(err).Must.Nil()
+			assert(ok).True()
+			assert(reverted.Version).Equal(migrations[i].Version)
+			assert(reverted.Name).Equal(migrations[i].Name)
+		}
+
+		_, ok, err := db.MigrateDown()
+		assert(err).Must.Nil()
+		assert(ok).False()
+
+		_, err = db.db.Exec(`SELECT 1 FROM profile`)
+		assert(err).NotNil()
+
+		statuses, err := db.Migrations()
+		assert(err).Must.Nil()
+		for _, m := range statuses {
+			assert(m.Applied()).False()
+		}
+
+		err = db.MigrateUp()
+		assert(err).Must.Nil()
+
+		statuses, err = db.Migrations()
+		assert(err).Must.Nil()
+		for _, m := range statuses {
+			assert(m.Applied()).True()
+		}
+	})
+}
+
+func TestMigrateWhenSchemaTooNew(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, err := Open("file:migratetoonew?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
+	assert(err).Must.Nil()
+	defer db.Close()
+
+	_, err = db.db.Exec(`INSERT INTO schema_migrations(Version, AppliedAt) VALUES (999, CURRENT_TIMESTAMP)`)
+	assert(err).Must.Nil()
+
+	other, err := Open("file:migratetoonew?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
+	assert(err).Equal(ErrSchemaTooNew)
+	assert(other == nil).True()
+
+	statuses, err := db.Migrations()
+	assert(err).Must.Nil()
+	last := statuses[len(statuses)-1]
+	assert(last.Version).Equal(999)
+	assert(last.Unknown).True()
+
+	_, _, err = db.MigrateDown()
+	assert(err).Equal(ErrSchemaTooNew)
+}
+
+func TestMigrateFromLegacyVersion(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, err := Connect("file:migratelegacy?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
+	assert(err).Must.Nil()
+	defer db.Close()
+
+	migrations, err := loadMigrations()
+	assert(err).Must.Nil()
+
+	// a database last opened before migrations existed, with the tables
+	// created and the first two statements applied
+	for _, m := range migrations[:3] {
+		_, err = db.db.Exec(m.up)
+		assert(err).Must.Nil()
+	}
+	_, err = db.db.Exec(`PRAGMA user_version = 2`)
+	assert(err).Must.Nil()
+
+	err = db.MigrateUp()
+	assert(err).Must.Nil()
+
+	statuses, err := db.Migrations()
+	assert(err).Must.Nil()
+	assert(statuses).Len(len(migrations))
+	for _, m := range statuses {
+		assert(m.Applied()).True()
+	}
+
+	version, err := sqliteDialect.legacyVersion(db.db.DB)
+	assert(err).Must.Nil()
+	assert(version).Equal(0)
+
+	_, err = db.db.Exec(`SELECT ParentToken FROM token`)
+	assert(err).Nil()
+}
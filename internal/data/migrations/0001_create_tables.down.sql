@@ -0,0 +1,13 @@
+DROP TABLE http_cache;
+DROP TABLE ticket;
+DROP TABLE registered_client;
+DROP TABLE device_authorization;
+DROP TABLE revoked_token;
+DROP TABLE signing_key;
+DROP TABLE pushed_request;
+DROP TABLE login;
+DROP TABLE token;
+DROP TABLE session;
+DROP TABLE client;
+DROP TABLE method;
+DROP TABLE profile;
@@ -0,0 +1,110 @@
+CREATE TABLE IF NOT EXISTS profile (
+  Me        TEXT PRIMARY KEY,
+  CreatedAt DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS method (
+  Me       TEXT,
+  Provider TEXT,
+  Profile  TEXT,
+  PRIMARY KEY (Me, Provider),
+  FOREIGN KEY (Me) REFERENCES profile(Me)
+);
+
+CREATE TABLE IF NOT EXISTS client (
+  ClientID    TEXT PRIMARY KEY,
+  RedirectURI TEXT,
+  Name        TEXT,
+  CreatedAt   DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS session (
+  Me           TEXT PRIMARY KEY,
+  ResponseType TEXT,
+  Provider     TEXT,
+  ProfileURI   TEXT,
+  ClientID     TEXT,
+  RedirectURI  TEXT,
+  Scope        TEXT,
+  State        TEXT,
+  Code         TEXT,
+  CreatedAt    DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS token (
+  ShortToken    TEXT PRIMARY KEY,
+  LongTokenHash TEXT,
+  Me            TEXT,
+  ClientID      TEXT,
+  Scope         TEXT,
+  CreatedAt     DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS login (
+  ID        TEXT,
+  Me        TEXT PRIMARY KEY,
+  CreatedAt DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS pushed_request (
+  RequestURI          TEXT PRIMARY KEY,
+  ResponseType        TEXT,
+  Me                  TEXT,
+  ClientID            TEXT,
+  RedirectURI         TEXT,
+  CodeChallenge       TEXT,
+  CodeChallengeMethod TEXT,
+  Scope               TEXT,
+  State               TEXT,
+  CreatedAt           DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS signing_key (
+  ID         TEXT PRIMARY KEY,
+  PrivateKey TEXT,
+  CreatedAt  DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS revoked_token (
+  JTI       TEXT PRIMARY KEY,
+  ExpiresAt DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS device_authorization (
+  DeviceCode   TEXT PRIMARY KEY,
+  UserCode     TEXT UNIQUE,
+  ClientID     TEXT,
+  Scope        TEXT,
+  Me           TEXT,
+  Denied       BOOLEAN,
+  CreatedAt    DATETIME,
+  LastPolledAt DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS registered_client (
+  ClientID     TEXT PRIMARY KEY,
+  SecretHash   TEXT,
+  Scopes       TEXT,
+  RedirectURIs TEXT,
+  CreatedAt    DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS ticket (
+  Ticket    TEXT PRIMARY KEY,
+  Me        TEXT,
+  Subject   TEXT,
+  Resource  TEXT,
+  Scope     TEXT,
+  CreatedAt DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS http_cache (
+  Key          TEXT PRIMARY KEY,
+  StatusCode   INTEGER,
+  Header       TEXT,
+  Body         BLOB,
+  ETag         TEXT,
+  LastModified TEXT,
+  CreatedAt    DATETIME,
+  ExpiresAt    DATETIME
+);
@@ -0,0 +1,2 @@
+ALTER TABLE session DROP COLUMN CodeChallengeMethod;
+ALTER TABLE session DROP COLUMN CodeChallenge;
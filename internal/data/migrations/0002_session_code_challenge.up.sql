@@ -0,0 +1,2 @@
+ALTER TABLE session ADD COLUMN CodeChallenge TEXT;
+ALTER TABLE session ADD COLUMN CodeChallengeMethod TEXT;
@@ -0,0 +1 @@
+ALTER TABLE token DROP COLUMN DPoPThumbprint;
@@ -0,0 +1 @@
+ALTER TABLE token ADD COLUMN DPoPThumbprint TEXT NOT NULL DEFAULT '';
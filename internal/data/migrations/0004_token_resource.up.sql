@@ -0,0 +1 @@
+ALTER TABLE token ADD COLUMN Resource TEXT NOT NULL DEFAULT '';
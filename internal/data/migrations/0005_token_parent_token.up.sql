@@ -0,0 +1 @@
+ALTER TABLE token ADD COLUMN ParentToken TEXT NOT NULL DEFAULT '';
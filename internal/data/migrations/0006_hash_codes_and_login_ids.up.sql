@@ -0,0 +1,2 @@
+DELETE FROM login;
+UPDATE session SET Code = NULL;
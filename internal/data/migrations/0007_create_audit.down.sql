@@ -0,0 +1,2 @@
+DROP INDEX audit_me;
+DROP TABLE audit;
@@ -0,0 +1,13 @@
+CREATE TABLE audit (
+  ID        TEXT PRIMARY KEY,
+  Action    TEXT,
+  Me        TEXT,
+  ClientID  TEXT,
+  Provider  TEXT,
+  IP        TEXT,
+  UserAgent TEXT,
+  Outcome   TEXT,
+  CreatedAt DATETIME
+);
+
+CREATE INDEX audit_me ON audit (Me, CreatedAt);
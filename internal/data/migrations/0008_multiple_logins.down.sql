@@ -0,0 +1,8 @@
+DROP INDEX login_me;
+DROP TABLE login;
+
+CREATE TABLE login (
+  ID        TEXT,
+  Me        TEXT PRIMARY KEY,
+  CreatedAt DATETIME
+);
@@ -0,0 +1,14 @@
+CREATE TABLE login_new (
+  ID        TEXT PRIMARY KEY,
+  Me        TEXT,
+  IP        TEXT,
+  UserAgent TEXT,
+  CreatedAt DATETIME
+);
+
+INSERT INTO login_new(ID, Me, IP, UserAgent, CreatedAt) SELECT ID, Me, '', '', CreatedAt FROM login;
+
+DROP TABLE login;
+ALTER TABLE login_new RENAME TO login;
+
+CREATE INDEX login_me ON login (Me);
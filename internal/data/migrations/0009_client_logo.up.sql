@@ -0,0 +1 @@
+ALTER TABLE client ADD COLUMN Logo TEXT NOT NULL DEFAULT '';
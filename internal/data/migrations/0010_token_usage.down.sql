@@ -0,0 +1,3 @@
+ALTER TABLE token DROP COLUMN UseCount;
+ALTER TABLE token DROP COLUMN LastUsedIP;
+ALTER TABLE token DROP COLUMN LastUsedAt;
@@ -0,0 +1,3 @@
+ALTER TABLE token ADD COLUMN LastUsedAt DATETIME;
+ALTER TABLE token ADD COLUMN LastUsedIP TEXT NOT NULL DEFAULT '';
+ALTER TABLE token ADD COLUMN UseCount INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DELETE FROM device_authorization;
+DELETE FROM ticket;
@@ -27,27 +27,40 @@ type Method struct {
 }
 
 func (d *Database) CacheProfile(profile Profile) error {
-	_, err := d.db.Exec(`
-    DELETE FROM method WHERE Me = ?;
-    DELETE FROM profile WHERE Me = ?;
-    INSERT INTO profile(Me, CreatedAt) VALUES(?, ?);
-  `,
-		profile.Me,
-		profile.Me,
-		profile.Me,
-		profile.UpdatedAt)
-
 	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
+
+	for _, stmt := range []string{
+		`DELETE FROM method WHERE Me = ?`,
+		`DELETE FROM profile WHERE Me = ?`,
+	} {
+		if _, err := tx.Exec(stmt, profile.Me); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO profile(Me, CreatedAt) VALUES(?, ?)`, profile.Me, profile.UpdatedAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	stmt, err := tx.Prepare(`INSERT INTO method(Me, Provider, Profile) VALUES(?, ?, ?)`)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
 	for _, method := range profile.Methods {
-		_, err = stmt.Exec(profile.Me, method.Provider, method.Profile)
+		var encryptedProfile string
+		if encryptedProfile, err = d.encrypt(method.Profile); err != nil {
+			break
+		}
+		if _, err = stmt.Exec(profile.Me, method.Provider, encryptedProfile); err != nil {
+			break
+		}
 	}
 
 	if err != nil {
@@ -82,6 +95,9 @@ func (d *Database) Profile(me string) (Profile, error) {
 		if err = rows.Scan(&profile.Me, &profile.UpdatedAt, &method.Provider, &method.Profile); err != nil {
 			return profile, err
 		}
+		if err = d.decryptAll(&method.Profile); err != nil {
+			return profile, err
+		}
 		if profile.UpdatedAt.After(updatedAt) {
 			updatedAt = profile.UpdatedAt
 		}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrPushedRequestUsed is returned when a pushed request has already been used
+// to start a session.
+var ErrPushedRequestUsed = errors.New("pushed request has already been used")
+
+// PushedRequestURIPrefix is prepended to the identifier of a PushedRequest to
+// form the "request_uri" returned to the client.
+const PushedRequestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+// PushedRequest holds the parameters of an authorization request that a client
+// has sent directly, so that they do not have to be included in the URL the
+// user is redirected to.
+type PushedRequest struct {
+	RequestURI          string
+	ResponseType        string
+	Me                  string
+	ClientID            string
+	RedirectURI         string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Scope               string
+	State               string
+	CreatedAt           time.Time
+	ExpiresAt           time.Time
+}
+
+func (r PushedRequest) Expired() bool {
+	return time.Now().After(r.ExpiresAt)
+}
+
+func (d *Database) CreatePushedRequest(request PushedRequest) error {
+	encrypted, err := d.encryptAll(request.CodeChallenge, request.State)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(`
+    INSERT INTO pushed_request(RequestURI, ResponseType, Me, ClientID, RedirectURI, CodeChallenge, CodeChallengeMethod, Scope, State, CreatedAt)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+  `,
+		request.RequestURI,
+		request.ResponseType,
+		request.Me,
+		request.ClientID,
+		request.RedirectURI,
+		encrypted[0],
+		request.CodeChallengeMethod,
+		request.Scope,
+		encrypted[1],
+		request.CreatedAt)
+
+	return err
+}
+
+// PushedRequest returns the request for the requestURI. The request can be
+// retrieved multiple times until it is used, or it expires, as the user may
+// need to enter their "me" before continuing.
+func (d *Database) PushedRequest(requestURI string) (request PushedRequest, err error) {
+	row := d.db.QueryRow(`
+    SELECT RequestURI, ResponseType, Me, ClientID, RedirectURI, CodeChallenge, CodeChallengeMethod, Scope, State, CreatedAt
+    FROM pushed_request
+    WHERE RequestURI = ?`,
+		requestURI)
+
+	err = row.Scan(
+		&request.RequestURI,
+		&request.ResponseType,
+		&request.Me,
+		&request.ClientID,
+		&request.RedirectURI,
+		&request.CodeChallenge,
+		&request.CodeChallengeMethod,
+		&request.Scope,
+		&request.State,
+		&request.CreatedAt)
+	if err != nil {
+		return
+	}
+
+	err = d.decryptAll(&request.CodeChallenge, &request.State)
+	request.ExpiresAt = request.CreatedAt.Add(d.expiry.PushedRequest)
+
+	return
+}
+
+// UsePushedRequest removes the request for the requestURI, so that it can only
+// start one session. If it has already been used, or does not exist,
+// ErrPushedRequestUsed is returned.
+func (d *Database) UsePushedRequest(requestURI string) error {
+	result, err := d.db.Exec(`DELETE FROM pushed_request WHERE RequestURI = ?`, requestURI)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPushedRequestUsed
+	}
+
+	return nil
+}
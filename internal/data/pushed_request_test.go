@@ -0,0 +1,75 @@
+package data
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestPushedRequest(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{PushedRequest: time.Minute})
+	defer db.Close()
+
+	now := time.Now()
+
+	err := db.CreatePushedRequest(PushedRequest{
+		RequestURI:          "urn:ietf:params:oauth:request_uri:abcde",
+		ResponseType:        "code",
+		ClientID:            "http://client.example.com/",
+		RedirectURI:         "http://client.example.com/callback",
+		CodeChallenge:       "xyz",
+		CodeChallengeMethod: "S256",
+		Scope:               "create",
+		State:               "123",
+		CreatedAt:           now,
+	})
+	assert(err).Must.Nil()
+
+	request, err := db.PushedRequest("urn:ietf:params:oauth:request_uri:abcde")
+	assert(err).Nil()
+	assert(request.ResponseType).Equal("code")
+	assert(request.Me).Equal("")
+	assert(request.ClientID).Equal("http://client.example.com/")
+	assert(request.RedirectURI).Equal("http://client.example.com/callback")
+	assert(request.CodeChallenge).Equal("xyz")
+	assert(request.CodeChallengeMethod).Equal("S256")
+	assert(request.Scope).Equal("create")
+	assert(request.State).Equal("123")
+	assert(request.CreatedAt).WithinDuration(now, 10*time.Millisecond)
+	assert(request.Expired()).False()
+
+	_, err = db.PushedRequest("urn:ietf:params:oauth:request_uri:what")
+	assert(err).Equal(sql.ErrNoRows)
+
+	err = db.UsePushedRequest("urn:ietf:params:oauth:request_uri:abcde")
+	assert(err).Nil()
+
+	_, err = db.PushedRequest("urn:ietf:params:oauth:request_uri:abcde")
+	assert(err).Equal(sql.ErrNoRows)
+
+	err = db.UsePushedRequest("urn:ietf:params:oauth:request_uri:abcde")
+	assert(err).Equal(ErrPushedRequestUsed)
+}
+
+func TestPushedRequestWithExpiry(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{PushedRequest: time.Minute})
+	defer db.Close()
+
+	err := db.CreatePushedRequest(PushedRequest{
+		RequestURI:   "urn:ietf:params:oauth:request_uri:abcde",
+		ResponseType: "id",
+		CreatedAt:    time.Now().Add(-2 * time.Minute),
+	})
+	assert(err).Must.Nil()
+
+	request, err := db.PushedRequest("urn:ietf:params:oauth:request_uri:abcde")
+	assert(err).Nil()
+	assert(request.Expired()).True()
+}
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reaped counts the rows deleted from each table by Reap.
+type Reaped map[string]int64
+
+// Total returns the number of rows deleted from all tables.
+func (r Reaped) Total() int64 {
+	var total int64
+	for _, n := range r {
+		total += n
+	}
+	return total
+}
+
+func (r Reaped) String() string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + strconv.FormatInt(r[name], 10)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// reapQuery deletes the rows from table that are older than arg.
+type reapQuery struct {
+	table string
+	query string
+	arg   time.Time
+}
+
+// Reap deletes the rows that are past their expiry at now, so would be ignored
+// if read. Opaque tokens do not expire, so tokens are only deleted when
+// expireTokens is true, which should be when every token issued is a JWT
+// access token.
+func (d *Database) Reap(now time.Time, expireTokens bool) (Reaped, error) {
+	// a session is replaced by a code, which refreshes CreatedAt, so keep rows
+	// for whichever lasts longer
+	sessionExpiry := d.expiry.Session
+	if d.expiry.Code > sessionExpiry {
+		sessionExpiry = d.expiry.Code
+	}
+
+	deletes := []reapQuery{
+		{"method", `DELETE FROM method WHERE Me IN (SELECT Me FROM profile WHERE CreatedAt < ?)`, now.Add(-d.expiry.Profile)},
+		{"profile", `DELETE FROM profile WHERE CreatedAt < ?`, now.Add(-d.expiry.Profile)},
+		{"client", `DELETE FROM client WHERE CreatedAt < ?`, now.Add(-d.expiry.Client)},
+		{"session", `DELETE FROM session WHERE CreatedAt < ?`, now.Add(-sessionExpiry)},
+		{"login", `DELETE FROM login WHERE CreatedAt < ?`, now.Add(-d.expiry.Login)},
+		{"pushed_request", `DELETE FROM pushed_request WHERE CreatedAt < ?`, now.Add(-d.expiry.PushedRequest)},
+		{"device_authorization", `DELETE FROM device_authorization WHERE CreatedAt < ?`, now.Add(-d.expiry.DeviceCode)},
+		{"ticket", `DELETE FROM ticket WHERE CreatedAt < ?`, now.Add(-d.expiry.Ticket)},
+		{"revoked_token", `DELETE FROM revoked_token WHERE ExpiresAt < ?`, now},
+		{"http_cache", `DELETE FROM http_cache WHERE CreatedAt < ?`, now.Add(-d.expiry.HTTPCache)},
+	}
+	if d.expiry.Audit > 0 {
+		deletes = append(deletes, reapQuery{"audit", `DELETE FROM audit WHERE CreatedAt < ?`, now.Add(-d.expiry.Audit)})
+	}
+	if expireTokens {
+		deletes = append(deletes, reapQuery{"token", `DELETE FROM token WHERE CreatedAt < ?`, now.Add(-d.expiry.AccessToken)})
+	}
+
+	reaped := Reaped{}
+	for _, del := range deletes {
+		result, err := d.db.Exec(del.query, del.arg)
+		if err != nil {
+			return reaped, err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return reaped, err
+		}
+		reaped[del.table] = n
+	}
+
+	return reaped, nil
+}
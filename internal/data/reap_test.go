@@ -0,0 +1,83 @@
+package data
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestReap(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{
+		Session:     5 * time.Minute,
+		Code:        time.Minute,
+		Profile:     time.Hour,
+		Login:       time.Hour,
+		Client:      time.Hour,
+		AccessToken: time.Hour,
+	})
+	defer db.Close()
+
+	now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	old := now.Add(-2 * time.Hour)
+
+	for _, me := range []string{"http://old.example.com", "http://new.example.com"} {
+		createdAt := now
+		if me == "http://old.example.com" {
+			createdAt = old
+		}
+
+		err := db.CreateSession(Session{Me: me, CreatedAt: createdAt})
+		assert(err).Must.Nil()
+
+		err = db.CacheProfile(Profile{
+			Me:        me,
+			UpdatedAt: createdAt,
+			Methods:   []Method{{Provider: "someone", Profile: "http://someone.example.com/"}},
+		})
+		assert(err).Must.Nil()
+
+		_, err = db.db.Exec(`INSERT INTO login(ID, Me, CreatedAt) VALUES (?, ?, ?)`, me, me, createdAt)
+		assert(err).Must.Nil()
+
+		err = db.cacheClient(Client{ID: me, RedirectURI: me + "/callback", UpdatedAt: createdAt})
+		assert(err).Must.Nil()
+
+		err = db.CreateToken(Token{ShortToken: me, LongTokenHash: "xyz", Me: me, CreatedAt: createdAt})
+		assert(err).Must.Nil()
+	}
+
+	reaped, err := db.Reap(now, false)
+	assert(err).Must.Nil()
+	assert(reaped["session"]).Equal(int64(1))
+	assert(reaped["profile"]).Equal(int64(1))
+	assert(reaped["method"]).Equal(int64(1))
+	assert(reaped["login"]).Equal(int64(1))
+	assert(reaped["client"]).Equal(int64(1))
+	assert(reaped.Total()).Equal(int64(5))
+
+	_, err = db.Session("http://new.example.com")
+	assert(err).Nil()
+	_, err = db.Profile("http://new.example.com")
+	assert(err).Nil()
+
+	tokens, _ := db.Tokens("http://old.example.com")
+	assert(tokens).Len(1)
+
+	reaped, err = db.Reap(now, true)
+	assert(err).Must.Nil()
+	assert(reaped["token"]).Equal(int64(1))
+	assert(reaped.Total()).Equal(int64(1))
+
+	tokens, _ = db.Tokens("http://old.example.com")
+	assert(tokens).Len(0)
+	tokens, _ = db.Tokens("http://new.example.com")
+	assert(tokens).Len(1)
+}
+
+func TestReapedString(t *testing.T) {
+	assert.Equal(t, "login=2 session=0 token=1", Reaped{"token": 1, "session": 0, "login": 2}.String())
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrUnknownClient is returned when a client has not been registered.
+var ErrUnknownClient = errors.New("client is not registered")
+
+// RegisteredClient is a confidential client that has been registered by an
+// administrator. It must authenticate with its secret at the token endpoint, and
+// is limited to the scopes and redirect URIs it was registered with.
+type RegisteredClient struct {
+	ID           string
+	SecretHash   string
+	Scopes       []string
+	RedirectURIs []string
+	CreatedAt    time.Time
+}
+
+// VerifySecret returns true if the secret is the one issued to the client.
+func (c RegisteredClient) VerifySecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(c.SecretHash)) == 1
+}
+
+// FilterScope removes any scopes the client is not allowed to request.
+func (c RegisteredClient) FilterScope(scope string) string {
+	var allowed []string
+	for _, requested := range strings.Fields(scope) {
+		for _, s := range c.Scopes {
+			if requested == s {
+				allowed = append(allowed, requested)
+				break
+			}
+		}
+	}
+
+	return strings.Join(allowed, " ")
+}
+
+func (c RegisteredClient) allowsRedirectURI(redirectURI string) bool {
+	for _, candidate := range c.RedirectURIs {
+		if candidate == redirectURI {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RegisterClient stores a new client, returning the secret it must use to
+// authenticate. Only a hash of the secret is kept.
+func (d *Database) RegisterClient(generator func(int) (string, error), clientID string, scopes, redirectURIs []string) (string, error) {
+	clientID = ParseClientID(clientID)
+	if clientID == "" {
+		return "", errors.New("client_id must be a valid URL")
+	}
+
+	secret, err := generator(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = d.db.Exec(`INSERT INTO registered_client(ClientID, SecretHash, Scopes, RedirectURIs, CreatedAt) VALUES (?, ?, ?, ?, ?)`,
+		clientID,
+		hashToken(secret),
+		strings.Join(scopes, " "),
+		strings.Join(redirectURIs, " "),
+		time.Now().UTC())
+
+	return secret, err
+}
+
+func (d *Database) RegisteredClient(clientID string) (client RegisteredClient, err error) {
+	row := d.db.QueryRow(`SELECT ClientID, SecretHash, Scopes, RedirectURIs, CreatedAt FROM registered_client WHERE ClientID = ?`,
+		ParseClientID(clientID))
+
+	client, err = scanRegisteredClient(row)
+	if err == sql.ErrNoRows {
+		err = ErrUnknownClient
+	}
+
+	return
+}
+
+func (d *Database) RegisteredClients() (clients []RegisteredClient, err error) {
+	rows, err := d.db.Query(`SELECT ClientID, SecretHash, Scopes, RedirectURIs, CreatedAt FROM registered_client ORDER BY ClientID`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var client RegisteredClient
+		if client, err = scanRegisteredClient(rows); err != nil {
+			return
+		}
+
+		clients = append(clients, client)
+	}
+
+	err = rows.Err()
+	return
+}
+
+// RemoveClient deletes the registered client, and revokes any tokens that were
+// issued to it.
+func (d *Database) RemoveClient(clientID string) error {
+	clientID = ParseClientID(clientID)
+
+	if err := d.revokeTokens(`ClientID = ?`, clientID); err != nil {
+		return err
+	}
+
+	_, err := d.db.Exec(`DELETE FROM registered_client WHERE ClientID = ?`, clientID)
+
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRegisteredClient(row scanner) (client RegisteredClient, err error) {
+	var scopes, redirectURIs string
+
+	err = row.Scan(
+		&client.ID,
+		&client.SecretHash,
+		&scopes,
+		&redirectURIs,
+		&client.CreatedAt)
+
+	client.Scopes = strings.Fields(scopes)
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	return
+}
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestRegisteredClient(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{Client: time.Minute})
+	defer db.Close()
+
+	generator := func(n int) (string, error) { return "secret", nil }
+
+	secret, err := db.RegisterClient(generator, "https://bot.example.com", []string{"read", "create"}, []string{"https://bot.example.com/callback"})
+	assert(err).Must.Nil()
+	assert(secret).Equal("secret")
+
+	client, err := db.RegisteredClient("https://bot.example.com/")
+	assert(err).Must.Nil()
+	assert(client.ID).Equal("https://bot.example.com/")
+	assert(client.Scopes).Equal([]string{"read", "create"})
+	assert(client.RedirectURIs).Equal([]string{"https://bot.example.com/callback"})
+	assert(client.VerifySecret("secret")).True()
+	assert(client.VerifySecret("guess")).False()
+	assert(client.FilterScope("create delete read")).Equal("create read")
+
+	clients, err := db.RegisteredClients()
+	assert(err).Must.Nil()
+	assert(clients).Len(1)
+
+	info, err := db.Client("https://bot.example.com/", "https://bot.example.com/callback")
+	assert(err).Nil()
+	assert(info.ID).Equal("https://bot.example.com/")
+
+	_, err = db.Client("https://bot.example.com/", "https://bot.example.com/other")
+	assert(err).NotNil()
+
+	assert(db.RemoveClient("https://bot.example.com/")).Must.Nil()
+
+	_, err = db.RegisteredClient("https://bot.example.com/")
+	assert(err).Equal(ErrUnknownClient)
+}
+
+func TestRegisterClientWithInvalidID(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
+	defer db.Close()
+
+	_, err := db.RegisterClient(func(n int) (string, error) { return "secret", nil }, "not a url", nil, nil)
+	assert(err).NotNil()
+}
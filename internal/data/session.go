@@ -24,18 +24,35 @@ func (s Session) Expired() bool {
 }
 
 func (d *Database) CreateSession(session Session) error {
-	_, err := d.db.Exec(`
-    INSERT OR REPLACE INTO session(ResponseType, Me, ClientID, RedirectURI, CodeChallenge, CodeChallengeMethod, Scope, State, Provider, ProfileURI, CreatedAt)
+	encrypted, err := d.encryptAll(session.CodeChallenge, session.State)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(`
+    INSERT INTO session(ResponseType, Me, ClientID, RedirectURI, CodeChallenge, CodeChallengeMethod, Scope, State, Provider, ProfileURI, CreatedAt)
     VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT (Me) DO UPDATE SET
+      ResponseType = excluded.ResponseType,
+      ClientID = excluded.ClientID,
+      RedirectURI = excluded.RedirectURI,
+      CodeChallenge = excluded.CodeChallenge,
+      CodeChallengeMethod = excluded.CodeChallengeMethod,
+      Scope = excluded.Scope,
+      State = excluded.State,
+      Provider = excluded.Provider,
+      ProfileURI = excluded.ProfileURI,
+      Code = NULL,
+      CreatedAt = excluded.CreatedAt
   `,
 		session.ResponseType,
 		session.Me,
 		session.ClientID,
 		session.RedirectURI,
-		session.CodeChallenge,
+		encrypted[0],
 		session.CodeChallengeMethod,
 		session.Scope,
-		session.State,
+		encrypted[1],
 		"",
 		"",
 		session.CreatedAt)
@@ -44,9 +61,14 @@ func (d *Database) CreateSession(session Session) error {
 }
 
 func (d *Database) SetProvider(me, provider, profileURI string) error {
-	_, err := d.db.Exec(`UPDATE session SET Provider = ?, ProfileURI = ? WHERE Me = ?`,
+	encryptedProfileURI, err := d.encrypt(profileURI)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(`UPDATE session SET Provider = ?, ProfileURI = ? WHERE Me = ?`,
 		provider,
-		profileURI,
+		encryptedProfileURI,
 		me)
 
 	return err
@@ -71,6 +93,11 @@ func (d *Database) Session(me string) (session Session, err error) {
 		&session.Provider,
 		&session.ProfileURI,
 		&session.CreatedAt)
+	if err != nil {
+		return
+	}
+
+	err = d.decryptAll(&session.CodeChallenge, &session.State, &session.ProfileURI)
 	session.ExpiresAt = session.CreatedAt.Add(d.expiry.Session)
 
 	return
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/jwt"
+	"hawx.me/code/relme-auth/internal/random"
+)
+
+// SigningKey is used to sign JWT access tokens.
+type SigningKey struct {
+	ID        string
+	Key       *ecdsa.PrivateKey
+	CreatedAt time.Time
+}
+
+func newSigningKey() (SigningKey, error) {
+	id, err := random.String(16)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	return SigningKey{
+		ID:        id,
+		Key:       key,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+func (d *Database) createSigningKey(key SigningKey) error {
+	der, err := x509.MarshalECPrivateKey(key.Key)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := d.encrypt(base64.StdEncoding.EncodeToString(der))
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(`INSERT INTO signing_key(ID, PrivateKey, CreatedAt) VALUES (?, ?, ?)`,
+		key.ID,
+		encoded,
+		key.CreatedAt)
+
+	return err
+}
+
+// signingKeys returns all stored keys, newest first.
+func (d *Database) signingKeys() (keys []SigningKey, err error) {
+	rows, err := d.db.Query(`SELECT ID, PrivateKey, CreatedAt FROM signing_key ORDER BY CreatedAt DESC`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key SigningKey
+		var encoded string
+		if err = rows.Scan(&key.ID, &encoded, &key.CreatedAt); err != nil {
+			return
+		}
+		if encoded, err = d.decrypt(encoded); err != nil {
+			return
+		}
+
+		var der []byte
+		if der, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return
+		}
+		if key.Key, err = x509.ParseECPrivateKey(der); err != nil {
+			return
+		}
+
+		keys = append(keys, key)
+	}
+
+	err = rows.Err()
+	return
+}
+
+// currentSigningKey returns the key that new tokens should be signed with,
+// creating one if none exist.
+func (d *Database) currentSigningKey() (SigningKey, error) {
+	keys, err := d.signingKeys()
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	if len(keys) > 0 {
+		return keys[0], nil
+	}
+
+	key, err := newSigningKey()
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	return key, d.createSigningKey(key)
+}
+
+// RotateSigningKeys creates a new signing key if the current key is older than
+// the SigningKey expiry. Keys that can no longer have signed an unexpired token
+// are deleted.
+func (d *Database) RotateSigningKeys() error {
+	keys, err := d.signingKeys()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	if len(keys) == 0 || now.After(keys[0].CreatedAt.Add(d.expiry.SigningKey)) {
+		key, err := newSigningKey()
+		if err != nil {
+			return err
+		}
+		if err := d.createSigningKey(key); err != nil {
+			return err
+		}
+	}
+
+	// a key stops being used once it is SigningKey old, so the last token it
+	// signed will expire AccessToken after that
+	_, err = d.db.Exec(`DELETE FROM signing_key WHERE CreatedAt < ?`,
+		now.Add(-d.expiry.SigningKey-d.expiry.AccessToken))
+
+	return err
+}
+
+// PublicKeys returns the set of keys that can be used to verify access tokens.
+func (d *Database) PublicKeys() (jwt.KeySet, error) {
+	keys, err := d.signingKeys()
+	if err != nil {
+		return jwt.KeySet{}, err
+	}
+
+	set := jwt.KeySet{Keys: []jwt.Key{}}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, jwt.PublicKey(key.ID, &key.Key.PublicKey))
+	}
+
+	return set, nil
+}
@@ -3,10 +3,11 @@ package data
 import (
 	"database/sql"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gorilla/sessions"
+	// register postgres for database/sql
+	_ "github.com/lib/pq"
 	// register sqlite3 for database/sql
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -36,130 +37,111 @@ type Expiry struct {
 	// "continue" on the "choose" page, bypassing the need to reauthenticate with
 	// a downstream provider.
 	Login time.Duration
+
+	// PushedRequest specifies how long a pushed authorization request can be
+	// used for. This is the time between the client pushing the request, and the
+	// user being sent to the authorization endpoint.
+	PushedRequest time.Duration
+
+	// AccessToken specifies how long a JWT access token is valid for. Opaque
+	// tokens do not expire.
+	AccessToken time.Duration
+
+	// SigningKey specifies how long a key is used to sign JWT access tokens
+	// before a new key is created. A key is kept for a further AccessToken so
+	// that tokens signed with it can still be verified.
+	SigningKey time.Duration
+
+	// DeviceCode specifies how long a device authorization request is valid for.
+	// This is the time between the device starting the request, and the user
+	// approving it.
+	DeviceCode time.Duration
+
+	// Ticket specifies how long a ticket can be redeemed for. This is the time
+	// between the ticket being sent to the subject's ticket endpoint, and the
+	// subject exchanging it for an access token.
+	Ticket time.Duration
+
+	// HTTPCache specifies how long a fetched page is kept so that it can be
+	// revalidated. This is the time since the page was last fetched, or
+	// revalidated, regardless of how long the page said it would be fresh for.
+	HTTPCache time.Duration
+
+	// Audit specifies how long audit events are kept for. If zero they are kept
+	// forever.
+	Audit time.Duration
 }
 
 type Database struct {
-	db         *sql.DB
+	db         sqlDB
 	httpClient *http.Client
 	cookies    sessions.Store
 	expiry     Expiry
+	keys       *keyring
+	usage      tokenUsage
 }
 
-func Open(path string, httpClient *http.Client, cookies sessions.Store, expiry Expiry) (*Database, error) {
-	sqlite, err := sql.Open("sqlite3", path)
+// Open connects to the database given by dsn, applying any migrations that
+// have not yet been. The dsn is either the path to a SQLite database, or a
+// postgres:// URL. If the database has had migrations applied that are not
+// known, ErrSchemaTooNew is returned.
+func Open(dsn string, httpClient *http.Client, cookies sessions.Store, expiry Expiry) (*Database, error) {
+	db, err := Connect(dsn, httpClient, cookies, expiry)
 	if err != nil {
 		return nil, err
 	}
 
-	db := &Database{
-		db:         sqlite,
-		httpClient: httpClient,
-		cookies:    cookies,
-		expiry:     expiry,
+	if err := db.MigrateUp(); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	return db, db.migrate()
+	return db, nil
 }
 
-func (d *Database) migrate() error {
-	_, err := d.db.Exec(`
-		CREATE TABLE IF NOT EXISTS profile (
-			Me        TEXT PRIMARY KEY,
-			CreatedAt DATETIME
-		);
-
-		CREATE TABLE IF NOT EXISTS method (
-			Me       TEXT,
-			Provider TEXT,
-			Profile  TEXT,
-			PRIMARY KEY (Me, Provider),
-			FOREIGN KEY (Me) REFERENCES profile(Me)
-		);
-
-		CREATE TABLE IF NOT EXISTS client (
-			ClientID    TEXT PRIMARY KEY,
-			RedirectURI TEXT,
-			Name        TEXT,
-			CreatedAt   DATETIME
-		);
-
-		CREATE TABLE IF NOT EXISTS session (
-			Me           TEXT PRIMARY KEY,
-			ResponseType TEXT,
-			Provider     TEXT,
-			ProfileURI   TEXT,
-			ClientID     TEXT,
-			RedirectURI  TEXT,
-			Scope        TEXT,
-			State        TEXT,
-			Code         TEXT,
-			CreatedAt    DATETIME
-		);
-
-		CREATE TABLE IF NOT EXISTS token (
-			ShortToken    TEXT PRIMARY KEY,
-			LongTokenHash TEXT,
-			Me            TEXT,
-			ClientID      TEXT,
-			Scope         TEXT,
-			CreatedAt     DATETIME
-		);
-
-		CREATE TABLE IF NOT EXISTS login (
-			ID        TEXT,
-			Me        TEXT PRIMARY KEY,
-			CreatedAt DATETIME
-		);
-
-`)
+// Connect connects to the database given by dsn, like Open, but leaves the
+// schema as it is.
+func Connect(dsn string, httpClient *http.Client, cookies sessions.Store, expiry Expiry) (*Database, error) {
+	dialect := dialectFor(dsn)
+
+	conn, err := sql.Open(dialect.driver, dsn)
 	if err != nil {
+		return nil, err
+	}
+
+	return &Database{
+		db:         sqlDB{DB: conn, dialect: dialect},
+		httpClient: httpClient,
+		cookies:    cookies,
+		expiry:     expiry,
+	}, nil
+}
+
+// Forget removes all data stored for me, from every table that has a column
+// for it.
+func (d *Database) Forget(me string) error {
+	if err := d.denyTokens(d.db, `Me = ?`, me); err != nil {
 		return err
 	}
 
-	version, err := d.schemaVersion()
+	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	stmts := []string{
-		`ALTER TABLE session ADD COLUMN CodeChallenge TEXT;
-		 ALTER TABLE session ADD COLUMN CodeChallengeMethod TEXT;`,
-	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		t := tables[i]
+		if t.me == "" {
+			continue
+		}
 
-	for _, stmt := range stmts[version:] {
-		_, err := d.db.Exec(stmt)
-		if err != nil {
+		if _, err := tx.Exec(`DELETE FROM `+t.name+` WHERE `+t.me+` = ?`, me); err != nil {
+			tx.Rollback()
 			return err
 		}
 	}
 
-	return d.setSchemaVersion(len(stmts))
-}
-
-func (d *Database) schemaVersion() (int, error) {
-	row := d.db.QueryRow("PRAGMA user_version")
-
-	var version int
-	err := row.Scan(&version)
-	return version, err
-}
-
-func (d *Database) setSchemaVersion(version int) error {
-	_, err := d.db.Exec("PRAGMA user_version = " + strconv.Itoa(version))
-	return err
-}
-
-func (d *Database) Forget(me string) error {
-	_, err := d.db.Exec(`
-		DELETE FROM profile WHERE Me = ?;
-		DELETE FROM method WHERE Me = ?;
-		DELETE FROM session WHERE Me = ?;
-		DELETE FROM token WHERE Me = ?;
-		DELETE FROM login WHERE Me = ?;
-	`,
-		me, me, me, me, me)
-
-	return err
+	return tx.Commit()
 }
 
 func (d *Database) Close() error {
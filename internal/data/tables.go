@@ -0,0 +1,100 @@
+package data
+
+import (
+	"strings"
+)
+
+// table describes a table, so that its rows can be handled without writing a
+// query for each.
+type table struct {
+	name    string
+	columns []string
+
+	// key lists the columns that make up the primary key.
+	key []string
+
+	// me is the column that holds the profile URL of the user each row belongs
+	// to, if any.
+	me string
+
+	// encrypted lists the columns whose values are encrypted, when a key is
+	// configured.
+	encrypted []string
+
+	// secret lists the columns that hold secrets, or hashes of them, so are left
+	// out when a user's data is exported.
+	secret []string
+}
+
+// tables lists every table that holds data. A table must come after any it
+// references, so that rows can be inserted in order, and before them when
+// rows are removed in order.
+var tables = []table{
+	{name: "profile", key: []string{"Me"}, me: "Me", columns: []string{"Me", "CreatedAt"}},
+	{name: "method", key: []string{"Me", "Provider"}, me: "Me", columns: []string{"Me", "Provider", "Profile"}, encrypted: []string{"Profile"}},
+	{name: "client", key: []string{"ClientID"}, columns: []string{"ClientID", "RedirectURI", "Name", "Logo", "CreatedAt"}},
+	{name: "session", key: []string{"Me"}, me: "Me", columns: []string{"Me", "ResponseType", "Provider", "ProfileURI", "ClientID", "RedirectURI", "Scope", "State", "Code", "CodeChallenge", "CodeChallengeMethod", "CreatedAt"}, encrypted: []string{"ProfileURI", "State", "CodeChallenge"}, secret: []string{"Code"}},
+	{name: "token", key: []string{"ShortToken"}, me: "Me", columns: []string{"ShortToken", "LongTokenHash", "Me", "ClientID", "Scope", "DPoPThumbprint", "Resource", "ParentToken", "CreatedAt", "LastUsedAt", "LastUsedIP", "UseCount"}, encrypted: []string{"LastUsedIP"}, secret: []string{"LongTokenHash"}},
+	{name: "login", key: []string{"ID"}, me: "Me", columns: []string{"ID", "Me", "IP", "UserAgent", "CreatedAt"}, encrypted: []string{"IP", "UserAgent"}, secret: []string{"ID"}},
+	{name: "pushed_request", key: []string{"RequestURI"}, me: "Me", columns: []string{"RequestURI", "ResponseType", "Me", "ClientID", "RedirectURI", "CodeChallenge", "CodeChallengeMethod", "Scope", "State", "CreatedAt"}, encrypted: []string{"State", "CodeChallenge"}, secret: []string{"RequestURI"}},
+	{name: "signing_key", key: []string{"ID"}, columns: []string{"ID", "PrivateKey", "CreatedAt"}, encrypted: []string{"PrivateKey"}},
+	{name: "revoked_token", key: []string{"JTI"}, columns: []string{"JTI", "ExpiresAt"}},
+	{name: "device_authorization", key: []string{"DeviceCode"}, me: "Me", columns: []string{"DeviceCode", "UserCode", "ClientID", "Scope", "Me", "Denied", "CreatedAt", "LastPolledAt"}, secret: []string{"DeviceCode", "UserCode"}},
+	{name: "registered_client", key: []string{"ClientID"}, columns: []string{"ClientID", "SecretHash", "Scopes", "RedirectURIs", "CreatedAt"}},
+	{name: "ticket", key: []string{"Ticket"}, me: "Me", columns: []string{"Ticket", "Me", "Subject", "Resource", "Scope", "CreatedAt"}, secret: []string{"Ticket"}},
+	{name: "audit", key: []string{"ID"}, me: "Me", columns: []string{"ID", "Action", "Me", "ClientID", "Provider", "IP", "UserAgent", "Outcome", "CreatedAt"}, encrypted: []string{"IP", "UserAgent"}},
+	{name: "http_cache", key: []string{"Key"}, columns: []string{"Key", "StatusCode", "Header", "Body", "ETag", "LastModified", "CreatedAt", "ExpiresAt"}},
+}
+
+// Copy inserts every row from src into d, for moving from one database to
+// another. It should be used with an empty database, as rows that already
+// exist will cause it to fail.
+func (d *Database) Copy(src *Database) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tables {
+		if err := copyTable(tx, src, t); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func copyTable(tx sqlTx, src *Database, t table) error {
+	columns := strings.Join(t.columns, ", ")
+
+	rows, err := src.db.Query(`SELECT ` + columns + ` FROM ` + t.name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	stmt, err := tx.Prepare(`INSERT INTO ` + t.name + `(` + columns + `) VALUES (?` + strings.Repeat(`, ?`, len(t.columns)-1) + `)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	values := make([]interface{}, len(t.columns))
+	dest := make([]interface{}, len(t.columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		if _, err := stmt.Exec(values...); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
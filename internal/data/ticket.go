@@ -0,0 +1,73 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Ticket allows the Subject to obtain an access token for the Resource, which
+// belongs to Me, without needing to sign-in. See IndieAuth Ticket Auth.
+type Ticket struct {
+	// Ticket is only stored hashed.
+	Ticket    string
+	Me        string
+	Subject   string
+	Resource  string
+	Scope     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (t Ticket) Expired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
+func (d *Database) CreateTicket(ticket Ticket) error {
+	_, err := d.db.Exec(`INSERT INTO ticket(Ticket, Me, Subject, Resource, Scope, CreatedAt) VALUES (?, ?, ?, ?, ?, ?)`,
+		hashToken(ticket.Ticket),
+		ticket.Me,
+		ticket.Subject,
+		ticket.Resource,
+		ticket.Scope,
+		ticket.CreatedAt)
+
+	return err
+}
+
+// RedeemTicket returns the ticket and removes it, so that it can only be
+// exchanged for an access token once. If it has already been redeemed, or does
+// not exist, sql.ErrNoRows is returned.
+func (d *Database) RedeemTicket(t string) (ticket Ticket, err error) {
+	row := d.db.QueryRow(`SELECT Ticket, Me, Subject, Resource, Scope, CreatedAt FROM ticket WHERE Ticket = ?`,
+		hashToken(t))
+
+	if err = row.Scan(
+		&ticket.Ticket,
+		&ticket.Me,
+		&ticket.Subject,
+		&ticket.Resource,
+		&ticket.Scope,
+		&ticket.CreatedAt,
+	); err != nil {
+		return
+	}
+	ticket.Ticket = t
+	ticket.ExpiresAt = ticket.CreatedAt.Add(d.expiry.Ticket)
+
+	// only the request that removes the ticket may use it, as another may have
+	// read it at the same time
+	result, err := d.db.Exec(`DELETE FROM ticket WHERE Ticket = ?`, hashToken(t))
+	if err != nil {
+		return
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if n == 0 {
+		return Ticket{}, sql.ErrNoRows
+	}
+
+	return
+}
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestTicket(t *testing.T) {
+	eachDatabase(t, Expiry{Ticket: time.Minute}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		now := time.Now().UTC()
+
+		err := db.CreateTicket(Ticket{
+			Ticket:    "abcde",
+			Me:        "https://me.example.com/",
+			Subject:   "https://friend.example.com/",
+			Resource:  "https://me.example.com/private",
+			Scope:     "read",
+			CreatedAt: now,
+		})
+		assert(err).Must.Nil()
+
+		var stored string
+		assert(db.db.QueryRow(`SELECT Ticket FROM ticket`).Scan(&stored)).Must.Nil()
+		assert(stored != "abcde").True()
+
+		ticket, err := db.RedeemTicket("abcde")
+		assert(err).Must.Nil()
+		assert(ticket.Ticket).Equal("abcde")
+		assert(ticket.Me).Equal("https://me.example.com/")
+		assert(ticket.Subject).Equal("https://friend.example.com/")
+		assert(ticket.Resource).Equal("https://me.example.com/private")
+		assert(ticket.Scope).Equal("read")
+		assert(ticket.CreatedAt).WithinDuration(now, 10*time.Millisecond)
+		assert(ticket.Expired()).False()
+
+		_, err = db.RedeemTicket("abcde")
+		assert(err).Equal(sql.ErrNoRows)
+	})
+}
+
+func TestTicketRedeemedConcurrently(t *testing.T) {
+	eachDatabase(t, Expiry{Ticket: time.Minute}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		err := db.CreateTicket(Ticket{
+			Ticket:    "abcde",
+			Me:        "https://me.example.com/",
+			Subject:   "https://friend.example.com/",
+			CreatedAt: time.Now().UTC(),
+		})
+		assert(err).Must.Nil()
+
+		var wg sync.WaitGroup
+		redeemed := make(chan bool, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := db.RedeemTicket("abcde")
+				redeemed <- err == nil
+			}()
+		}
+		wg.Wait()
+		close(redeemed)
+
+		successes := 0
+		for ok := range redeemed {
+			if ok {
+				successes++
+			}
+		}
+		assert(successes <= 1).True()
+
+		_, err = db.RedeemTicket("abcde")
+		assert(err).Equal(sql.ErrNoRows)
+	})
+}
+
+func TestTicketWithExpiry(t *testing.T) {
+	eachDatabase(t, Expiry{Ticket: -time.Second}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		err := db.CreateTicket(Ticket{
+			Ticket:    "abcde",
+			Me:        "https://me.example.com/",
+			Subject:   "https://friend.example.com/",
+			CreatedAt: time.Now().UTC(),
+		})
+		assert(err).Must.Nil()
+
+		ticket, err := db.RedeemTicket("abcde")
+		assert(err).Must.Nil()
+		assert(ticket.Expired()).True()
+	})
+}
@@ -2,10 +2,13 @@ package data
 
 import (
 	"crypto/sha256"
+	"database/sql"
 	"encoding/base64"
 	"errors"
 	"strings"
 	"time"
+
+	"hawx.me/code/relme-auth/internal/jwt"
 )
 
 const (
@@ -31,6 +34,8 @@ func NewToken(generator func(int) (string, error), code Code) (Token, string, er
 		Me:            code.Me,
 		ClientID:      code.ClientID,
 		Scope:         code.Scope,
+		Resource:      code.Resource,
+		ParentToken:   code.ParentToken,
 		CreatedAt:     time.Now(),
 	}, tokenPrefix + "_" + shortToken + "_" + longToken, nil
 }
@@ -42,6 +47,28 @@ type Token struct {
 	ClientID      string
 	Scope         string
 	CreatedAt     time.Time
+
+	// Resource is the URL the token grants access to, it is only set for tokens
+	// issued in exchange for a ticket or another token.
+	Resource string
+
+	// ParentToken is the ShortToken of the token this was exchanged for, if any.
+	// Revoking the parent also revokes this token.
+	ParentToken string
+
+	// ExpiresAt is only set for JWT access tokens, opaque tokens do not expire.
+	ExpiresAt time.Time
+
+	// DPoPThumbprint is the thumbprint of the key the token is bound to, if the
+	// token was requested with a DPoP proof.
+	DPoPThumbprint string
+
+	// LastUsedAt and LastUsedIP describe when the token was last verified or
+	// introspected, and UseCount how many times it has been. LastUsedAt is zero
+	// if the token has not been used.
+	LastUsedAt time.Time
+	LastUsedIP string
+	UseCount   int64
 }
 
 func hashToken(t string) string {
@@ -51,24 +78,31 @@ func hashToken(t string) string {
 }
 
 func (d *Database) CreateToken(token Token) error {
-	_, err := d.db.Exec(`INSERT INTO token(ShortToken, LongTokenHash, Me, ClientID, Scope, CreatedAt) VALUES (?, ?, ?, ?, ?, ?)`,
+	_, err := d.db.Exec(`INSERT INTO token(ShortToken, LongTokenHash, Me, ClientID, Scope, CreatedAt, DPoPThumbprint, Resource, ParentToken) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		token.ShortToken,
 		token.LongTokenHash,
 		token.Me,
 		token.ClientID,
 		token.Scope,
-		token.CreatedAt)
+		token.CreatedAt,
+		token.DPoPThumbprint,
+		token.Resource,
+		token.ParentToken)
 
 	return err
 }
 
 func (d *Database) Token(t string) (token Token, err error) {
+	if jwt.Looks(t) {
+		return d.accessToken(t)
+	}
+
 	parts := strings.Split(t, "_")
 	if len(parts) != 3 && parts[0] != tokenPrefix {
 		return token, errors.New("invalid token")
 	}
 
-	row := d.db.QueryRow(`SELECT ShortToken, LongTokenHash, Me, ClientID, Scope, CreatedAt FROM token WHERE ShortToken = ? AND LongTokenHash = ?`,
+	row := d.db.QueryRow(`SELECT ShortToken, LongTokenHash, Me, ClientID, Scope, CreatedAt, DPoPThumbprint, Resource, ParentToken FROM token WHERE ShortToken = ? AND LongTokenHash = ?`,
 		parts[1], hashToken(parts[2]))
 
 	err = row.Scan(
@@ -77,12 +111,15 @@ func (d *Database) Token(t string) (token Token, err error) {
 		&token.Me,
 		&token.ClientID,
 		&token.Scope,
-		&token.CreatedAt)
+		&token.CreatedAt,
+		&token.DPoPThumbprint,
+		&token.Resource,
+		&token.ParentToken)
 	return
 }
 
 func (d *Database) Tokens(me string) (tokens []Token, err error) {
-	rows, err := d.db.Query(`SELECT ShortToken, Me, ClientID, Scope, CreatedAt FROM token WHERE Me = ?`,
+	rows, err := d.db.Query(`SELECT ShortToken, Me, ClientID, Scope, CreatedAt, Resource, LastUsedAt, LastUsedIP, UseCount FROM token WHERE Me = ?`,
 		me)
 	if err != nil {
 		return
@@ -91,15 +128,24 @@ func (d *Database) Tokens(me string) (tokens []Token, err error) {
 
 	for rows.Next() {
 		var token Token
+		var lastUsedAt sql.NullTime
 		if err = rows.Scan(
 			&token.ShortToken,
 			&token.Me,
 			&token.ClientID,
 			&token.Scope,
 			&token.CreatedAt,
+			&token.Resource,
+			&lastUsedAt,
+			&token.LastUsedIP,
+			&token.UseCount,
 		); err != nil {
 			return
 		}
+		token.LastUsedAt = lastUsedAt.Time
+		if err = d.decryptAll(&token.LastUsedIP); err != nil {
+			return
+		}
 
 		tokens = append(tokens, token)
 	}
@@ -108,14 +154,144 @@ func (d *Database) Tokens(me string) (tokens []Token, err error) {
 	return
 }
 
+// AuthorizedClient is a client that has been issued tokens for a user.
+type AuthorizedClient struct {
+	ClientID string
+	Name     string
+	Logo     string
+
+	// Scopes are the scopes granted by any of the tokens.
+	Scopes []string
+
+	// CreatedAt is when the first of the tokens was issued.
+	CreatedAt time.Time
+
+	// LastUsedAt is when any of the tokens was last used, or zero if none have
+	// been.
+	LastUsedAt time.Time
+
+	Tokens []Token
+}
+
+// AuthorizedClients returns the tokens issued for me grouped by the client they
+// were issued to, with the client's name and logo if it has been fetched.
+func (d *Database) AuthorizedClients(me string) (clients []AuthorizedClient, err error) {
+	rows, err := d.db.Query(`SELECT token.ShortToken, token.Me, token.ClientID, token.Scope, token.CreatedAt, token.Resource, token.LastUsedAt, token.LastUsedIP, token.UseCount, COALESCE(client.Name, ''), COALESCE(client.Logo, '')
+    FROM token LEFT JOIN client ON client.ClientID = token.ClientID
+    WHERE token.Me = ?
+    ORDER BY token.ClientID, token.CreatedAt`,
+		me)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token Token
+		var lastUsedAt sql.NullTime
+		var name, logo string
+		if err = rows.Scan(
+			&token.ShortToken,
+			&token.Me,
+			&token.ClientID,
+			&token.Scope,
+			&token.CreatedAt,
+			&token.Resource,
+			&lastUsedAt,
+			&token.LastUsedIP,
+			&token.UseCount,
+			&name,
+			&logo,
+		); err != nil {
+			return
+		}
+		token.LastUsedAt = lastUsedAt.Time
+		if err = d.decryptAll(&token.LastUsedIP); err != nil {
+			return
+		}
+
+		if len(clients) == 0 || clients[len(clients)-1].ClientID != token.ClientID {
+			if name == "" {
+				name = token.ClientID
+			}
+
+			clients = append(clients, AuthorizedClient{
+				ClientID:  token.ClientID,
+				Name:      name,
+				Logo:      logo,
+				CreatedAt: token.CreatedAt,
+			})
+		}
+
+		client := &clients[len(clients)-1]
+		for _, scope := range strings.Fields(token.Scope) {
+			if !containsString(client.Scopes, scope) {
+				client.Scopes = append(client.Scopes, scope)
+			}
+		}
+		if token.LastUsedAt.After(client.LastUsedAt) {
+			client.LastUsedAt = token.LastUsedAt
+		}
+		client.Tokens = append(client.Tokens, token)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Database) RevokeToken(shortToken string) error {
-	_, err := d.db.Exec(`DELETE FROM token WHERE ShortToken = ?`, shortToken)
+	return d.revokeTokens(`ShortToken = ?`, shortToken)
+}
 
-	return err
+// RevokeUserToken revokes the token, if it was issued for me.
+func (d *Database) RevokeUserToken(me, shortToken string) error {
+	return d.revokeTokens(`Me = ? AND ShortToken = ?`, me, shortToken)
 }
 
 func (d *Database) RevokeClient(me, clientID string) error {
-	_, err := d.db.Exec(`DELETE FROM token WHERE Me = ? AND ClientID = ?`, me, clientID)
+	return d.revokeTokens(`Me = ? AND ClientID = ?`, me, clientID)
+}
+
+// RevokeAll revokes every token issued for me.
+func (d *Database) RevokeAll(me string) error {
+	return d.revokeTokens(`Me = ?`, me)
+}
+
+// revokeTokens deletes the tokens matching the where clause, along with any
+// tokens that were exchanged for them.
+func (d *Database) revokeTokens(where string, args ...interface{}) error {
+	return d.deleteTokens(d.db, exchangedTokens(where), args...)
+}
+
+// exchangedTokens returns a where clause matching the tokens that where matches,
+// along with any tokens that were exchanged for them.
+func exchangedTokens(where string) string {
+	return `ShortToken IN (
+		WITH RECURSIVE revoked(ShortToken) AS (
+			SELECT ShortToken FROM token WHERE ` + where + `
+			UNION
+			SELECT token.ShortToken FROM token JOIN revoked ON token.ParentToken = revoked.ShortToken
+		)
+		SELECT ShortToken FROM revoked
+	)`
+}
+
+// deleteTokens denies and then deletes the tokens matching the where clause.
+func (d *Database) deleteTokens(db execer, where string, args ...interface{}) error {
+	if err := d.denyTokens(db, where, args...); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`DELETE FROM token WHERE `+where, args...)
 
 	return err
 }
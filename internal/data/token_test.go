@@ -2,7 +2,6 @@ package data
 
 import (
 	"database/sql"
-	"net/http"
 	"testing"
 	"time"
 
@@ -10,97 +9,226 @@ import (
 )
 
 func TestToken(t *testing.T) {
-	assert := assert.Wrap(t)
+	eachDatabase(t, Expiry{}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+		err := db.CreateToken(Token{
+			ShortToken:     "abcde",
+			LongTokenHash:  "Ngi8oeROpsTSaOttsCJgJpiSwLQrhrvx53pvoWw8koI",
+			Me:             "http://john.doe.example.com",
+			ClientID:       "http://client.example.com",
+			Scope:          "create media",
+			CreatedAt:      now,
+			DPoPThumbprint: "thumb",
+			Resource:       "http://john.doe.example.com/private",
+		})
+		assert(err).Nil()
+
+		token, err := db.Token("relmeauth_abcde_xyz")
+		assert(err).Nil()
+		assert(token.DPoPThumbprint).Equal("thumb")
+		assert(token.Resource).Equal("http://john.doe.example.com/private")
+		assert(token.ShortToken).Equal("abcde")
+		assert(token.Me).Equal("http://john.doe.example.com")
+		assert(token.ClientID).Equal("http://client.example.com")
+		assert(token.Scope).Equal("create media")
+		assert(token.CreatedAt.Equal(now)).True()
+
+		tokens, err := db.Tokens("http://john.doe.example.com")
+		assert(err).Nil()
+		if assert(tokens).Len(1) {
+			assert(tokens[0].ShortToken).Equal("abcde")
+			assert(tokens[0].Me).Equal("http://john.doe.example.com")
+			assert(tokens[0].ClientID).Equal("http://client.example.com")
+			assert(tokens[0].Scope).Equal("create media")
+			assert(tokens[0].CreatedAt.Equal(now)).True()
+			assert(tokens[0].Resource).Equal("http://john.doe.example.com/private")
+		}
+
+		err = db.RevokeToken("abcde")
+		assert(err).Nil()
+
+		_, err = db.Token("relmeauth_abcde_xyz")
+		assert(err).Equal(sql.ErrNoRows)
+
+		tokens, err = db.Tokens("http://john.doe.example.com")
+		assert(err).Nil()
+		assert(tokens).Len(0)
+
+		err = db.RevokeToken("abcde")
+		assert(err).Nil()
+	})
+}
+
+func TestTokenRevokeByClient(t *testing.T) {
+	eachDatabase(t, Expiry{}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+		err := db.CreateToken(Token{
+			ShortToken:    "abcde",
+			LongTokenHash: "Ngi8oeROpsTSaOttsCJgJpiSwLQrhrvx53pvoWw8koI",
+			Me:            "http://john.doe.example.com",
+			ClientID:      "http://client.example.com",
+			Scope:         "create media",
+			CreatedAt:     now,
+		})
+		assert(err).Nil()
+
+		token, err := db.Token("relmeauth_abcde_xyz")
+		assert(err).Nil()
+		assert(token.ShortToken).Equal("abcde")
+		assert(token.Me).Equal("http://john.doe.example.com")
+		assert(token.ClientID).Equal("http://client.example.com")
+		assert(token.Scope).Equal("create media")
+		assert(token.CreatedAt.Equal(now)).True()
+
+		tokens, err := db.Tokens("http://john.doe.example.com")
+		assert(err).Nil()
+		if assert(tokens).Len(1) {
+			assert(tokens[0].ShortToken).Equal("abcde")
+			assert(tokens[0].Me).Equal("http://john.doe.example.com")
+			assert(tokens[0].ClientID).Equal("http://client.example.com")
+			assert(tokens[0].Scope).Equal("create media")
+			assert(tokens[0].CreatedAt.Equal(now)).True()
+		}
+
+		err = db.RevokeClient("http://john.doe.example.com", "http://client.example.com")
+		assert(err).Nil()
+
+		_, err = db.Token("relmeauth_abcde_xyz")
+		assert(err).Equal(sql.ErrNoRows)
+
+		err = db.RevokeClient("http://john.doe.example.com", "http://client.example.com")
+		assert(err).Nil()
+	})
+}
+
+func TestTokenRevokeUserToken(t *testing.T) {
+	eachDatabase(t, Expiry{}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		err := db.CreateToken(Token{
+			ShortToken:    "abcde",
+			LongTokenHash: "Ngi8oeROpsTSaOttsCJgJpiSwLQrhrvx53pvoWw8koI",
+			Me:            "http://john.doe.example.com",
+			ClientID:      "http://client.example.com",
+			CreatedAt:     time.Now().UTC(),
+		})
+		assert(err).Must.Nil()
+
+		// another user can't revoke the token
+		err = db.RevokeUserToken("http://jane.doe.example.com", "abcde")
+		assert(err).Nil()
 
-	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
-	defer db.Close()
+		_, err = db.Token("relmeauth_abcde_xyz")
+		assert(err).Nil()
 
-	now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+		err = db.RevokeUserToken("http://john.doe.example.com", "abcde")
+		assert(err).Nil()
 
-	err := db.CreateToken(Token{
-		ShortToken:    "abcde",
-		LongTokenHash: "Ngi8oeROpsTSaOttsCJgJpiSwLQrhrvx53pvoWw8koI",
-		Me:            "http://john.doe.example.com",
-		ClientID:      "http://client.example.com",
-		Scope:         "create media",
-		CreatedAt:     now,
+		_, err = db.Token("relmeauth_abcde_xyz")
+		assert(err).Equal(sql.ErrNoRows)
 	})
-	assert(err).Nil()
-
-	token, err := db.Token("relmeauth_abcde_xyz")
-	assert(err).Nil()
-	assert(token.ShortToken).Equal("abcde")
-	assert(token.Me).Equal("http://john.doe.example.com")
-	assert(token.ClientID).Equal("http://client.example.com")
-	assert(token.Scope).Equal("create media")
-	assert(token.CreatedAt).Equal(now)
-
-	tokens, err := db.Tokens("http://john.doe.example.com")
-	assert(err).Nil()
-	if assert(tokens).Len(1) {
-		assert(tokens[0].ShortToken).Equal("abcde")
-		assert(tokens[0].Me).Equal("http://john.doe.example.com")
-		assert(tokens[0].ClientID).Equal("http://client.example.com")
-		assert(tokens[0].Scope).Equal("create media")
-		assert(tokens[0].CreatedAt).Equal(now)
-	}
-
-	err = db.RevokeToken("abcde")
-	assert(err).Nil()
-
-	_, err = db.Token("relmeauth_abcde_xyz")
-	assert(err).Equal(sql.ErrNoRows)
-
-	tokens, err = db.Tokens("http://john.doe.example.com")
-	assert(err).Nil()
-	assert(tokens).Len(0)
-
-	err = db.RevokeToken("abcde")
-	assert(err).Nil()
 }
 
-func TestTokenRevokeByClient(t *testing.T) {
-	assert := assert.Wrap(t)
+func TestTokenRevokeCascades(t *testing.T) {
+	eachDatabase(t, Expiry{AccessToken: time.Hour}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		for _, token := range []Token{
+			{ShortToken: "parent", LongTokenHash: hashToken("p"), Me: "http://john.doe.example.com", ClientID: "http://client.example.com"},
+			{ShortToken: "child", LongTokenHash: hashToken("c"), Me: "http://john.doe.example.com", ClientID: "http://micropub.example.com", ParentToken: "parent"},
+			{ShortToken: "grandchild", LongTokenHash: hashToken("g"), Me: "http://john.doe.example.com", ClientID: "http://media.example.com", ParentToken: "child"},
+			{ShortToken: "other", LongTokenHash: hashToken("o"), Me: "http://john.doe.example.com", ClientID: "http://other.example.com"},
+		} {
+			assert(db.CreateToken(token)).Must.Nil()
+		}
+
+		token, err := db.Token("relmeauth_child_c")
+		assert(err).Must.Nil()
+		assert(token.ParentToken).Equal("parent")
+
+		assert(db.RevokeToken("parent")).Must.Nil()
+
+		for _, short := range []string{"parent", "child", "grandchild"} {
+			revoked, err := db.tokenRevoked(short)
+			assert(err).Nil()
+			assert(revoked).True()
+		}
+
+		tokens, err := db.Tokens("http://john.doe.example.com")
+		assert(err).Nil()
+		if assert(tokens).Len(1) {
+			assert(tokens[0].ShortToken).Equal("other")
+		}
+	})
+}
+
+func TestAuthorizedClients(t *testing.T) {
+	eachDatabase(t, Expiry{}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+		err := db.cacheClient(Client{ID: "http://client.example.com", RedirectURI: "http://client.example.com/callback", Name: "My App", Logo: "http://client.example.com/logo.png", UpdatedAt: now})
+		assert(err).Must.Nil()
+
+		for _, token := range []Token{
+			{ShortToken: "a", LongTokenHash: hashToken("a"), Me: "http://john.doe.example.com", ClientID: "http://client.example.com", Scope: "create", CreatedAt: now.Add(time.Minute)},
+			{ShortToken: "b", LongTokenHash: hashToken("b"), Me: "http://john.doe.example.com", ClientID: "http://client.example.com", Scope: "create media", CreatedAt: now},
+			{ShortToken: "c", LongTokenHash: hashToken("c"), Me: "http://john.doe.example.com", ClientID: "http://other.example.com", Scope: "read", CreatedAt: now},
+			{ShortToken: "d", LongTokenHash: hashToken("d"), Me: "http://someone.example.com", ClientID: "http://client.example.com", Scope: "read", CreatedAt: now},
+		} {
+			assert(db.CreateToken(token)).Must.Nil()
+		}
+
+		clients, err := db.AuthorizedClients("http://john.doe.example.com")
+		assert(err).Must.Nil()
+		assert(clients).Must.Len(2)
+
+		assert(clients[0].ClientID).Equal("http://client.example.com")
+		assert(clients[0].Name).Equal("My App")
+		assert(clients[0].Logo).Equal("http://client.example.com/logo.png")
+		assert(clients[0].Scopes).Equal([]string{"create", "media"})
+		assert(clients[0].CreatedAt.Equal(now)).True()
+		if assert(clients[0].Tokens).Len(2) {
+			assert(clients[0].Tokens[0].ShortToken).Equal("b")
+			assert(clients[0].Tokens[1].ShortToken).Equal("a")
+		}
+
+		assert(clients[1].ClientID).Equal("http://other.example.com")
+		assert(clients[1].Name).Equal("http://other.example.com")
+		assert(clients[1].Logo).Equal("")
+		assert(clients[1].Scopes).Equal([]string{"read"})
+		assert(clients[1].Tokens).Len(1)
+	})
+}
+
+func TestTokenRevokeAll(t *testing.T) {
+	eachDatabase(t, Expiry{}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+
+		for _, token := range []Token{
+			{ShortToken: "a", LongTokenHash: hashToken("a"), Me: "http://john.doe.example.com", ClientID: "http://client.example.com"},
+			{ShortToken: "b", LongTokenHash: hashToken("b"), Me: "http://john.doe.example.com", ClientID: "http://other.example.com"},
+			{ShortToken: "c", LongTokenHash: hashToken("c"), Me: "http://someone.example.com", ClientID: "http://client.example.com"},
+		} {
+			assert(db.CreateToken(token)).Must.Nil()
+		}
 
-	db, _ := Open("file::memory:?mode=memory&cache=shared", http.DefaultClient, &fakeCookieStore{}, Expiry{})
-	defer db.Close()
+		err := db.RevokeAll("http://john.doe.example.com")
+		assert(err).Must.Nil()
 
-	now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+		tokens, err := db.Tokens("http://john.doe.example.com")
+		assert(err).Nil()
+		assert(tokens).Len(0)
 
-	err := db.CreateToken(Token{
-		ShortToken:    "abcde",
-		LongTokenHash: "Ngi8oeROpsTSaOttsCJgJpiSwLQrhrvx53pvoWw8koI",
-		Me:            "http://john.doe.example.com",
-		ClientID:      "http://client.example.com",
-		Scope:         "create media",
-		CreatedAt:     now,
+		tokens, err = db.Tokens("http://someone.example.com")
+		assert(err).Nil()
+		assert(tokens).Len(1)
 	})
-	assert(err).Nil()
-
-	token, err := db.Token("relmeauth_abcde_xyz")
-	assert(err).Nil()
-	assert(token.ShortToken).Equal("abcde")
-	assert(token.Me).Equal("http://john.doe.example.com")
-	assert(token.ClientID).Equal("http://client.example.com")
-	assert(token.Scope).Equal("create media")
-	assert(token.CreatedAt).Equal(now)
-
-	tokens, err := db.Tokens("http://john.doe.example.com")
-	assert(err).Nil()
-	if assert(tokens).Len(1) {
-		assert(tokens[0].ShortToken).Equal("abcde")
-		assert(tokens[0].Me).Equal("http://john.doe.example.com")
-		assert(tokens[0].ClientID).Equal("http://client.example.com")
-		assert(tokens[0].Scope).Equal("create media")
-		assert(tokens[0].CreatedAt).Equal(now)
-	}
-
-	err = db.RevokeClient("http://john.doe.example.com", "http://client.example.com")
-	assert(err).Nil()
-
-	_, err = db.Token("relmeauth_abcde_xyz")
-	assert(err).Equal(sql.ErrNoRows)
-
-	err = db.RevokeClient("http://john.doe.example.com", "http://client.example.com")
-	assert(err).Nil()
 }
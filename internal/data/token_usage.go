@@ -0,0 +1,145 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenUse is the use of a token that has not yet been written.
+type tokenUse struct {
+	count      int64
+	lastUsedAt time.Time
+	lastUsedIP string
+}
+
+// tokenUsage buffers the uses of tokens, so that verifying a token does not
+// have to wait for a write.
+type tokenUsage struct {
+	mu   sync.Mutex
+	uses map[string]*tokenUse
+}
+
+func (u *tokenUsage) add(shortToken string, use tokenUse) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.uses == nil {
+		u.uses = map[string]*tokenUse{}
+	}
+
+	existing, ok := u.uses[shortToken]
+	if !ok {
+		u.uses[shortToken] = &use
+		return
+	}
+
+	existing.count += use.count
+	if use.lastUsedAt.After(existing.lastUsedAt) {
+		existing.lastUsedAt = use.lastUsedAt
+		existing.lastUsedIP = use.lastUsedIP
+	}
+}
+
+func (u *tokenUsage) take() map[string]*tokenUse {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	uses := u.uses
+	u.uses = nil
+	return uses
+}
+
+// RecordTokenUse notes that the token was used from ip at the given time. It
+// is not written until FlushTokenUses is called.
+func (d *Database) RecordTokenUse(shortToken, ip string, at time.Time) {
+	d.usage.add(shortToken, tokenUse{count: 1, lastUsedAt: at, lastUsedIP: ip})
+}
+
+// FlushTokenUses writes the uses recorded since it was last called, updating
+// each token's LastUsedAt, LastUsedIP and UseCount. If the write fails the uses
+// are kept, to be written by the next call.
+func (d *Database) FlushTokenUses() error {
+	uses := d.usage.take()
+	if len(uses) == 0 {
+		return nil
+	}
+
+	if err := d.writeTokenUses(uses); err != nil {
+		for shortToken, use := range uses {
+			d.usage.add(shortToken, *use)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (d *Database) writeTokenUses(uses map[string]*tokenUse) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`UPDATE token SET UseCount = UseCount + ?, LastUsedAt = ?, LastUsedIP = ? WHERE ShortToken = ?`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for shortToken, use := range uses {
+		ip, err := d.encrypt(use.lastUsedIP)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := stmt.Exec(use.count, use.lastUsedAt, ip, shortToken); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RevokeUnused revokes the tokens that have not been used since before, or
+// that were issued before then and have never been used, along with any tokens
+// exchanged for them. It returns every token revoked.
+func (d *Database) RevokeUnused(before time.Time) ([]Token, error) {
+	where := exchangedTokens(`COALESCE(LastUsedAt, CreatedAt) < ?`)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT ShortToken, Me, ClientID FROM token WHERE `+where, before)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var tokens []Token
+	for rows.Next() {
+		var token Token
+		if err := rows.Scan(&token.ShortToken, &token.Me, &token.ClientID); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(tokens) == 0 {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := d.deleteTokens(tx, where, before); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tokens, tx.Commit()
+}
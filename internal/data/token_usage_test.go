@@ -0,0 +1,90 @@
+package data
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestTokenUses(t *testing.T) {
+	eachDatabase(t, Expiry{}, func(t *testing.T, db *Database) {
+		assert := assert.Wrap(t)
+		assert(db.SetEncryptionKeys([][]byte{[]byte("0123456789abcdef0123456789abcdef")})).Must.Nil()
+
+		now := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+		for _, token := range []Token{
+			{ShortToken: "used", LongTokenHash: hashToken("u"), Me: "http://john.doe.example.com", ClientID: "http://client.example.com", CreatedAt: now},
+			{ShortToken: "unused", LongTokenHash: hashToken("n"), Me: "http://john.doe.example.com", ClientID: "http://other.example.com", CreatedAt: now},
+		} {
+			assert(db.CreateToken(token)).Must.Nil()
+		}
+
+		db.RecordTokenUse("used", "192.0.2.1", now.Add(time.Minute))
+		db.RecordTokenUse("used", "192.0.2.2", now.Add(2*time.Minute))
+
+		// not written until flushed
+		tokens, err := db.Tokens("http://john.doe.example.com")
+		assert(err).Must.Nil()
+		for _, token := range tokens {
+			assert(token.UseCount).Equal(int64(0))
+			assert(token.LastUsedAt.IsZero()).True()
+		}
+
+		assert(db.FlushTokenUses()).Must.Nil()
+		db.RecordTokenUse("used", "192.0.2.3", now.Add(time.Hour))
+		assert(db.FlushTokenUses()).Must.Nil()
+		assert(db.FlushTokenUses()).Must.Nil()
+
+		var storedIP string
+		assert(db.db.QueryRow(`SELECT LastUsedIP FROM token WHERE ShortToken = 'used'`).Scan(&storedIP)).Must.Nil()
+		assert(storedIP != "192.0.2.3").True()
+
+		tokens, err = db.Tokens("http://john.doe.example.com")
+		assert(err).Must.Nil()
+		assert(tokens).Must.Len(2)
+		for _, token := range tokens {
+			if token.ShortToken == "used" {
+				assert(token.UseCount).Equal(int64(3))
+				assert(token.LastUsedAt.Equal(now.Add(time.Hour))).True()
+				assert(token.LastUsedIP).Equal("192.0.2.3")
+			} else {
+				assert(token.UseCount).Equal(int64(0))
+				assert(token.LastUsedAt.IsZero()).True()
+				assert(token.LastUsedIP).Equal("")
+			}
+		}
+
+		clients, err := db.AuthorizedClients("http://john.doe.example.com")
+		assert(err).Must.Nil()
+		assert(clients).Must.Len(2)
+		assert(clients[0].LastUsedAt.Equal(now.Add(time.Hour))).True()
+		assert(clients[1].LastUsedAt.IsZero()).True()
+
+		// exchanged for the unused token, so revoked with it
+		assert(db.CreateToken(Token{ShortToken: "exchanged", LongTokenHash: hashToken("e"), Me: "http://john.doe.example.com", ClientID: "http://service.example.com", CreatedAt: now.Add(time.Hour), ParentToken: "unused"})).Must.Nil()
+
+		revoked, err := db.RevokeUnused(now.Add(30 * time.Minute))
+		assert(err).Must.Nil()
+		if assert(revoked).Len(2) {
+			sort.Slice(revoked, func(i, j int) bool { return revoked[i].ShortToken > revoked[j].ShortToken })
+			assert(revoked[0].ShortToken).Equal("unused")
+			assert(revoked[0].Me).Equal("http://john.doe.example.com")
+			assert(revoked[0].ClientID).Equal("http://other.example.com")
+			assert(revoked[1].ShortToken).Equal("exchanged")
+			assert(revoked[1].ClientID).Equal("http://service.example.com")
+		}
+
+		tokens, err = db.Tokens("http://john.doe.example.com")
+		assert(err).Must.Nil()
+		if assert(tokens).Len(1) {
+			assert(tokens[0].ShortToken).Equal("used")
+		}
+
+		revoked, err = db.RevokeUnused(now.Add(30 * time.Minute))
+		assert(err).Must.Nil()
+		assert(revoked).Len(0)
+	})
+}
@@ -0,0 +1,119 @@
+// Package dpop verifies proofs of possession for sender-constrained access
+// tokens, as defined by RFC 9449.
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/jwt"
+)
+
+var (
+	// ErrInvalidProof is returned when a proof is malformed, not signed by the
+	// key it contains, or does not match the request it was sent with.
+	ErrInvalidProof = errors.New("dpop: invalid proof")
+
+	// ErrReplayed is returned when a proof has already been used.
+	ErrReplayed = errors.New("dpop: proof has already been used")
+)
+
+const proofType = "dpop+jwt"
+
+type proofClaims struct {
+	JWTID           string `json:"jti"`
+	Method          string `json:"htm"`
+	URI             string `json:"htu"`
+	IssuedAt        int64  `json:"iat"`
+	AccessTokenHash string `json:"ath,omitempty"`
+}
+
+// Verifier checks proofs, remembering those it has seen recently so that they
+// can not be replayed.
+type Verifier struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// New returns a Verifier that accepts proofs issued within window of the
+// current time.
+func New(window time.Duration) *Verifier {
+	return &Verifier{
+		window: window,
+		seen:   map[string]time.Time{},
+	}
+}
+
+// Verify checks that proof is signed by the key in its header and was created
+// for a request with the method and uri given. If accessToken is not empty the
+// proof must also contain its hash. It returns the thumbprint of the key.
+func (v *Verifier) Verify(proof, method, uri, accessToken string) (string, error) {
+	var claims proofClaims
+
+	header, err := jwt.Parse(proof, func(header jwt.Header) (*ecdsa.PublicKey, error) {
+		if header.JWK == nil {
+			return nil, ErrInvalidProof
+		}
+		return header.JWK.ECDSA()
+	}, &claims)
+	if err != nil || header.Type != proofType {
+		return "", ErrInvalidProof
+	}
+
+	if claims.JWTID == "" || claims.Method != method || !sameURI(claims.URI, uri) {
+		return "", ErrInvalidProof
+	}
+
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+	now := time.Now()
+	if issuedAt.Before(now.Add(-v.window)) || issuedAt.After(now.Add(v.window)) {
+		return "", ErrInvalidProof
+	}
+
+	if accessToken != "" && claims.AccessTokenHash != jwt.Hash(accessToken) {
+		return "", ErrInvalidProof
+	}
+
+	if !v.claim(claims.JWTID, now) {
+		return "", ErrReplayed
+	}
+
+	return header.JWK.Thumbprint(), nil
+}
+
+func (v *Verifier) claim(jti string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for key, seenAt := range v.seen {
+		if now.Sub(seenAt) > 2*v.window {
+			delete(v.seen, key)
+		}
+	}
+
+	if _, ok := v.seen[jti]; ok {
+		return false
+	}
+
+	v.seen[jti] = now
+	return true
+}
+
+// sameURI compares the URIs ignoring any query or fragment.
+func sameURI(a, b string) bool {
+	aURL, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	bURL, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return aURL.Scheme == bURL.Scheme && aURL.Host == bURL.Host && aURL.Path == bURL.Path
+}
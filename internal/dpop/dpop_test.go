@@ -0,0 +1,94 @@
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/jwt"
+)
+
+func makeProof(key *ecdsa.PrivateKey, jti, method, uri string, issuedAt time.Time, accessToken string) string {
+	jwk := jwt.PublicKey("", &key.PublicKey)
+
+	claims := proofClaims{
+		JWTID:    jti,
+		Method:   method,
+		URI:      uri,
+		IssuedAt: issuedAt.Unix(),
+	}
+	if accessToken != "" {
+		claims.AccessTokenHash = jwt.Hash(accessToken)
+	}
+
+	proof, _ := jwt.Sign(key, jwt.Header{Type: "dpop+jwt", JWK: &jwk}, claims)
+	return proof
+}
+
+func TestVerify(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk := jwt.PublicKey("", &key.PublicKey)
+	verifier := New(time.Minute)
+
+	thumbprint, err := verifier.Verify(makeProof(key, "1", "POST", "https://example.com/token", time.Now(), ""), "POST", "https://example.com/token?a=b", "")
+	assert(err).Nil()
+	assert(thumbprint).Equal(jwk.Thumbprint())
+
+	thumbprint, err = verifier.Verify(makeProof(key, "2", "GET", "https://example.com/token", time.Now(), "abc"), "GET", "https://example.com/token", "abc")
+	assert(err).Nil()
+	assert(thumbprint).Equal(jwk.Thumbprint())
+}
+
+func TestVerifyReplayed(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	verifier := New(time.Minute)
+	proof := makeProof(key, "1", "POST", "https://example.com/token", time.Now(), "")
+
+	_, err := verifier.Verify(proof, "POST", "https://example.com/token", "")
+	assert(err).Nil()
+
+	_, err = verifier.Verify(proof, "POST", "https://example.com/token", "")
+	assert(err).Equal(ErrReplayed)
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	verifier := New(time.Minute)
+
+	testCases := map[string]struct {
+		proof, method, uri, accessToken string
+	}{
+		"malformed": {
+			"what", "POST", "https://example.com/token", "",
+		},
+		"wrong method": {
+			makeProof(key, "1", "GET", "https://example.com/token", time.Now(), ""), "POST", "https://example.com/token", "",
+		},
+		"wrong uri": {
+			makeProof(key, "2", "POST", "https://example.com/other", time.Now(), ""), "POST", "https://example.com/token", "",
+		},
+		"too old": {
+			makeProof(key, "3", "POST", "https://example.com/token", time.Now().Add(-time.Hour), ""), "POST", "https://example.com/token", "",
+		},
+		"missing access token hash": {
+			makeProof(key, "4", "GET", "https://example.com/token", time.Now(), ""), "GET", "https://example.com/token", "abc",
+		},
+		"wrong access token hash": {
+			makeProof(key, "5", "GET", "https://example.com/token", time.Now(), "xyz"), "GET", "https://example.com/token", "abc",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := verifier.Verify(tc.proof, tc.method, tc.uri, tc.accessToken)
+			assert.Equal(t, ErrInvalidProof, err)
+		})
+	}
+}
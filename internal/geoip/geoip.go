@@ -0,0 +1,120 @@
+// Package geoip finds the rough location of an IP address, using a database in
+// the CSV format of DB-IP's free "IP to Country Lite" or "IP to City Lite"
+// downloads.
+package geoip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+type ipRange struct {
+	start, end net.IP
+	location   string
+}
+
+// Database is a list of IP address ranges, and the location of each.
+type Database struct {
+	ranges []ipRange
+}
+
+// Open reads the database at path.
+func Open(path string) (*Database, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Read(file)
+}
+
+// Read reads a database. Each row must start with the first and last IP address
+// of a range, then either have the country code, or the continent, country
+// code, region and city.
+func Read(r io.Reader) (*Database, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	// many ranges share a location, so only keep one copy of each
+	locations := map[string]string{}
+
+	db := &Database{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: expected at least 3 fields, got %d", line, len(record))
+		}
+
+		start, end := net.ParseIP(record[0]), net.ParseIP(record[1])
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("line %d: invalid IP address range", line)
+		}
+
+		location := record[2]
+		if len(record) >= 6 {
+			location = joinNonEmpty(record[5], record[3])
+		}
+		if existing, ok := locations[location]; ok {
+			location = existing
+		} else {
+			locations[location] = location
+		}
+
+		db.ranges = append(db.ranges, ipRange{
+			start:    start.To16(),
+			end:      end.To16(),
+			location: location,
+		})
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+
+	return db, nil
+}
+
+// Locate returns the location of ip, such as "London, GB" or "GB", or an empty
+// string if it is not known.
+func (d *Database) Locate(ip string) string {
+	parsed := net.ParseIP(ip).To16()
+	if parsed == nil {
+		return ""
+	}
+
+	// find the last range starting at or before ip
+	i := sort.Search(len(d.ranges), func(i int) bool {
+		return bytes.Compare(d.ranges[i].start, parsed) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(parsed, d.ranges[i].end) > 0 {
+		return ""
+	}
+
+	return d.ranges[i].location
+}
+
+func joinNonEmpty(parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+
+	return strings.Join(nonEmpty, ", ")
+}
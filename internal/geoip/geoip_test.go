@@ -0,0 +1,59 @@
+package geoip
+
+import (
+	"strings"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+func TestLocateWithCountries(t *testing.T) {
+	db, err := Read(strings.NewReader(`192.0.2.0,192.0.2.255,GB
+2001:db8::,2001:db8::ffff,FR
+198.51.100.0,198.51.100.127,US
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]string{
+		"192.0.2.0":      "GB",
+		"192.0.2.77":     "GB",
+		"192.0.2.255":    "GB",
+		"198.51.100.10":  "US",
+		"198.51.100.200": "",
+		"203.0.113.1":    "",
+		"2001:db8::1":    "FR",
+		"2001:db8::1:0":  "",
+		"not an ip":      "",
+		"":               "",
+	}
+
+	for ip, expected := range testCases {
+		t.Run(ip, func(t *testing.T) {
+			assert.Equal(t, expected, db.Locate(ip))
+		})
+	}
+}
+
+func TestLocateWithCities(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	db, err := Read(strings.NewReader(`192.0.2.0,192.0.2.255,EU,GB,England,London,51.5,-0.1
+198.51.100.0,198.51.100.255,NA,US,,,,
+`))
+	assert(err).Must.Nil()
+
+	assert(db.Locate("192.0.2.1")).Equal("London, GB")
+	assert(db.Locate("198.51.100.1")).Equal("US")
+}
+
+func TestReadWithInvalidRow(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	_, err := Read(strings.NewReader("192.0.2.0,GB\n"))
+	assert(err).NotNil()
+
+	_, err = Read(strings.NewReader("192.0.2.0,what,GB\n"))
+	assert(err).NotNil()
+}
@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+type AuditDB interface {
+	Audit(data.AuditEvent) error
+}
+
+// audit records the event, along with where the request came from. If the event
+// can't be recorded it is logged, but the request carries on.
+func audit(store AuditDB, r *http.Request, event data.AuditEvent) {
+	event.IP = data.RemoteIP(r)
+	event.UserAgent = r.UserAgent()
+	event.CreatedAt = time.Now().UTC()
+
+	if err := store.Audit(event); err != nil {
+		log.Println("handler/audit could not record event:", err)
+	}
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+type fakeAuditStore struct {
+	events []data.AuditEvent
+}
+
+func (s *fakeAuditStore) Audit(event data.AuditEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestAudit(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeAuditStore{}
+
+	r := httptest.NewRequest("POST", "/token", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "test/1.0")
+
+	audit(store, r, data.AuditEvent{
+		Action:  data.AuditTokenCreate,
+		Me:      "https://me.example.com/",
+		Outcome: data.AuditSuccess,
+	})
+
+	assert(store.events).Len(1)
+	event := store.events[0]
+	assert(event.Action).Equal(data.AuditTokenCreate)
+	assert(event.Me).Equal("https://me.example.com/")
+	assert(event.Outcome).Equal(data.AuditSuccess)
+	assert(event.IP).Equal("192.0.2.1")
+	assert(event.UserAgent).Equal("test/1.0")
+	assert(event.CreatedAt.IsZero()).False()
+}
@@ -11,6 +11,7 @@ import (
 )
 
 type CallbackDB interface {
+	AuditDB
 	SaveLogin(http.ResponseWriter, *http.Request, string) error
 	Session(string) (data.Session, error)
 	CreateCode(me, code string, createdAt time.Time) error
@@ -31,6 +32,12 @@ func Callback(store CallbackDB, strat strategy.Strategy, generator func() (strin
 
 		userProfileURL, err := strat.Callback(r.Form)
 		if err != nil {
+			audit(store, r, data.AuditEvent{
+				Action:   data.AuditLogin,
+				Provider: strat.Name(),
+				Outcome:  "provider_error",
+			})
+
 			if err == strategy.ErrUnauthorized {
 				http.Error(w, "the chosen provider says you are unauthorized", http.StatusUnauthorized)
 			} else {
@@ -46,6 +53,14 @@ func Callback(store CallbackDB, strat strategy.Strategy, generator func() (strin
 			return
 		}
 		if session.Expired() {
+			audit(store, r, data.AuditEvent{
+				Action:   data.AuditLogin,
+				Me:       session.Me,
+				ClientID: session.ClientID,
+				Provider: strat.Name(),
+				Outcome:  "session_expired",
+			})
+
 			http.Error(w, "Auth session expired", http.StatusInternalServerError)
 			return
 		}
@@ -76,6 +91,14 @@ func Callback(store CallbackDB, strat strategy.Strategy, generator func() (strin
 
 		store.SaveLogin(w, r, session.Me)
 
+		audit(store, r, data.AuditEvent{
+			Action:   data.AuditLogin,
+			Me:       session.Me,
+			ClientID: session.ClientID,
+			Provider: strat.Name(),
+			Outcome:  data.AuditSuccess,
+		})
+
 		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
 	})
 }
@@ -15,6 +15,7 @@ import (
 func codeGenerator() (string, error) { return "my-code", nil }
 
 type fakeCallbackStore struct {
+	fakeAuditStore
 	session data.Session
 	code    data.Code
 }
@@ -76,6 +77,12 @@ func TestCallback(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, http.StatusFound, resp.StatusCode)
 	assert.Equal(t, "http://example.com/callback?code=my-code&state=my-state", resp.Header.Get("Location"))
+
+	if assert.Equal(t, 1, len(store.events)) {
+		assert.Equal(t, data.AuditLogin, store.events[0].Action)
+		assert.Equal(t, "me", store.events[0].Me)
+		assert.Equal(t, data.AuditSuccess, store.events[0].Outcome)
+	}
 }
 
 func TestCallbackWhenSessionDoesNotExist(t *testing.T) {
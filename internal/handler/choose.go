@@ -1,12 +1,14 @@
 package handler
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"hawx.me/code/mux"
+	"hawx.me/code/relme-auth/internal/config"
 	"hawx.me/code/relme-auth/internal/data"
 	"hawx.me/code/relme-auth/internal/strategy"
 )
@@ -15,17 +17,41 @@ type ChooseDB interface {
 	Login(*http.Request) (string, error)
 	CreateSession(data.Session) error
 	Client(clientID, redirectURI string) (data.Client, error)
+	PushedRequest(string) (data.PushedRequest, error)
+	UsePushedRequest(string) error
+	Profile(string) (data.Profile, error)
 }
 
+type canonicalizer interface {
+	Canonical(ctx context.Context, profile string) (string, error)
+}
+
+// canonicalTimeout is how long finding the canonical "me" may take before the
+// page is shown with "me" as given. It is kept short, and well within the
+// server's write timeout, as discovery fetches the profile again.
+const canonicalTimeout = 3 * time.Second
+
 // Choose finds, for the "me" parameter, all authentication providers that can be
-// used for authentication.
-func Choose(baseURL string, store ChooseDB, strategies strategy.Strategies, chooseTemplate, meTemplate tmpl) http.Handler {
+// used for authentication. Any requested scopes that are not known, or not
+// allowed for the client, are removed.
+//
+// The "me" parameter is replaced by its canonical URL, found by following
+// permanent redirects, so that is who the session, and any tokens issued, are
+// for.
+//
+// If a "request_uri" is given the parameters are taken from the matching
+// pushed authorization request, instead of the query. The request is used up
+// once a session is started for it, so it cannot start another.
+//
+// The methods are found by choose.js, but any cached methods are also listed
+// for when JavaScript is not available.
+func Choose(baseURL string, conf config.Config, store ChooseDB, strategies strategy.Strategies, profiles canonicalizer, chooseTemplate, meTemplate tmpl) http.Handler {
 	return mux.Method{
-		"GET": chooseProvider(baseURL, store, strategies, chooseTemplate, meTemplate),
+		"GET": chooseProvider(baseURL, conf, store, strategies, profiles, chooseTemplate, meTemplate),
 	}
 }
 
-func chooseProvider(baseURL string, store ChooseDB, strategies strategy.Strategies, chooseTemplate, meTemplate tmpl) http.Handler {
+func chooseProvider(baseURL string, conf config.Config, store ChooseDB, strategies strategy.Strategies, profiles canonicalizer, chooseTemplate, meTemplate tmpl) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
 			responseType        = r.FormValue("response_type")
@@ -36,6 +62,7 @@ func chooseProvider(baseURL string, store ChooseDB, strategies strategy.Strategi
 			codeChallengeMethod = r.FormValue("code_challenge_method")
 			scope               = r.FormValue("scope")
 			me                  = r.FormValue("me")
+			requestURI          = r.FormValue("request_uri")
 		)
 
 		clientID = data.ParseClientID(clientID)
@@ -44,6 +71,28 @@ func chooseProvider(baseURL string, store ChooseDB, strategies strategy.Strategi
 			return
 		}
 
+		if requestURI != "" {
+			request, err := store.PushedRequest(requestURI)
+			if err != nil || request.ClientID != clientID {
+				http.Error(w, "request_uri is invalid", http.StatusBadRequest)
+				return
+			}
+			if request.Expired() {
+				http.Error(w, "request_uri has expired", http.StatusBadRequest)
+				return
+			}
+
+			responseType = request.ResponseType
+			redirectURI = request.RedirectURI
+			state = request.State
+			codeChallenge = request.CodeChallenge
+			codeChallengeMethod = request.CodeChallengeMethod
+			scope = request.Scope
+			if request.Me != "" {
+				me = request.Me
+			}
+		}
+
 		client, err := store.Client(clientID, redirectURI)
 		if err != nil {
 			log.Println("handler/choose failed to get client:", err)
@@ -51,7 +100,8 @@ func chooseProvider(baseURL string, store ChooseDB, strategies strategy.Strategi
 			return
 		}
 
-		scopes := strings.Fields(scope)
+		scopes := conf.FilterScopes(client.ID, strings.Fields(scope))
+		scope = strings.Join(config.ScopeNames(scopes), " ")
 
 		if me == "" {
 			if err := meTemplate.ExecuteTemplate(w, "app", meCtx{
@@ -64,6 +114,7 @@ func chooseProvider(baseURL string, store ChooseDB, strategies strategy.Strategi
 				State:               state,
 				ResponseType:        responseType,
 				Scope:               scope,
+				RequestURI:          requestURI,
 			}); err != nil {
 				log.Println("handler/choose failed to write template:", err)
 			}
@@ -85,6 +136,27 @@ func chooseProvider(baseURL string, store ChooseDB, strategies strategy.Strategi
 			return
 		}
 
+		ctx, cancel := context.WithTimeout(r.Context(), canonicalTimeout)
+		canonical, err := profiles.Canonical(ctx, me)
+		cancel()
+		if err != nil {
+			log.Println("handler/choose failed to find canonical me:", err)
+		} else if canonical = data.ParseProfileURL(canonical); canonical != "" {
+			me = canonical
+		}
+
+		if responseType != "id" && responseType != "code" {
+			http.Error(w, "Unknown response_type", http.StatusBadRequest)
+			return
+		}
+
+		if requestURI != "" {
+			if err := store.UsePushedRequest(requestURI); err != nil {
+				http.Error(w, "request_uri is invalid", http.StatusBadRequest)
+				return
+			}
+		}
+
 		switch responseType {
 		case "id":
 			store.CreateSession(data.Session{
@@ -105,13 +177,9 @@ func chooseProvider(baseURL string, store ChooseDB, strategies strategy.Strategi
 				ResponseType:        responseType,
 				CodeChallenge:       codeChallenge,
 				CodeChallengeMethod: codeChallengeMethod,
-				Scope:               strings.Join(scopes, " "),
+				Scope:               scope,
 				CreatedAt:           time.Now().UTC(),
 			})
-
-		default:
-			http.Error(w, "Unknown response_type", http.StatusBadRequest)
-			return
 		}
 
 		tmplCtx := chooseCtx{
@@ -119,6 +187,7 @@ func chooseProvider(baseURL string, store ChooseDB, strategies strategy.Strategi
 			ClientName:          client.Name,
 			CodeChallengeMethod: codeChallengeMethod,
 			Me:                  me,
+			RedirectURI:         redirectURI,
 			Scopes:              scopes,
 		}
 
@@ -126,6 +195,11 @@ func chooseProvider(baseURL string, store ChooseDB, strategies strategy.Strategi
 			tmplCtx.Skip = true
 		}
 
+		if profile, err := store.Profile(me); err == nil {
+			tmplCtx.CachedAt = profile.UpdatedAt.Format("2 Jan")
+			tmplCtx.Methods = cachedMethods(me, redirectURI, profile)
+		}
+
 		if err := chooseTemplate.ExecuteTemplate(w, "app", tmplCtx); err != nil {
 			log.Println("handler/choose failed to write template:", err)
 		}
@@ -137,8 +211,11 @@ type chooseCtx struct {
 	ClientName          string
 	CodeChallengeMethod string
 	Me                  string
-	Scopes              []string
+	RedirectURI         string
+	Scopes              []config.Scope
 	Skip                bool
+	CachedAt            string
+	Methods             []chooseCtxMethod
 }
 
 type meCtx struct {
@@ -146,9 +223,10 @@ type meCtx struct {
 	ClientName          string
 	CodeChallenge       string
 	CodeChallengeMethod string
-	Scopes              []string
+	Scopes              []config.Scope
 	RedirectURI         string
 	State               string
 	ResponseType        string
 	Scope               string
+	RequestURI          string
 }
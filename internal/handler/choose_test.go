@@ -1,13 +1,16 @@
 package handler
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/config"
 	"hawx.me/code/relme-auth/internal/data"
 	"hawx.me/code/relme-auth/internal/strategy"
 )
@@ -16,6 +19,8 @@ type fakeChooseStore struct {
 	session data.Session
 	client  data.Client
 	login   string
+	request data.PushedRequest
+	profile data.Profile
 }
 
 func (s *fakeChooseStore) Login(r *http.Request) (string, error) {
@@ -37,6 +42,37 @@ func (s *fakeChooseStore) Client(clientID, redirectURI string) (data.Client, err
 	return data.Client{}, errors.New("what")
 }
 
+func (s *fakeChooseStore) PushedRequest(requestURI string) (data.PushedRequest, error) {
+	if requestURI == s.request.RequestURI {
+		return s.request, nil
+	}
+	return data.PushedRequest{}, errors.New("nope")
+}
+
+func (s *fakeChooseStore) UsePushedRequest(requestURI string) error {
+	if requestURI == s.request.RequestURI {
+		s.request = data.PushedRequest{}
+		return nil
+	}
+	return data.ErrPushedRequestUsed
+}
+
+func (s *fakeChooseStore) Profile(me string) (data.Profile, error) {
+	if me == s.profile.Me {
+		return s.profile, nil
+	}
+	return data.Profile{}, errors.New("nope")
+}
+
+type fakeCanonicalizer map[string]string
+
+func (c fakeCanonicalizer) Canonical(ctx context.Context, profile string) (string, error) {
+	if canonical, ok := c[profile]; ok {
+		return canonical, nil
+	}
+	return profile, nil
+}
+
 func TestChoose(t *testing.T) {
 	assert := assert.Wrap(t)
 
@@ -49,7 +85,7 @@ func TestChoose(t *testing.T) {
 	}
 	chooseTmpl := &mockTemplate{}
 
-	s := httptest.NewServer(Choose("http://localhost", store, strategy.Strategies{&fakeStrategy{}}, chooseTmpl, nil))
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, chooseTmpl, nil))
 	defer s.Close()
 
 	form := url.Values{
@@ -78,6 +114,81 @@ func TestChoose(t *testing.T) {
 	assert(store.session.State).Equal("some-value")
 }
 
+func TestChooseWithRedirectedMe(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeChooseStore{
+		client: data.Client{
+			ID:          "http://client.example.com/",
+			RedirectURI: "http://client.example.com/callback",
+			Name:        "Client",
+		},
+	}
+	chooseTmpl := &mockTemplate{}
+	profiles := fakeCanonicalizer{"http://me.example.com/": "https://www.me.example.com/"}
+
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, profiles, chooseTmpl, nil))
+	defer s.Close()
+
+	form := url.Values{
+		"me":           {"http://me.example.com"},
+		"client_id":    {"http://client.example.com"},
+		"redirect_uri": {"http://client.example.com/callback"},
+		"state":        {"some-value"},
+	}
+
+	resp, err := http.Get(s.URL + "?" + form.Encode())
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	data, ok := chooseTmpl.Data.(chooseCtx)
+	assert(ok).Must.True()
+	assert(data.Me).Equal("https://www.me.example.com/")
+	assert(store.session.Me).Equal("https://www.me.example.com/")
+}
+
+// failingCanonicalizer records whether it was given a deadline, then fails as if
+// the profile could not be fetched in time.
+type failingCanonicalizer struct {
+	deadline time.Time
+}
+
+func (c *failingCanonicalizer) Canonical(ctx context.Context, profile string) (string, error) {
+	c.deadline, _ = ctx.Deadline()
+	return "", context.DeadlineExceeded
+}
+
+func TestChooseWhenCanonicalFails(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeChooseStore{
+		client: data.Client{
+			ID:          "http://client.example.com/",
+			RedirectURI: "http://client.example.com/callback",
+			Name:        "Client",
+		},
+	}
+	canonicalizer := &failingCanonicalizer{}
+	chooseTmpl := &mockTemplate{}
+
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, canonicalizer, chooseTmpl, nil))
+	defer s.Close()
+
+	form := url.Values{
+		"me":           {"http://me.example.com"},
+		"client_id":    {"http://client.example.com/"},
+		"redirect_uri": {"http://client.example.com/callback"},
+		"state":        {"some-value"},
+	}
+
+	resp, err := http.Get(s.URL + "?" + form.Encode())
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	assert(canonicalizer.deadline).WithinDuration(time.Now().Add(canonicalTimeout), time.Second)
+	assert(store.session.Me).Equal("http://me.example.com/")
+}
+
 func TestChooseWithMissingMe(t *testing.T) {
 	assert := assert.Wrap(t)
 
@@ -90,7 +201,7 @@ func TestChooseWithMissingMe(t *testing.T) {
 	}
 	meTmpl := &mockTemplate{}
 
-	s := httptest.NewServer(Choose("http://localhost", store, strategy.Strategies{&fakeStrategy{}}, nil, meTmpl))
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, nil, meTmpl))
 	defer s.Close()
 
 	form := url.Values{
@@ -127,7 +238,7 @@ func TestChooseWithRecentLogin(t *testing.T) {
 	}
 	chooseTmpl := &mockTemplate{}
 
-	s := httptest.NewServer(Choose("http://localhost", store, strategy.Strategies{&fakeStrategy{}}, chooseTmpl, nil))
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, chooseTmpl, nil))
 	defer s.Close()
 
 	form := url.Values{
@@ -156,12 +267,60 @@ func TestChooseWithRecentLogin(t *testing.T) {
 	assert(store.session.State).Equal("some-value")
 }
 
+func TestChooseWithCachedMethods(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeChooseStore{
+		client: data.Client{
+			ID:          "http://client.example.com/",
+			RedirectURI: "http://client.example.com/callback",
+			Name:        "Client",
+		},
+		profile: data.Profile{
+			Me:        "http://me.example.com/",
+			UpdatedAt: time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC),
+			Methods: []data.Method{
+				{Provider: "github", Profile: "https://github.com/me"},
+			},
+		},
+	}
+	chooseTmpl := &mockTemplate{}
+
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, chooseTmpl, nil))
+	defer s.Close()
+
+	form := url.Values{
+		"me":           {"http://me.example.com"},
+		"client_id":    {"http://client.example.com"},
+		"redirect_uri": {"http://client.example.com/callback"},
+		"state":        {"some-value"},
+	}
+
+	resp, err := http.Get(s.URL + "?" + form.Encode())
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	data, ok := chooseTmpl.Data.(chooseCtx)
+	assert(ok).Must.True()
+	assert(data.CachedAt).Equal("4 Mar")
+	assert(data.Methods).Equal([]chooseCtxMethod{{
+		Query: url.Values{
+			"me":           {"http://me.example.com/"},
+			"provider":     {"github"},
+			"profile":      {"https://github.com/me"},
+			"redirect_uri": {"http://client.example.com/callback"},
+		}.Encode(),
+		StrategyName: "github",
+		ProfileURL:   "https://github.com/me",
+	}})
+}
+
 func TestChooseWhenClientCannotBeRetrieved(t *testing.T) {
 	assert := assert.Wrap(t)
 
 	store := &fakeChooseStore{}
 
-	s := httptest.NewServer(Choose("http://localhost", store, strategy.Strategies{&fakeStrategy{}}, nil, nil))
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, nil, nil))
 	defer s.Close()
 
 	form := url.Values{
@@ -187,7 +346,7 @@ func TestChooseWithBadMe(t *testing.T) {
 		},
 	}
 
-	s := httptest.NewServer(Choose("http://localhost", store, strategy.Strategies{&fakeStrategy{}}, nil, nil))
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, nil, nil))
 	defer s.Close()
 
 	form := url.Values{
@@ -213,7 +372,7 @@ func TestChooseWithBadClientID(t *testing.T) {
 		},
 	}
 
-	s := httptest.NewServer(Choose("http://localhost", store, strategy.Strategies{&fakeStrategy{}}, nil, nil))
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, nil, nil))
 	defer s.Close()
 
 	form := url.Values{
@@ -237,7 +396,7 @@ func TestChooseWithBadParams(t *testing.T) {
 		},
 	}
 
-	s := httptest.NewServer(Choose("http://localhost", store, strategy.Strategies{&fakeStrategy{}}, nil, nil))
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, nil, nil))
 	defer s.Close()
 
 	testCases := map[string]url.Values{
@@ -286,7 +445,7 @@ func TestChooseForCode(t *testing.T) {
 	}
 	chooseTmpl := &mockTemplate{}
 
-	s := httptest.NewServer(Choose("http://localhost", store, strategy.Strategies{&fakeStrategy{}}, chooseTmpl, nil))
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, chooseTmpl, nil))
 	defer s.Close()
 
 	form := url.Values{
@@ -317,6 +476,200 @@ func TestChooseForCode(t *testing.T) {
 	assert(store.session.Scope).Equal("create update")
 }
 
+func TestChooseForCodeWithScopeRegistry(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeChooseStore{
+		client: data.Client{
+			ID:          "http://client.example.com/",
+			RedirectURI: "http://client.example.com/callback",
+			Name:        "Client",
+		},
+	}
+	chooseTmpl := &mockTemplate{}
+
+	conf := config.Config{
+		Scopes: []config.Scope{
+			{Name: "create", Description: "Create new posts"},
+			{Name: "update", Description: "Edit existing posts"},
+			{Name: "delete", Description: "Delete posts", Sensitive: true},
+		},
+		Clients: []config.Client{
+			{ID: "http://client.example.com/", Scopes: []string{"create", "delete"}},
+		},
+	}
+
+	s := httptest.NewServer(Choose("http://localhost", conf, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, chooseTmpl, nil))
+	defer s.Close()
+
+	form := url.Values{
+		"me":            {"http://me.example.com/"},
+		"client_id":     {"http://client.example.com/"},
+		"redirect_uri":  {"http://client.example.com/callback"},
+		"state":         {"some-value"},
+		"response_type": {"code"},
+		"scope":         {"create update delete what"},
+	}
+
+	resp, err := http.Get(s.URL + "?" + form.Encode())
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	data := chooseTmpl.Data.(chooseCtx)
+	if assert(data.Scopes).Len(2) {
+		assert(data.Scopes[0]).Equal(conf.Scopes[0])
+		assert(data.Scopes[1]).Equal(conf.Scopes[2])
+	}
+
+	assert(store.session.Scope).Equal("create delete")
+}
+
+func TestChooseWithPushedRequest(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeChooseStore{
+		client: data.Client{
+			ID:          "http://client.example.com/",
+			RedirectURI: "http://client.example.com/callback",
+			Name:        "Client",
+		},
+		request: data.PushedRequest{
+			RequestURI:          "urn:ietf:params:oauth:request_uri:abcde",
+			ResponseType:        "code",
+			Me:                  "http://me.example.com/",
+			ClientID:            "http://client.example.com/",
+			RedirectURI:         "http://client.example.com/callback",
+			CodeChallenge:       "xyz",
+			CodeChallengeMethod: "S256",
+			Scope:               "create update",
+			State:               "some-value",
+			ExpiresAt:           time.Now().Add(time.Minute),
+		},
+	}
+	chooseTmpl := &mockTemplate{}
+
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, chooseTmpl, nil))
+	defer s.Close()
+
+	form := url.Values{
+		"client_id":   {"http://client.example.com/"},
+		"request_uri": {"urn:ietf:params:oauth:request_uri:abcde"},
+	}
+
+	resp, err := http.Get(s.URL + "?" + form.Encode())
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	data := chooseTmpl.Data.(chooseCtx)
+	assert(data.Me).Equal("http://me.example.com/")
+	assert(data.RedirectURI).Equal("http://client.example.com/callback")
+	assert(data.CodeChallengeMethod).Equal("S256")
+
+	assert(store.session.ResponseType).Equal("code")
+	assert(store.session.Me).Equal("http://me.example.com/")
+	assert(store.session.ClientID).Equal("http://client.example.com/")
+	assert(store.session.RedirectURI).Equal("http://client.example.com/callback")
+	assert(store.session.State).Equal("some-value")
+	assert(store.session.CodeChallenge).Equal("xyz")
+	assert(store.session.Scope).Equal("create update")
+
+	// the request can only be used once
+	resp, err = http.Get(s.URL + "?" + form.Encode())
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusBadRequest)
+}
+
+func TestChooseWithPushedRequestWithoutMe(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeChooseStore{
+		client: data.Client{
+			ID:          "http://client.example.com/",
+			RedirectURI: "http://client.example.com/callback",
+			Name:        "Client",
+		},
+		request: data.PushedRequest{
+			RequestURI:   "urn:ietf:params:oauth:request_uri:abcde",
+			ResponseType: "code",
+			ClientID:     "http://client.example.com/",
+			RedirectURI:  "http://client.example.com/callback",
+			State:        "some-value",
+			ExpiresAt:    time.Now().Add(time.Minute),
+		},
+	}
+	chooseTmpl := &mockTemplate{}
+	meTmpl := &mockTemplate{}
+
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, chooseTmpl, meTmpl))
+	defer s.Close()
+
+	form := url.Values{
+		"client_id":   {"http://client.example.com/"},
+		"request_uri": {"urn:ietf:params:oauth:request_uri:abcde"},
+	}
+
+	// asking for me does not use the request, so the page can be reloaded
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(s.URL + "?" + form.Encode())
+		assert(err).Must.Nil()
+		assert(resp.StatusCode).Equal(http.StatusOK)
+		assert(meTmpl.Data.(meCtx).RequestURI).Equal("urn:ietf:params:oauth:request_uri:abcde")
+	}
+
+	form.Set("me", "http://me.example.com/")
+	resp, err := http.Get(s.URL + "?" + form.Encode())
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+	assert(store.session.Me).Equal("http://me.example.com/")
+
+	resp, err = http.Get(s.URL + "?" + form.Encode())
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusBadRequest)
+}
+
+func TestChooseWithBadPushedRequest(t *testing.T) {
+	store := &fakeChooseStore{
+		client: data.Client{
+			ID:          "http://client.example.com/",
+			RedirectURI: "http://client.example.com/callback",
+			Name:        "Client",
+		},
+		request: data.PushedRequest{
+			RequestURI:  "urn:ietf:params:oauth:request_uri:abcde",
+			ClientID:    "http://client.example.com/",
+			RedirectURI: "http://client.example.com/callback",
+			State:       "some-value",
+			ExpiresAt:   time.Now().Add(-time.Minute),
+		},
+	}
+
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, nil, nil))
+	defer s.Close()
+
+	testCases := map[string]url.Values{
+		"unknown request_uri": {
+			"client_id":   {"http://client.example.com/"},
+			"request_uri": {"urn:ietf:params:oauth:request_uri:what"},
+		},
+		"mismatched client_id": {
+			"client_id":   {"http://other.example.com/"},
+			"request_uri": {"urn:ietf:params:oauth:request_uri:abcde"},
+		},
+		"expired request_uri": {
+			"client_id":   {"http://client.example.com/"},
+			"request_uri": {"urn:ietf:params:oauth:request_uri:abcde"},
+		},
+	}
+
+	for name, form := range testCases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := http.Get(s.URL + "?" + form.Encode())
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
+
 func TestChooseForCodeWithPKCE(t *testing.T) {
 	assert := assert.Wrap(t)
 
@@ -329,7 +682,7 @@ func TestChooseForCodeWithPKCE(t *testing.T) {
 	}
 	chooseTmpl := &mockTemplate{}
 
-	s := httptest.NewServer(Choose("http://localhost", store, strategy.Strategies{&fakeStrategy{}}, chooseTmpl, nil))
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, chooseTmpl, nil))
 	defer s.Close()
 
 	form := url.Values{
@@ -373,7 +726,7 @@ func TestChooseForCodeWithBadParams(t *testing.T) {
 		},
 	}
 
-	s := httptest.NewServer(Choose("http://localhost", store, strategy.Strategies{&fakeStrategy{}}, nil, nil))
+	s := httptest.NewServer(Choose("http://localhost", config.Config{}, store, strategy.Strategies{&fakeStrategy{}}, fakeCanonicalizer{}, nil, nil))
 	defer s.Close()
 
 	testCases := map[string]url.Values{
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+// tokenClient is the client making a request to the token endpoint.
+type tokenClient struct {
+	ID string
+
+	// Registered is set when the client has authenticated as a registered
+	// client, otherwise the client is public and ID has not been verified.
+	Registered *data.RegisteredClient
+}
+
+// authenticateClient checks the credentials given using client_secret_basic or
+// client_secret_post. A registered client must always authenticate, other
+// clients must not give a secret. If a registered client gives the wrong secret
+// it is returned, with false.
+func authenticateClient(store TokenDB, w http.ResponseWriter, r *http.Request) (tokenClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.FormValue("client_id")
+		secret = r.FormValue("client_secret")
+	}
+
+	registered, err := store.RegisteredClient(clientID)
+	if err == data.ErrUnknownClient {
+		if basic || secret != "" {
+			writeInvalidClient(w, basic)
+			return tokenClient{}, false
+		}
+
+		return tokenClient{ID: clientID}, true
+	}
+	if err != nil {
+		log.Println("handler/token could not find client:", err)
+		writeJSONError(w, "server_error", "Something went wrong", http.StatusInternalServerError)
+		return tokenClient{}, false
+	}
+
+	if !registered.VerifySecret(secret) {
+		writeInvalidClient(w, basic)
+		return tokenClient{ID: registered.ID, Registered: &registered}, false
+	}
+
+	return tokenClient{ID: registered.ID, Registered: &registered}, true
+}
+
+func writeInvalidClient(w http.ResponseWriter, basic bool) {
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+
+	writeJSONError(w, "invalid_client", "Client authentication failed", http.StatusUnauthorized)
+}
+
+// clientCredentialsGrant issues a token to a registered client for itself, with
+// the requested scopes it is allowed, or all of its scopes if none were
+// requested.
+func clientCredentialsGrant(store TokenDB, w http.ResponseWriter, r *http.Request, client tokenClient) (data.Code, bool) {
+	if client.Registered == nil {
+		writeJSONError(w, "unauthorized_client", "Only registered clients may use the client_credentials grant", http.StatusBadRequest)
+		return data.Code{}, false
+	}
+
+	scope := r.FormValue("scope")
+	if scope == "" {
+		scope = strings.Join(client.Registered.Scopes, " ")
+	}
+
+	return data.Code{
+		Me:       client.ID,
+		ClientID: client.ID,
+		Scope:    scope,
+	}, true
+}
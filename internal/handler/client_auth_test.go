@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/dpop"
+)
+
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return base64.RawStdEncoding.EncodeToString(hash[:])
+}
+
+var registeredClient = data.RegisteredClient{
+	ID:           "http://bot.example.com/",
+	SecretHash:   hashSecret("shh"),
+	Scopes:       []string{"read", "create"},
+	RedirectURIs: []string{"http://bot.example.com/callback"},
+}
+
+func TestTokenWithClientCredentials(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeTokenStore{client: registeredClient}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	req, _ := http.NewRequest("POST", s.URL, strings.NewReader(url.Values{
+		"grant_type": {"client_credentials"},
+		"scope":      {"read delete"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape("http://bot.example.com/"), "shh")
+
+	resp, err := http.DefaultClient.Do(req)
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	var v struct {
+		AccessToken string `json:"access_token"`
+		Scope       string `json:"scope"`
+		Me          string `json:"me"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.AccessToken).Equal("relmeauth_ran8dom_ran24dom")
+	assert(v.Scope).Equal("read")
+	assert(v.Me).Equal("http://bot.example.com/")
+	assert(store.token.ClientID).Equal("http://bot.example.com/")
+}
+
+func TestTokenWithClientCredentialsPost(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeTokenStore{client: registeredClient}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"http://bot.example.com/"},
+		"client_secret": {"shh"},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	var v struct {
+		Scope string `json:"scope"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.Scope).Equal("read create")
+}
+
+func TestTokenWithBadClientCredentials(t *testing.T) {
+	testCases := map[string]struct {
+		values  url.Values
+		status  int
+		error   string
+		audited bool
+	}{
+		"wrong secret": {
+			values:  url.Values{"client_id": {"http://bot.example.com/"}, "client_secret": {"guess"}},
+			status:  http.StatusUnauthorized,
+			error:   "invalid_client",
+			audited: true,
+		},
+		"missing secret": {
+			values:  url.Values{"client_id": {"http://bot.example.com/"}},
+			status:  http.StatusUnauthorized,
+			error:   "invalid_client",
+			audited: true,
+		},
+		"secret for unknown client": {
+			values: url.Values{"client_id": {"http://other.example.com/"}, "client_secret": {"shh"}},
+			status: http.StatusUnauthorized,
+			error:  "invalid_client",
+		},
+		"public client": {
+			values: url.Values{"client_id": {"http://other.example.com/"}},
+			status: http.StatusBadRequest,
+			error:  "unauthorized_client",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			store := &fakeTokenStore{client: registeredClient}
+			s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+			defer s.Close()
+
+			tc.values.Set("grant_type", "client_credentials")
+
+			resp, err := http.PostForm(s.URL, tc.values)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+
+			var v struct {
+				Error string `json:"error"`
+			}
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(&v))
+			assert.Equal(t, tc.error, v.Error)
+
+			// only failures by a registered client are audited
+			assert.Equal(t, tc.audited, len(store.events) == 1)
+			if tc.audited {
+				assert.Equal(t, registeredClient.ID, store.events[0].ClientID)
+				assert.Equal(t, tc.error, store.events[0].Outcome)
+			}
+		})
+	}
+}
+
+func TestTokenWithRegisteredClientCode(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	code := data.Code{
+		ClientID:     "http://bot.example.com/",
+		RedirectURI:  "http://bot.example.com/callback",
+		Me:           "https://me.example.com/",
+		ExpiresAt:    time.Now().Add(time.Minute),
+		Code:         "1234",
+		ResponseType: "code",
+		Scope:        "create delete",
+	}
+
+	store := &fakeTokenStore{code: code, client: registeredClient}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code.Code},
+		"client_id":     {code.ClientID},
+		"client_secret": {"shh"},
+		"redirect_uri":  {code.RedirectURI},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	var v struct {
+		Scope string `json:"scope"`
+		Me    string `json:"me"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.Scope).Equal("create")
+	assert(v.Me).Equal("https://me.example.com/")
+}
@@ -10,6 +10,7 @@ import (
 )
 
 type ContinueDB interface {
+	AuditDB
 	Login(*http.Request) (string, error)
 	Session(string) (data.Session, error)
 	CreateCode(me, code string, createdAt time.Time) error
@@ -20,8 +21,14 @@ func Continue(store ContinueDB, generator func() (string, error)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userProfileURL, err := store.Login(r)
 		if err != nil {
+			audit(store, r, data.AuditEvent{
+				Action:  data.AuditContinue,
+				Outcome: "no_login",
+			})
+
 			log.Println(err)
 			http.Error(w, "how did you get here?", http.StatusInternalServerError)
+			return
 		}
 
 		session, err := store.Session(userProfileURL)
@@ -30,6 +37,13 @@ func Continue(store ContinueDB, generator func() (string, error)) http.Handler {
 			return
 		}
 		if session.Expired() {
+			audit(store, r, data.AuditEvent{
+				Action:   data.AuditContinue,
+				Me:       session.Me,
+				ClientID: session.ClientID,
+				Outcome:  "session_expired",
+			})
+
 			http.Error(w, "Auth session expired", http.StatusInternalServerError)
 			return
 		}
@@ -58,6 +72,14 @@ func Continue(store ContinueDB, generator func() (string, error)) http.Handler {
 		query.Set("state", session.State)
 		redirectURI.RawQuery = query.Encode()
 
+		audit(store, r, data.AuditEvent{
+			Action:   data.AuditContinue,
+			Me:       session.Me,
+			ClientID: session.ClientID,
+			Provider: session.Provider,
+			Outcome:  data.AuditSuccess,
+		})
+
 		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
 	})
 }
@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"hawx.me/code/mux"
+	"hawx.me/code/relme-auth/internal/config"
+	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/random"
+)
+
+const (
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// deviceInterval is the minimum time a device must wait between polls.
+	deviceInterval = 5 * time.Second
+)
+
+type DeviceDB interface {
+	CreateDeviceAuthorization(data.DeviceAuthorization) error
+	DeviceAuthorization(userCode string) (data.DeviceAuthorization, error)
+	ApproveDeviceAuthorization(userCode, me string) error
+	DenyDeviceAuthorization(userCode string) error
+	Code(string) (data.Code, error)
+}
+
+// DeviceAuthorization starts authorization for a device that can not receive a
+// redirect, such as a command-line tool. It returns a code for the user to
+// enter on the verification page, and a code for the device to poll the token
+// endpoint with. See RFC 8628.
+func DeviceAuthorization(baseURL string, conf config.Config, store DeviceDB, generator func() (string, error), expiresIn time.Duration) http.Handler {
+	return mux.Method{
+		"POST": deviceAuthorizationEndpoint(baseURL, conf, store, generator, expiresIn),
+	}
+}
+
+func deviceAuthorizationEndpoint(baseURL string, conf config.Config, store DeviceDB, generator func() (string, error), expiresIn time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			clientID = r.PostFormValue("client_id")
+			scope    = r.PostFormValue("scope")
+		)
+
+		w.Header().Set("Content-Type", "application/json")
+
+		clientID = data.ParseClientID(clientID)
+		if clientID == "" {
+			writeJSONError(w, "invalid_request", "The 'client_id' parameter is invalid", http.StatusBadRequest)
+			return
+		}
+
+		deviceCode, err := generator()
+		if err != nil {
+			log.Println("handler/device could not generate device_code:", err)
+			writeJSONError(w, "server_error", "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+
+		userCode, err := random.UserCode(8)
+		if err != nil {
+			log.Println("handler/device could not generate user_code:", err)
+			writeJSONError(w, "server_error", "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+
+		if err := store.CreateDeviceAuthorization(data.DeviceAuthorization{
+			DeviceCode: deviceCode,
+			UserCode:   userCode,
+			ClientID:   clientID,
+			Scope:      strings.Join(config.ScopeNames(conf.FilterScopes(clientID, strings.Fields(scope))), " "),
+			CreatedAt:  time.Now().UTC(),
+		}); err != nil {
+			log.Println("handler/device could not persist request:", err)
+			writeJSONError(w, "server_error", "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+
+		displayCode := userCode[:4] + "-" + userCode[4:]
+
+		if err := json.NewEncoder(w).Encode(deviceAuthorizationResponse{
+			DeviceCode:              deviceCode,
+			UserCode:                displayCode,
+			VerificationURI:         baseURL + "/device",
+			VerificationURIComplete: baseURL + "/device?" + url.Values{"user_code": {displayCode}}.Encode(),
+			ExpiresIn:               int(expiresIn.Seconds()),
+			Interval:                int(deviceInterval.Seconds()),
+		}); err != nil {
+			log.Println("handler/device failed to write response:", err)
+		}
+	}
+}
+
+// deviceCodeGrant checks whether the user has approved the device's request,
+// returning the details to issue a token for.
+func deviceCodeGrant(store TokenDB, w http.ResponseWriter, r *http.Request, client tokenClient) (data.Code, bool) {
+	auth, err := store.PollDeviceAuthorization(r.FormValue("device_code"))
+	if err != nil {
+		writeJSONError(w, "invalid_grant", "The device_code provided was not valid", http.StatusBadRequest)
+		return data.Code{}, false
+	}
+
+	failed := data.Code{Me: auth.Me, ClientID: auth.ClientID}
+
+	if auth.ClientID != data.ParseClientID(client.ID) {
+		writeJSONError(w, "invalid_grant", "The device_code provided was not valid", http.StatusBadRequest)
+		return failed, false
+	}
+
+	if auth.Expired() {
+		writeJSONError(w, "expired_token", "The device_code has expired", http.StatusBadRequest)
+		return failed, false
+	}
+
+	if auth.Denied {
+		writeJSONError(w, "access_denied", "The user denied the request", http.StatusBadRequest)
+		return failed, false
+	}
+
+	// polling before the user has decided is expected, so is not a failure to
+	// record
+	if !auth.Approved() {
+		if time.Since(auth.LastPolledAt) < deviceInterval {
+			writeJSONError(w, "slow_down", "Polling too frequently", http.StatusBadRequest)
+		} else {
+			writeJSONError(w, "authorization_pending", "The user has not yet approved the request", http.StatusBadRequest)
+		}
+		return data.Code{}, false
+	}
+
+	return data.Code{
+		Me:       auth.Me,
+		ClientID: auth.ClientID,
+		Scope:    auth.Scope,
+	}, true
+}
+
+// Device serves the page where a user enters the code shown by their device.
+// To approve the request the user signs-in, using relme-auth as the client, and
+// is returned to DeviceCallback.
+func Device(baseURL string, conf config.Config, cookies sessions.Store, store DeviceDB, deviceTemplate tmpl) http.Handler {
+	return mux.Method{
+		"GET":  deviceShow(conf, cookies, store, deviceTemplate),
+		"POST": deviceSubmit(baseURL, cookies, store, deviceTemplate),
+	}
+}
+
+func deviceShow(conf config.Config, cookies sessions.Store, store DeviceDB, deviceTemplate tmpl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userCode := r.FormValue("user_code")
+		if userCode == "" {
+			renderDevice(w, deviceTemplate, deviceCtx{})
+			return
+		}
+
+		auth, err := store.DeviceAuthorization(userCode)
+		if err != nil || auth.Expired() || auth.Denied || auth.Approved() {
+			renderDevice(w, deviceTemplate, deviceCtx{Error: "That code is not valid, or has expired."})
+			return
+		}
+
+		state, err := random.String(64)
+		if err != nil {
+			log.Println("handler/device could not generate state:", err)
+			http.Error(w, "something went wrong", http.StatusInternalServerError)
+			return
+		}
+
+		session, _ := cookies.Get(r, "device-session")
+		session.Values["state"] = state
+		session.Values["user_code"] = auth.UserCode
+		if err := session.Save(r, w); err != nil {
+			log.Println("handler/device could not save session:", err)
+		}
+
+		renderDevice(w, deviceTemplate, deviceCtx{
+			Confirm:  true,
+			ClientID: auth.ClientID,
+			Scopes:   conf.FilterScopes(auth.ClientID, strings.Fields(auth.Scope)),
+			State:    state,
+		})
+	}
+}
+
+func deviceSubmit(baseURL string, cookies sessions.Store, store DeviceDB, deviceTemplate tmpl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := cookies.Get(r, "device-session")
+
+		state, ok := session.Values["state"].(string)
+		if !ok || r.FormValue("state") != state {
+			http.Error(w, "state did not match", http.StatusBadRequest)
+			return
+		}
+
+		userCode, _ := session.Values["user_code"].(string)
+
+		if r.FormValue("action") == "deny" {
+			if err := store.DenyDeviceAuthorization(userCode); err != nil {
+				log.Println("handler/device could not deny request:", err)
+			}
+
+			renderDevice(w, deviceTemplate, deviceCtx{Denied: true})
+			return
+		}
+
+		query := url.Values{
+			"response_type": {"id"},
+			"client_id":     {baseURL + "/"},
+			"redirect_uri":  {baseURL + "/device/callback"},
+			"state":         {state},
+		}
+		if me := r.FormValue("me"); me != "" {
+			query.Set("me", me)
+		}
+
+		http.Redirect(w, r, baseURL+"/auth?"+query.Encode(), http.StatusFound)
+	}
+}
+
+// DeviceCallback completes the sign-in started by Device, approving the
+// device's request for the user that signed-in.
+func DeviceCallback(baseURL string, cookies sessions.Store, store DeviceDB, deviceTemplate tmpl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := cookies.Get(r, "device-session")
+
+		state, ok := session.Values["state"].(string)
+		if !ok || r.FormValue("state") != state {
+			http.Error(w, "state did not match", http.StatusBadRequest)
+			return
+		}
+
+		userCode, _ := session.Values["user_code"].(string)
+
+		code, err := store.Code(r.FormValue("code"))
+		if err != nil || code.ResponseType != "id" || code.Expired() ||
+			code.ClientID != data.ParseClientID(baseURL) ||
+			code.RedirectURI != baseURL+"/device/callback" {
+			http.Error(w, "could not authenticate", http.StatusBadRequest)
+			return
+		}
+
+		if err := store.ApproveDeviceAuthorization(userCode, code.Me); err != nil {
+			log.Println("handler/device could not approve request:", err)
+			http.Error(w, "something went wrong", http.StatusInternalServerError)
+			return
+		}
+
+		delete(session.Values, "state")
+		delete(session.Values, "user_code")
+		if err := session.Save(r, w); err != nil {
+			log.Println("handler/device could not save session:", err)
+		}
+
+		renderDevice(w, deviceTemplate, deviceCtx{Approved: true, Me: code.Me})
+	}
+}
+
+func renderDevice(w http.ResponseWriter, deviceTemplate tmpl, ctx deviceCtx) {
+	if err := deviceTemplate.ExecuteTemplate(w, "app", ctx); err != nil {
+		log.Println("handler/device failed to write template:", err)
+	}
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type deviceCtx struct {
+	Error    string
+	Confirm  bool
+	Approved bool
+	Denied   bool
+	ClientID string
+	Scopes   []config.Scope
+	State    string
+	Me       string
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/config"
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+type fakeDeviceStore struct {
+	auth data.DeviceAuthorization
+	code data.Code
+}
+
+func (s *fakeDeviceStore) CreateDeviceAuthorization(auth data.DeviceAuthorization) error {
+	s.auth = auth
+	s.auth.ExpiresAt = auth.CreatedAt.Add(time.Minute)
+	return nil
+}
+
+func (s *fakeDeviceStore) DeviceAuthorization(userCode string) (data.DeviceAuthorization, error) {
+	if data.NormalizeUserCode(userCode) == s.auth.UserCode {
+		return s.auth, nil
+	}
+	return data.DeviceAuthorization{}, errors.New("no")
+}
+
+func (s *fakeDeviceStore) ApproveDeviceAuthorization(userCode, me string) error {
+	if data.NormalizeUserCode(userCode) != s.auth.UserCode {
+		return errors.New("no")
+	}
+	s.auth.Me = me
+	return nil
+}
+
+func (s *fakeDeviceStore) DenyDeviceAuthorization(userCode string) error {
+	s.auth.Denied = true
+	return nil
+}
+
+func (s *fakeDeviceStore) Code(code string) (data.Code, error) {
+	if code == s.code.Code {
+		return s.code, nil
+	}
+	return data.Code{}, errors.New("no")
+}
+
+type fakeDeviceTemplate struct {
+	ctx deviceCtx
+}
+
+func (t *fakeDeviceTemplate) ExecuteTemplate(w io.Writer, name string, v interface{}) error {
+	t.ctx = v.(deviceCtx)
+	return nil
+}
+
+func TestDeviceAuthorization(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeDeviceStore{}
+
+	s := httptest.NewServer(DeviceAuthorization("http://localhost", config.Config{}, store, func() (string, error) { return "abcde", nil }, 10*time.Minute))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"client_id": {"http://client.example.com"},
+		"scope":     {"create update"},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+	assert(resp.Header.Get("Content-Type")).Equal("application/json")
+
+	var v struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.DeviceCode).Equal("abcde")
+	assert(len(v.UserCode)).Equal(9)
+	assert(v.VerificationURI).Equal("http://localhost/device")
+	assert(v.VerificationURIComplete).Equal("http://localhost/device?user_code=" + v.UserCode)
+	assert(v.ExpiresIn).Equal(600)
+	assert(v.Interval).Equal(5)
+
+	assert(store.auth.DeviceCode).Equal("abcde")
+	assert(store.auth.UserCode).Equal(data.NormalizeUserCode(v.UserCode))
+	assert(store.auth.ClientID).Equal("http://client.example.com/")
+	assert(store.auth.Scope).Equal("create update")
+}
+
+func TestDeviceAuthorizationWithBadClientID(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(DeviceAuthorization("http://localhost", config.Config{}, &fakeDeviceStore{}, func() (string, error) { return "abcde", nil }, time.Minute))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"client_id": {"not a url"},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusBadRequest)
+}
+
+func TestDevice(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeDeviceStore{
+		auth: data.DeviceAuthorization{
+			DeviceCode: "abcde",
+			UserCode:   "BCDFGHJK",
+			ClientID:   "http://client.example.com/",
+			Scope:      "create",
+			ExpiresAt:  time.Now().Add(time.Minute),
+		},
+		code: data.Code{
+			Code:         "123",
+			ResponseType: "id",
+			Me:           "https://me.example.com/",
+			ClientID:     "http://localhost/",
+			RedirectURI:  "http://localhost/device/callback",
+			ExpiresAt:    time.Now().Add(time.Minute),
+		},
+	}
+	cookies := sessions.NewCookieStore([]byte("secret"))
+	templates := &fakeDeviceTemplate{}
+
+	show := httptest.NewRecorder()
+	Device("http://localhost", config.Config{}, cookies, store, templates).
+		ServeHTTP(show, httptest.NewRequest("GET", "/device?user_code=bcdf-ghjk", nil))
+	assert(show.Code).Equal(http.StatusOK)
+	assert(templates.ctx.Confirm).True()
+	assert(templates.ctx.ClientID).Equal("http://client.example.com/")
+	assert(len(templates.ctx.Scopes)).Equal(1)
+
+	state := templates.ctx.State
+	cookie := show.Result().Cookies()[0]
+
+	submitReq := httptest.NewRequest("POST", "/device", strings.NewReader(url.Values{
+		"state":  {state},
+		"action": {"approve"},
+		"me":     {"https://me.example.com/"},
+	}.Encode()))
+	submitReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	submitReq.AddCookie(cookie)
+
+	submit := httptest.NewRecorder()
+	Device("http://localhost", config.Config{}, cookies, store, templates).ServeHTTP(submit, submitReq)
+	assert(submit.Code).Equal(http.StatusFound)
+
+	location, _ := url.Parse(submit.Header().Get("Location"))
+	assert(location.Path).Equal("/auth")
+	assert(location.Query().Get("client_id")).Equal("http://localhost/")
+	assert(location.Query().Get("redirect_uri")).Equal("http://localhost/device/callback")
+	assert(location.Query().Get("state")).Equal(state)
+	assert(location.Query().Get("me")).Equal("https://me.example.com/")
+
+	callbackReq := httptest.NewRequest("GET", "/device/callback?"+url.Values{
+		"code":  {"123"},
+		"state": {state},
+	}.Encode(), nil)
+	callbackReq.AddCookie(cookie)
+
+	callback := httptest.NewRecorder()
+	DeviceCallback("http://localhost", cookies, store, templates).ServeHTTP(callback, callbackReq)
+	assert(callback.Code).Equal(http.StatusOK)
+	assert(templates.ctx.Approved).True()
+	assert(store.auth.Me).Equal("https://me.example.com/")
+}
+
+func TestDeviceWithUnknownCode(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	templates := &fakeDeviceTemplate{}
+
+	w := httptest.NewRecorder()
+	Device("http://localhost", config.Config{}, sessions.NewCookieStore([]byte("secret")), &fakeDeviceStore{}, templates).
+		ServeHTTP(w, httptest.NewRequest("GET", "/device?user_code=what", nil))
+	assert(w.Code).Equal(http.StatusOK)
+	assert(templates.ctx.Confirm).False()
+	assert(templates.ctx.Error).Equal("That code is not valid, or has expired.")
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"hawx.me/code/mux"
+	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/microformats"
+	"hawx.me/code/relme-auth/internal/strategy"
+)
+
+// DiscoveryReport returns a JSON report of how the authentication methods for
+// the "me" parameter were found, or why they weren't. The profile is always
+// fetched, rather than using any cached methods.
+func DiscoveryReport(strategies strategy.Strategies, relMe *microformats.RelMe) http.Handler {
+	return mux.Method{
+		"GET": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+
+			me := data.ParseProfileURL(r.FormValue("me"))
+			if me == "" {
+				writeJSONError(w, "invalid_request", "me is invalid", http.StatusBadRequest)
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(discover(r.Context(), strategies, relMe, me)); err != nil {
+				log.Println("handler/discovery failed to write response:", err)
+			}
+		}),
+	}
+}
+
+// Discovery shows the report given by DiscoveryReport as a page.
+func Discovery(strategies strategy.Strategies, relMe *microformats.RelMe, tmpl tmpl) http.Handler {
+	return mux.Method{
+		"GET": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := discoveryCtx{Me: r.FormValue("me")}
+
+			if ctx.Me != "" {
+				me := data.ParseProfileURL(ctx.Me)
+				if me == "" {
+					http.Error(w, "me is invalid", http.StatusBadRequest)
+					return
+				}
+
+				report := discover(r.Context(), strategies, relMe, me)
+				ctx.Me = me
+				ctx.Report = &report
+			}
+
+			if err := tmpl.ExecuteTemplate(w, "app", ctx); err != nil {
+				log.Println("handler/discovery failed to write template:", err)
+			}
+		}),
+	}
+}
+
+type discoveryCtx struct {
+	Me     string
+	Report *discoveryReport
+}
+
+type discoveryReport struct {
+	Me        string           `json:"me"`
+	Redirects []string         `json:"redirects"`
+	PGPKey    string           `json:"pgpkey,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	Links     []*discoveryLink `json:"links"`
+}
+
+type discoveryLink struct {
+	Link     string              `json:"link"`
+	Rels     []string            `json:"rels"`
+	Strategy string              `json:"strategy,omitempty"`
+	Status   string              `json:"status"`
+	Backlink string              `json:"backlink,omitempty"`
+	Checked  []discoveryFollowed `json:"checked"`
+	Error    string              `json:"error,omitempty"`
+}
+
+type discoveryFollowed struct {
+	Link      string   `json:"link"`
+	Redirects []string `json:"redirects"`
+}
+
+// discover runs the same discovery as the websocket, but records everything
+// that happened to each link instead of just the methods that can be used.
+//
+// The status of each link is one of "unsupported", when no strategy matches it,
+// "pending", if it wasn't checked before the request ended, or "verified",
+// "unverified" or "error" as given by the microformats.Event for it.
+func discover(ctx context.Context, strategies strategy.Strategies, relMe *microformats.RelMe, me string) discoveryReport {
+	report := discoveryReport{
+		Me:        me,
+		Redirects: []string{},
+		Links:     []*discoveryLink{},
+	}
+	links := map[string]*discoveryLink{}
+
+	for event := range relMe.Me(ctx, me, strategies) {
+		switch event.Type {
+		case microformats.Found, microformats.Unsupported:
+			link := &discoveryLink{
+				Link:    event.Link,
+				Rels:    event.Rels,
+				Status:  "unsupported",
+				Checked: []discoveryFollowed{},
+			}
+			if link.Rels == nil {
+				link.Rels = []string{}
+			}
+			if event.Type == microformats.Found {
+				link.Status = "pending"
+				if strategy, ok := strategies.IsAllowed(event.Link); ok {
+					link.Strategy = strategy.Name()
+				}
+			}
+
+			links[event.Link] = link
+			report.Links = append(report.Links, link)
+
+		case microformats.PGP:
+			report.PGPKey = event.Link
+
+		case microformats.Redirects:
+			report.Redirects = event.Redirects
+
+		case microformats.Verified, microformats.Unverified, microformats.Error:
+			if event.Link == "" {
+				report.Error = event.Err.Error()
+				continue
+			}
+
+			link, ok := links[event.Link]
+			if !ok {
+				continue
+			}
+
+			switch event.Type {
+			case microformats.Verified:
+				link.Status = "verified"
+			case microformats.Unverified:
+				link.Status = "unverified"
+			case microformats.Error:
+				link.Status = "error"
+				link.Error = event.Err.Error()
+			}
+
+			link.Backlink = event.Backlink
+			for _, followed := range event.Checked {
+				link.Checked = append(link.Checked, discoveryFollowed{
+					Link:      followed.Link,
+					Redirects: followed.Redirects,
+				})
+			}
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/microformats"
+	"hawx.me/code/relme-auth/internal/strategy"
+)
+
+// hostStrategy matches links to any of the hosts listed
+type hostStrategy []string
+
+func (hostStrategy) Name() string { return "host" }
+func (s hostStrategy) Match(me *url.URL) bool {
+	for _, host := range s {
+		if me.Host == host {
+			return true
+		}
+	}
+	return false
+}
+func (hostStrategy) Redirect(expectedLink, _ string) (string, error) {
+	return "https://example.com/redirect", nil
+}
+func (hostStrategy) Callback(form url.Values) (string, error) { return "me", nil }
+
+func TestDiscover(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var meSite, goodSite, badSite, otherSite *httptest.Server
+
+	goodSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a rel="me" href="https://example.com/">elsewhere</a><a rel="me" href="%s">me</a>`, meSite.URL)
+	}))
+	defer goodSite.Close()
+
+	badSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<p>nothing here</p>`)
+	}))
+	defer badSite.Close()
+
+	otherSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer otherSite.Close()
+
+	meSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a rel="me authn" href="%s">good</a><a rel="me authn" href="%s">bad</a><a rel="me authn" href="%s">other</a>`,
+			goodSite.URL, badSite.URL, otherSite.URL)
+	}))
+	defer meSite.Close()
+
+	goodURL, _ := url.Parse(goodSite.URL)
+	badURL, _ := url.Parse(badSite.URL)
+	strategies := strategy.Strategies{hostStrategy{goodURL.Host, badURL.Host}}
+
+	relMe := &microformats.RelMe{
+		Client: http.DefaultClient,
+		NoRedirectClient: &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	// the handler only accepts valid profile URLs, which can't have a port, so
+	// call discover directly
+	report := discover(context.Background(), strategies, relMe, meSite.URL+"/")
+
+	assert(report.Me).Equal(meSite.URL + "/")
+	assert(report.Redirects).Equal([]string{meSite.URL + "/"})
+	assert(report.Error).Equal("")
+
+	if assert(report.Links).Len(3) {
+		links := map[string]*discoveryLink{}
+		for _, link := range report.Links {
+			links[link.Link] = link
+		}
+
+		good := links[goodSite.URL]
+		assert(good.Rels).Equal([]string{"me", "authn"})
+		assert(good.Strategy).Equal("host")
+		assert(good.Status).Equal("verified")
+		assert(good.Backlink).Equal(meSite.URL)
+		if assert(good.Checked).Len(2) {
+			assert(good.Checked[0].Link).Equal("https://example.com/")
+			assert(good.Checked[1]).Equal(discoveryFollowed{Link: meSite.URL, Redirects: []string{meSite.URL}})
+		}
+
+		bad := links[badSite.URL]
+		assert(bad.Strategy).Equal("host")
+		assert(bad.Status).Equal("unverified")
+		assert(bad.Backlink).Equal("")
+		assert(bad.Checked).Len(0)
+
+		other := links[otherSite.URL]
+		assert(other.Strategy).Equal("")
+		assert(other.Status).Equal("unsupported")
+	}
+}
+
+func TestDiscoveryReportWithInvalidMe(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(DiscoveryReport(strategy.Strategies{}, &microformats.RelMe{}))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "?me=")
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusBadRequest)
+}
@@ -17,15 +17,31 @@ import (
 )
 
 type ExampleDB interface {
+	AuditDB
 	CreateToken(data.Token) error
-	Tokens(string) ([]data.Token, error)
-	RevokeToken(string) error
+	AuthorizedClients(string) ([]data.AuthorizedClient, error)
+	RevokeUserToken(me, shortToken string) error
+	RevokeClient(me, clientID string) error
+	RevokeAll(string) error
 	Forget(string) error
+	Export(string) (data.Export, error)
+	Logins(*http.Request, string) ([]data.Login, error)
+	RemoveLogin(me, id string) error
+	AuditEvents(data.AuditQuery) ([]data.AuditEvent, error)
 }
 
+// Locator finds the rough location, such as the city or country, of an IP
+// address. It returns an empty string if the location is not known.
+type Locator interface {
+	Locate(ip string) string
+}
+
+// recentActivityLimit is the number of sign-ins shown on the account page.
+const recentActivityLimit = 10
+
 // Example implements a basic site using the authentication flow provided by
-// this package.
-func Example(baseURL string, conf config.Config, store sessions.Store, tokenStore ExampleDB, welcomeTemplate, accountTemplate tmpl) http.HandlerFunc {
+// this package. If locator is nil the location of sign-ins is not shown.
+func Example(baseURL string, conf config.Config, store sessions.Store, tokenStore ExampleDB, locator Locator, welcomeTemplate, accountTemplate tmpl) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		session, _ := store.Get(r, "example-session")
 
@@ -37,7 +53,25 @@ func Example(baseURL string, conf config.Config, store sessions.Store, tokenStor
 			me, ok = meValue.(string)
 		}
 		if ok {
-			tokens, _ = tokenStore.Tokens(me)
+			clients, err := tokenStore.AuthorizedClients(me)
+			if err != nil {
+				log.Println("handler/example could not get clients:", err)
+			}
+
+			logins, err := tokenStore.Logins(r, me)
+			if err != nil {
+				log.Println("handler/example could not get logins:", err)
+			}
+
+			activity, err := tokenStore.AuditEvents(data.AuditQuery{
+				Me:      me,
+				Actions: []string{data.AuditLogin, data.AuditContinue},
+				Limit:   recentActivityLimit,
+			})
+			if err != nil {
+				log.Println("handler/example could not get activity:", err)
+			}
+
 			state, _ := random.String(64)
 			session.Values["state"] = state
 
@@ -45,11 +79,19 @@ func Example(baseURL string, conf config.Config, store sessions.Store, tokenStor
 				log.Println("handler/example could not save session:", err)
 			}
 
-			if err := accountTemplate.ExecuteTemplate(w, "page", accountCtx{
-				Me:     me,
-				State:  state,
-				Tokens: tokens,
-			}); err != nil {
+			ctx := accountCtx{
+				Me:      me,
+				State:   state,
+				Clients: clients,
+			}
+			for _, login := range logins {
+				ctx.Logins = append(ctx.Logins, accountLogin{Login: login, Location: locate(locator, login.IP)})
+			}
+			for _, event := range activity {
+				ctx.Activity = append(ctx.Activity, accountEvent{AuditEvent: event, Location: locate(locator, event.IP)})
+			}
+
+			if err := accountTemplate.ExecuteTemplate(w, "page", ctx); err != nil {
 				log.Println("handler/example failed to write template:", err)
 			}
 
@@ -159,7 +201,7 @@ func ExampleSignOut(baseURL string, store sessions.Store) http.HandlerFunc {
 	}
 }
 
-// ExampleRevoke removes the token for the client_id.
+// ExampleRevoke removes one of the user's tokens, given by its id.
 func ExampleRevoke(baseURL string, store sessions.Store, tokenStore ExampleDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		session, _ := store.Get(r, "example-session")
@@ -169,18 +211,133 @@ func ExampleRevoke(baseURL string, store sessions.Store, tokenStore ExampleDB) h
 			return
 		}
 
+		me, ok := session.Values["me"].(string)
+		if !ok {
+			http.Redirect(w, r, baseURL, http.StatusFound)
+			return
+		}
+
 		rowID := r.FormValue("id")
 
-		if err := tokenStore.RevokeToken(rowID); err != nil {
+		outcome := data.AuditSuccess
+		if err := tokenStore.RevokeUserToken(me, rowID); err != nil {
 			log.Println("handler/example failed to revoke token:", err)
+			outcome = "error"
+		}
+
+		audit(tokenStore, r, data.AuditEvent{
+			Action:  data.AuditTokenRevoke,
+			Me:      me,
+			Outcome: outcome,
+		})
+
+		http.Redirect(w, r, baseURL, http.StatusFound)
+	}
+}
+
+// ExampleRevokeClient removes all of the user's tokens for the client_id.
+func ExampleRevokeClient(baseURL string, store sessions.Store, tokenStore ExampleDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "example-session")
+
+		if r.FormValue("state") != session.Values["state"] {
+			http.Error(w, "state did not match", http.StatusBadRequest)
+			return
+		}
+
+		me, ok := session.Values["me"].(string)
+		if !ok {
+			http.Redirect(w, r, baseURL, http.StatusFound)
+			return
+		}
+
+		clientID := r.FormValue("client_id")
+
+		outcome := data.AuditSuccess
+		if err := tokenStore.RevokeClient(me, clientID); err != nil {
+			log.Println("handler/example failed to revoke client:", err)
+			outcome = "error"
+		}
+
+		audit(tokenStore, r, data.AuditEvent{
+			Action:   data.AuditTokenRevoke,
+			Me:       me,
+			ClientID: clientID,
+			Outcome:  outcome,
+		})
+
+		http.Redirect(w, r, baseURL, http.StatusFound)
+	}
+}
+
+// ExampleRevokeAll removes all of the user's tokens.
+func ExampleRevokeAll(baseURL string, store sessions.Store, tokenStore ExampleDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "example-session")
+
+		if r.FormValue("state") != session.Values["state"] {
+			http.Error(w, "state did not match", http.StatusBadRequest)
+			return
+		}
+
+		me, ok := session.Values["me"].(string)
+		if !ok {
+			http.Redirect(w, r, baseURL, http.StatusFound)
+			return
 		}
 
+		outcome := data.AuditSuccess
+		if err := tokenStore.RevokeAll(me); err != nil {
+			log.Println("handler/example failed to revoke all tokens:", err)
+			outcome = "error"
+		}
+
+		audit(tokenStore, r, data.AuditEvent{
+			Action:  data.AuditTokenRevoke,
+			Me:      me,
+			Outcome: outcome,
+		})
+
+		http.Redirect(w, r, baseURL, http.StatusFound)
+	}
+}
+
+// ExampleRemoveLogin signs out one of the user's browsers from relme-auth, so
+// that they will have to sign-in again to use it.
+func ExampleRemoveLogin(baseURL string, store sessions.Store, tokenStore ExampleDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "example-session")
+
+		if r.FormValue("state") != session.Values["state"] {
+			http.Error(w, "state did not match", http.StatusBadRequest)
+			return
+		}
+
+		me, ok := session.Values["me"].(string)
+		if !ok {
+			http.Redirect(w, r, baseURL, http.StatusFound)
+			return
+		}
+
+		outcome := data.AuditSuccess
+		if err := tokenStore.RemoveLogin(me, r.FormValue("id")); err != nil {
+			log.Println("handler/example failed to remove login:", err)
+			outcome = "error"
+		}
+
+		audit(tokenStore, r, data.AuditEvent{
+			Action:  data.AuditLogout,
+			Me:      me,
+			Outcome: outcome,
+		})
+
 		http.Redirect(w, r, baseURL, http.StatusFound)
 	}
 }
 
 func ExampleGenerate(
 	baseURL string,
+	conf config.Config,
 	store sessions.Store,
 	generator func(int) (string, error),
 	tokenStore ExampleDB,
@@ -199,10 +356,13 @@ func ExampleGenerate(
 			return
 		}
 
+		clientID := r.FormValue("client_id")
+		scopes := conf.FilterScopes(clientID, strings.Fields(r.FormValue("scope")))
+
 		token, tokenString, err := data.NewToken(generator, data.Code{
 			Me:       me,
-			ClientID: r.FormValue("client_id"),
-			Scope:    r.FormValue("scope"),
+			ClientID: clientID,
+			Scope:    strings.Join(config.ScopeNames(scopes), " "),
 		})
 		if err != nil {
 			log.Println("handler/token could not generate token:", err)
@@ -221,7 +381,7 @@ func ExampleGenerate(
 		}{
 			ThisURI:  baseURL,
 			Me:       me,
-			ClientID: r.FormValue("client_id"),
+			ClientID: clientID,
 			Token:    tokenString,
 		}); err != nil {
 			log.Println("handler/example failed to write template:", err)
@@ -249,10 +409,23 @@ func ExampleForget(baseURL string, store sessions.Store, tokenStore ExampleDB) h
 		if ok {
 			if err := tokenStore.Forget(me); err != nil {
 				log.Println("handler/example failed to forget:", err)
+				audit(tokenStore, r, data.AuditEvent{
+					Action:  data.AuditForget,
+					Me:      me,
+					Outcome: "error",
+				})
 				http.Error(w, "failed to forget", http.StatusInternalServerError)
 				return
 			}
 
+			// the user's earlier events are forgotten, but that they asked to be
+			// forgotten is kept
+			audit(tokenStore, r, data.AuditEvent{
+				Action:  data.AuditForget,
+				Me:      me,
+				Outcome: data.AuditSuccess,
+			})
+
 			delete(session.Values, "me")
 			if err := session.Save(r, w); err != nil {
 				log.Println("handler/example could not save session:", err)
@@ -263,6 +436,51 @@ func ExampleForget(baseURL string, store sessions.Store, tokenStore ExampleDB) h
 	}
 }
 
+// ExampleExport sends the user a JSON file of all the data stored for them.
+func ExampleExport(baseURL string, store sessions.Store, tokenStore ExampleDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "example-session")
+
+		if r.FormValue("state") != session.Values["state"] {
+			http.Error(w, "state did not match", http.StatusBadRequest)
+			return
+		}
+
+		me, ok := session.Values["me"].(string)
+		if !ok {
+			http.Redirect(w, r, baseURL, http.StatusFound)
+			return
+		}
+
+		export, err := tokenStore.Export(me)
+		if err != nil {
+			log.Println("handler/example failed to export:", err)
+			audit(tokenStore, r, data.AuditEvent{
+				Action:  data.AuditExport,
+				Me:      me,
+				Outcome: "error",
+			})
+			http.Error(w, "failed to export", http.StatusInternalServerError)
+			return
+		}
+
+		audit(tokenStore, r, data.AuditEvent{
+			Action:  data.AuditExport,
+			Me:      me,
+			Outcome: data.AuditSuccess,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="relme-auth-export.json"`)
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(export); err != nil {
+			log.Println("handler/example failed to write export:", err)
+		}
+	}
+}
+
 type exampleResponse struct {
 	Me string `json:"me"`
 }
@@ -279,7 +497,30 @@ type welcomeCtx struct {
 }
 
 type accountCtx struct {
-	State  string
-	Me     string
-	Tokens []data.Token
+	State    string
+	Me       string
+	Clients  []data.AuthorizedClient
+	Logins   []accountLogin
+	Activity []accountEvent
+}
+
+type accountLogin struct {
+	data.Login
+	Location string
+}
+
+type accountEvent struct {
+	data.AuditEvent
+	Location string
+}
+
+// locate returns the location of ip to show, or "Unknown" if it can't be found.
+func locate(locator Locator, ip string) string {
+	if locator != nil {
+		if location := locator.Locate(ip); location != "" {
+			return location
+		}
+	}
+
+	return "Unknown"
 }
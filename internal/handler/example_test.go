@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/config"
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+type fakeExampleStore struct {
+	fakeAuditStore
+	logins   []data.Login
+	activity []data.AuditEvent
+	revoked  []string
+}
+
+func (s *fakeExampleStore) CreateToken(data.Token) error { return nil }
+func (s *fakeExampleStore) AuthorizedClients(string) ([]data.AuthorizedClient, error) {
+	return nil, nil
+}
+func (s *fakeExampleStore) RevokeUserToken(me, shortToken string) error {
+	s.revoked = append(s.revoked, me+" "+shortToken)
+	return nil
+}
+func (s *fakeExampleStore) RevokeClient(me, clientID string) error { return nil }
+func (s *fakeExampleStore) RevokeAll(string) error                 { return nil }
+func (s *fakeExampleStore) Forget(string) error                    { return nil }
+func (s *fakeExampleStore) Export(string) (data.Export, error)     { return data.Export{}, nil }
+func (s *fakeExampleStore) RemoveLogin(me, id string) error        { return nil }
+func (s *fakeExampleStore) Logins(*http.Request, string) ([]data.Login, error) {
+	return s.logins, nil
+}
+func (s *fakeExampleStore) AuditEvents(data.AuditQuery) ([]data.AuditEvent, error) {
+	return s.activity, nil
+}
+
+type fakeLocator map[string]string
+
+func (l fakeLocator) Locate(ip string) string {
+	return l[ip]
+}
+
+func TestExampleAccountLocations(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	cookies := sessions.NewCookieStore([]byte("secret"))
+	store := &fakeExampleStore{
+		logins: []data.Login{
+			{ID: "a", IP: "192.0.2.1", CreatedAt: time.Now()},
+			{ID: "b", IP: "198.51.100.1", CreatedAt: time.Now()},
+		},
+		activity: []data.AuditEvent{
+			{Action: data.AuditLogin, IP: "198.51.100.1", Outcome: data.AuditSuccess},
+			{Action: data.AuditLogin, IP: "192.0.2.1", Outcome: data.AuditSuccess},
+		},
+	}
+
+	get := func(locator Locator) accountCtx {
+		templates := &mockTemplate{}
+		handler := Example("http://localhost", config.Config{}, cookies, store, locator, &mockTemplate{}, templates)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		session, _ := cookies.Get(req, "example-session")
+		session.Values["me"] = "https://me.example.com/"
+		w := httptest.NewRecorder()
+		session.Save(req, w)
+		req.AddCookie(w.Result().Cookies()[0])
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		ctx, ok := templates.Data.(accountCtx)
+		assert(ok).Must.True()
+		return ctx
+	}
+
+	ctx := get(fakeLocator{"192.0.2.1": "London, GB"})
+	assert(ctx.Logins).Must.Len(2)
+	assert(ctx.Logins[0].ID).Equal("a")
+	assert(ctx.Logins[0].Location).Equal("London, GB")
+	assert(ctx.Logins[1].Location).Equal("Unknown")
+	assert(ctx.Activity).Must.Len(2)
+	assert(ctx.Activity[0].Location).Equal("Unknown")
+	assert(ctx.Activity[1].Location).Equal("London, GB")
+
+	ctx = get(nil)
+	assert(ctx.Logins).Must.Len(2)
+	assert(ctx.Logins[0].Location).Equal("Unknown")
+	assert(ctx.Activity).Must.Len(2)
+	assert(ctx.Activity[1].Location).Equal("Unknown")
+}
+
+func TestExampleRevoke(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	cookies := sessions.NewCookieStore([]byte("secret"))
+	store := &fakeExampleStore{}
+	handler := ExampleRevoke("http://localhost", cookies, store)
+
+	send := func(me string) {
+		req := httptest.NewRequest("POST", "/revoke?id=abcde&state=state", nil)
+		session, _ := cookies.Get(req, "example-session")
+		if me != "" {
+			session.Values["me"] = me
+		}
+		session.Values["state"] = "state"
+		w := httptest.NewRecorder()
+		session.Save(req, w)
+		req.AddCookie(w.Result().Cookies()[0])
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	send("")
+	assert(store.revoked).Len(0)
+	assert(store.events).Len(0)
+
+	send("https://me.example.com/")
+	assert(store.revoked).Equal([]string{"https://me.example.com/ abcde"})
+	if assert(store.events).Len(1) {
+		assert(store.events[0].Me).Equal("https://me.example.com/")
+		assert(store.events[0].Outcome).Equal(data.AuditSuccess)
+	}
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"hawx.me/code/mux"
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+type IntrospectDB interface {
+	TokenUsageDB
+	Token(string) (data.Token, error)
+}
+
+// Introspect allows a resource server to check whether an access token is
+// active, and who it was issued to. See RFC 7662.
+//
+// A token bound to a key is reported with the "DPoP" token_type and the key's
+// thumbprint, so that the resource server can check the proof it was sent. See
+// RFC 9449 section 6.2. The caller is the resource server, which does not hold
+// the client's key, so a DPoP proof is not required. If one is sent it must be
+// from the token's key, for the introspection request, otherwise the token is
+// reported as inactive.
+func Introspect(baseURL string, store IntrospectDB, proofs proofVerifier) http.Handler {
+	return mux.Method{
+		"POST": introspectEndpoint(baseURL, store, proofs),
+	}
+}
+
+func introspectEndpoint(baseURL string, store IntrospectDB, proofs proofVerifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		token := r.PostFormValue("token")
+		if token == "" {
+			writeJSONError(w, "invalid_request", "Missing 'token' parameter", http.StatusBadRequest)
+			return
+		}
+
+		response := introspectResponse{}
+
+		found, err := store.Token(token)
+		active := err == nil
+
+		// a proof is not required, but one that is sent must be valid
+		if active && r.Header.Get("DPoP") != "" {
+			active = checkProof(proofs, r, baseURL+"/introspect", token, found)
+		}
+
+		if active {
+			store.RecordTokenUse(found.ShortToken, data.RemoteIP(r), time.Now().UTC())
+
+			response = introspectResponse{
+				Active:   true,
+				Me:       found.Me,
+				ClientID: found.ClientID,
+				Scope:    found.Scope,
+				Resource: found.Resource,
+				IssuedAt: found.CreatedAt.Unix(),
+			}
+			if !found.ExpiresAt.IsZero() {
+				response.ExpiresAt = found.ExpiresAt.Unix()
+			}
+			if found.DPoPThumbprint != "" {
+				response.TokenType = "DPoP"
+				response.Confirmation = &confirmation{Thumbprint: found.DPoPThumbprint}
+			} else {
+				response.TokenType = "Bearer"
+			}
+		}
+
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Println("handler/introspect failed to write response:", err)
+		}
+	}
+}
+
+type introspectResponse struct {
+	Active       bool          `json:"active"`
+	Me           string        `json:"me,omitempty"`
+	ClientID     string        `json:"client_id,omitempty"`
+	Scope        string        `json:"scope,omitempty"`
+	Resource     string        `json:"resource,omitempty"`
+	TokenType    string        `json:"token_type,omitempty"`
+	IssuedAt     int64         `json:"iat,omitempty"`
+	ExpiresAt    int64         `json:"exp,omitempty"`
+	Confirmation *confirmation `json:"cnf,omitempty"`
+}
+
+type confirmation struct {
+	Thumbprint string `json:"jkt"`
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/dpop"
+	"hawx.me/code/relme-auth/internal/jwt"
+)
+
+func TestIntrospect(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	now := time.Now()
+	token := data.Token{
+		ShortToken: "abcde",
+		ClientID:   "http://client.example.com",
+		Scope:      "create update",
+		Me:         "it is me",
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+
+	store := &fakeTokenStore{token: token}
+
+	s := httptest.NewServer(Introspect("http://localhost", store, dpop.New(time.Minute)))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{"token": {"abcde"}})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+	assert(resp.Header.Get("Content-Type")).Equal("application/json")
+
+	var v struct {
+		Active    bool   `json:"active"`
+		Me        string `json:"me"`
+		ClientID  string `json:"client_id"`
+		Scope     string `json:"scope"`
+		TokenType string `json:"token_type"`
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.Active).True()
+	assert(v.Me).Equal(token.Me)
+	assert(v.ClientID).Equal(token.ClientID)
+	assert(v.Scope).Equal(token.Scope)
+	assert(v.TokenType).Equal("Bearer")
+	assert(v.IssuedAt).Equal(now.Unix())
+	assert(v.ExpiresAt).Equal(now.Add(time.Hour).Unix())
+	assert(store.uses).Equal([]string{"abcde"})
+}
+
+func TestIntrospectInactive(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeTokenStore{}
+
+	s := httptest.NewServer(Introspect("http://localhost", store, dpop.New(time.Minute)))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{"token": {"abcde"}})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	var v map[string]interface{}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v).Equal(map[string]interface{}{"active": false})
+	assert(store.uses).Len(0)
+}
+
+func TestIntrospectWithBoundToken(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk := jwt.PublicKey("", &key.PublicKey)
+
+	token := data.Token{
+		ShortToken:     "abcde",
+		ClientID:       "http://client.example.com",
+		Me:             "it is me",
+		CreatedAt:      time.Now(),
+		DPoPThumbprint: jwk.Thumbprint(),
+	}
+
+	s := httptest.NewServer(Introspect("http://localhost", &fakeTokenStore{token: token}, dpop.New(time.Minute)))
+	defer s.Close()
+
+	// the resource server does not hold the client's key, so sends no proof
+	resp, err := http.PostForm(s.URL, url.Values{"token": {"abcde"}})
+	assert(err).Must.Nil()
+
+	var v struct {
+		Active       bool   `json:"active"`
+		TokenType    string `json:"token_type"`
+		Confirmation struct {
+			Thumbprint string `json:"jkt"`
+		} `json:"cnf"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.Active).True()
+	assert(v.TokenType).Equal("DPoP")
+	assert(v.Confirmation.Thumbprint).Equal(jwk.Thumbprint())
+}
+
+func TestIntrospectWithBoundTokenAndProof(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk := jwt.PublicKey("", &key.PublicKey)
+
+	token := data.Token{
+		ShortToken:     "abcde",
+		ClientID:       "http://client.example.com",
+		Me:             "it is me",
+		CreatedAt:      time.Now(),
+		DPoPThumbprint: jwk.Thumbprint(),
+	}
+
+	s := httptest.NewServer(Introspect("http://localhost", &fakeTokenStore{token: token}, dpop.New(time.Minute)))
+	defer s.Close()
+
+	testCases := map[string]struct {
+		proof  string
+		active bool
+	}{
+		"valid proof":          {fakeProof(key, "POST", "http://localhost/introspect", "abcde"), true},
+		"proof from other key": {fakeProof(otherKey, "POST", "http://localhost/introspect", "abcde"), false},
+		"proof for other uri":  {fakeProof(key, "POST", "http://localhost/token", "abcde"), false},
+		"invalid proof":        {"what", false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", s.URL, strings.NewReader(url.Values{"token": {"abcde"}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("DPoP", tc.proof)
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+
+			var v struct {
+				Active bool `json:"active"`
+			}
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(&v))
+			assert.Equal(t, tc.active, v.Active)
+		})
+	}
+}
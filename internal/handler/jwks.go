@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"hawx.me/code/relme-auth/internal/jwt"
+)
+
+type JWKSDB interface {
+	PublicKeys() (jwt.KeySet, error)
+}
+
+// JWKS serves the public keys that JWT access tokens are signed with, so that
+// resource servers can verify them without calling the token endpoint.
+func JWKS(store JWKSDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := store.PublicKeys()
+		if err != nil {
+			log.Println("handler/jwks failed to get keys:", err)
+			http.Error(w, "something went wrong", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		if err := json.NewEncoder(w).Encode(keys); err != nil {
+			log.Println("handler/jwks failed to write response:", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"hawx.me/code/relme-auth/internal/config"
+)
+
+// Metadata serves the authorization server metadata document describing the
+// endpoints and features that are supported. See RFC 8414.
+func Metadata(baseURL string, conf config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(metadataResponse{
+			Issuer:                             baseURL,
+			AuthorizationEndpoint:              baseURL + "/auth",
+			TokenEndpoint:                      baseURL + "/token",
+			PushedAuthorizationRequestEndpoint: baseURL + "/par",
+			IntrospectionEndpoint:              baseURL + "/introspect",
+			DeviceAuthorizationEndpoint:        baseURL + "/device/authorize",
+			JWKSURI:                            baseURL + "/jwks",
+			ScopesSupported:                    config.ScopeNames(conf.Scopes),
+			ResponseTypesSupported:             []string{"code", "id"},
+			GrantTypesSupported:                []string{"authorization_code", deviceCodeGrantType, "ticket", "client_credentials", tokenExchangeGrantType},
+			TokenEndpointAuthMethodsSupported:  []string{"none", "client_secret_basic", "client_secret_post"},
+			CodeChallengeMethodsSupported:      []string{"S256", "plain"},
+			DPoPSigningAlgValuesSupported:      []string{"ES256"},
+		}); err != nil {
+			log.Println("handler/metadata failed to write response:", err)
+		}
+	}
+}
+
+type metadataResponse struct {
+	Issuer                             string   `json:"issuer"`
+	AuthorizationEndpoint              string   `json:"authorization_endpoint"`
+	TokenEndpoint                      string   `json:"token_endpoint"`
+	PushedAuthorizationRequestEndpoint string   `json:"pushed_authorization_request_endpoint"`
+	IntrospectionEndpoint              string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint        string   `json:"device_authorization_endpoint"`
+	JWKSURI                            string   `json:"jwks_uri"`
+	ScopesSupported                    []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported             []string `json:"response_types_supported"`
+	GrantTypesSupported                []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported  []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported      []string `json:"code_challenge_methods_supported"`
+	DPoPSigningAlgValuesSupported      []string `json:"dpop_signing_alg_values_supported"`
+}
@@ -0,0 +1,356 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"hawx.me/code/mux"
+	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/microformats"
+	"hawx.me/code/relme-auth/internal/strategy"
+)
+
+type MethodsDB interface {
+	Profile(string) (data.Profile, error)
+	CacheProfile(data.Profile) error
+}
+
+// discoveryTimeout limits how long MethodEvents and Methods spend discovering
+// methods. It must be less than the server's WriteTimeout, of 10 seconds, so
+// that the final event can be written before the connection is closed.
+var discoveryTimeout = 8 * time.Second
+
+// MethodEvents returns a http.Handler that streams the authentication methods
+// for a user as Server-Sent Events. Each event has the same data as the
+// messages sent by WebSocket, for when a websocket can't be used.
+func MethodEvents(strategies strategy.Strategies, store MethodsDB, relMe *microformats.RelMe) http.Handler {
+	finder := &methodFinder{strategies: strategies, store: store, relMe: relMe}
+
+	return mux.Method{
+		"GET": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request, ok := parseProfileRequest(r)
+			if !ok {
+				http.Error(w, "me is invalid", http.StatusBadRequest)
+				return
+			}
+
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			flusher.Flush()
+
+			ctx, cancel := context.WithTimeout(r.Context(), discoveryTimeout)
+			defer cancel()
+
+			finder.find(ctx, &eventStream{w: w, flusher: flusher}, request)
+		}),
+	}
+}
+
+// Methods returns a http.Handler that responds with the authentication methods
+// for a user as JSON, once they have all been found. This is used when neither
+// a websocket or Server-Sent Events can be used.
+func Methods(strategies strategy.Strategies, store MethodsDB, relMe *microformats.RelMe) http.Handler {
+	finder := &methodFinder{strategies: strategies, store: store, relMe: relMe}
+
+	return mux.Method{
+		"GET": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+
+			request, ok := parseProfileRequest(r)
+			if !ok {
+				writeJSONError(w, "invalid_request", "me is invalid", http.StatusBadRequest)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), discoveryTimeout)
+			defer cancel()
+
+			collected := &methodCollector{}
+			finder.find(ctx, collected, request)
+
+			if collected.failed {
+				writeJSONError(w, "server_error", "could not retrieve the profile", http.StatusBadGateway)
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(collected.response); err != nil {
+				log.Println("handler/methods failed to write response:", err)
+			}
+		}),
+	}
+}
+
+func parseProfileRequest(r *http.Request) (request profileRequest, ok bool) {
+	request = profileRequest{
+		Me:          data.ParseProfileURL(r.FormValue("me")),
+		ClientID:    r.FormValue("client_id"),
+		RedirectURI: r.FormValue("redirect_uri"),
+		Force:       r.FormValue("force") == "true",
+	}
+
+	return request, request.Me != ""
+}
+
+type profileRequest struct {
+	Me          string
+	ClientID    string
+	RedirectURI string
+	Force       bool
+}
+
+type profileResponse struct {
+	CachedAt string
+	Methods  []chooseCtxMethod
+}
+
+type eventResponse struct {
+	Type   string
+	Link   string
+	Method chooseCtxMethod
+}
+
+type chooseCtxMethod struct {
+	Query        string
+	StrategyName string
+	ProfileURL   string
+}
+
+// sender is given each message, a profileResponse or eventResponse, while the
+// methods for a user are found.
+type sender interface {
+	send(msg interface{})
+}
+
+// methodFinder finds the authentication methods for a user, either from the
+// cache or by discovering them.
+type methodFinder struct {
+	strategies strategy.Strategies
+	store      MethodsDB
+	relMe      *microformats.RelMe
+}
+
+// find sends the cached methods for request, if they can be used, otherwise it
+// discovers them sending an event as each is found.
+func (f *methodFinder) find(ctx context.Context, conn sender, request profileRequest) {
+	if profile, ok := f.canUseCache(request); ok {
+		f.getFromCache(conn, request, profile)
+		return
+	}
+
+	f.discover(ctx, conn, request)
+}
+
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *eventStream) send(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("handler/methods failed to encode event:", err)
+		return
+	}
+
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		log.Println("handler/methods failed to send event:", err)
+		return
+	}
+	s.flusher.Flush()
+}
+
+// methodCollector turns the messages sent while finding methods into a single
+// profileResponse.
+type methodCollector struct {
+	response profileResponse
+	failed   bool
+}
+
+func (c *methodCollector) send(msg interface{}) {
+	switch msg := msg.(type) {
+	case profileResponse:
+		c.response = msg
+
+	case eventResponse:
+		switch msg.Type {
+		case "verified", "pgp":
+			c.response.Methods = append(c.response.Methods, msg.Method)
+		case "error":
+			c.failed = c.failed || msg.Link == ""
+		case "done":
+			c.response.CachedAt = time.Now().UTC().Format("2 Jan")
+		}
+	}
+}
+
+func (f *methodFinder) discover(ctx context.Context, conn sender, request profileRequest) {
+	profile := data.Profile{
+		Me:        request.Me,
+		UpdatedAt: time.Now().UTC(),
+		Methods:   []data.Method{},
+	}
+
+	if err := f.readAllEvents(ctx, conn, request, &profile); err != nil {
+		if err == context.DeadlineExceeded {
+			// the methods verified so far can still be used, but as others may have
+			// been missed the profile is not cached
+			if len(profile.Methods) > 0 {
+				conn.send(eventResponse{Type: "done"})
+			} else {
+				conn.send(eventResponse{Type: "error"})
+			}
+			return
+		}
+		if err != context.Canceled {
+			log.Println("handler/methods failed to read all events:", err)
+		}
+		return
+	}
+	conn.send(eventResponse{Type: "done"})
+
+	if err := f.store.CacheProfile(profile); err != nil {
+		log.Println("handler/methods failed to cache profile:", err)
+	}
+}
+
+func (f *methodFinder) canUseCache(request profileRequest) (profile data.Profile, ok bool) {
+	if request.Force {
+		return
+	}
+
+	profile, err := f.store.Profile(request.Me)
+	if err != nil {
+		return
+	}
+
+	return profile, profile.Me != "" && !profile.Expired()
+}
+
+func (f *methodFinder) getFromCache(conn sender, request profileRequest, profile data.Profile) {
+	conn.send(profileResponse{
+		CachedAt: profile.UpdatedAt.Format("2 Jan"),
+		Methods:  cachedMethods(request.Me, request.RedirectURI, profile),
+	})
+}
+
+// cachedMethods returns a method that can be chosen for each of the methods
+// stored in profile.
+func cachedMethods(me, redirectURI string, profile data.Profile) []chooseCtxMethod {
+	var methods []chooseCtxMethod
+
+	for _, method := range profile.Methods {
+		query := url.Values{
+			"me":           {me},
+			"provider":     {method.Provider},
+			"profile":      {method.Profile},
+			"redirect_uri": {redirectURI},
+		}
+
+		methods = append(methods, chooseCtxMethod{
+			Query:        query.Encode(),
+			StrategyName: method.Provider,
+			ProfileURL:   method.Profile,
+		})
+	}
+
+	return methods
+}
+
+func (f *methodFinder) readAllEvents(ctx context.Context, conn sender, request profileRequest, profile *data.Profile) error {
+	meCh := f.relMe.Me(ctx, request.Me, f.strategies)
+
+	for {
+		var event microformats.Event
+		var ok bool
+
+		select {
+		case event, ok = <-meCh:
+			if !ok {
+				return ctx.Err()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		switch event.Type {
+		case microformats.Error:
+			if event.Link == "" {
+				conn.send(eventResponse{Type: "error"})
+				return event.Err
+			}
+
+			conn.send(eventResponse{Type: "error", Link: event.Link})
+
+		case microformats.PGP:
+			if strategy, ok := f.strategies.IsAllowed("pgp"); ok {
+
+				query := url.Values{
+					"me":           {request.Me},
+					"provider":     {strategy.Name()},
+					"profile":      {event.Link},
+					"redirect_uri": {request.RedirectURI},
+				}
+
+				conn.send(eventResponse{
+					Type: "pgp",
+					Link: event.Link,
+					Method: chooseCtxMethod{
+						Query:        query.Encode(),
+						StrategyName: "pgp",
+						ProfileURL:   event.Link,
+					},
+				})
+
+				profile.Methods = append(profile.Methods, data.Method{
+					Provider: strategy.Name(),
+					Profile:  event.Link,
+				})
+			}
+
+		case microformats.Found:
+			if _, ok := f.strategies.IsAllowed(event.Link); ok {
+				conn.send(eventResponse{Type: "found", Link: event.Link})
+			} else {
+				conn.send(eventResponse{Type: "not-supported", Link: event.Link})
+			}
+
+		case microformats.Unverified:
+			conn.send(eventResponse{Type: "unverified", Link: event.Link})
+
+		case microformats.Verified:
+			if strategy, ok := f.strategies.IsAllowed(event.Link); ok {
+				query := url.Values{
+					"me":           {request.Me},
+					"provider":     {strategy.Name()},
+					"profile":      {event.Link},
+					"redirect_uri": {request.RedirectURI},
+				}
+
+				conn.send(eventResponse{Type: "verified", Link: event.Link, Method: chooseCtxMethod{
+					Query:        query.Encode(),
+					StrategyName: strategy.Name(),
+					ProfileURL:   event.Link,
+				}})
+
+				profile.Methods = append(profile.Methods, data.Method{
+					Provider: strategy.Name(),
+					Profile:  event.Link,
+				})
+			} else {
+				conn.send(eventResponse{Type: "not-supported", Link: event.Link})
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/microformats"
+	"hawx.me/code/relme-auth/internal/strategy"
+)
+
+func TestMethodsWithInvalidMe(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	for _, handler := range []http.Handler{
+		Methods(strategy.Strategies{}, nil, &microformats.RelMe{}),
+		MethodEvents(strategy.Strategies{}, nil, &microformats.RelMe{}),
+	} {
+		s := httptest.NewServer(handler)
+
+		resp, err := http.Get(s.URL + "?me=ftp://example.com")
+		assert(err).Must.Nil()
+		assert(resp.StatusCode).Equal(http.StatusBadRequest)
+
+		s.Close()
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	w := httptest.NewRecorder()
+	stream := &eventStream{w: w, flusher: w}
+
+	stream.send(eventResponse{Type: "found", Link: "https://github.com/me"})
+	stream.send(eventResponse{Type: "done"})
+
+	assert(w.Flushed).True()
+	assert(w.Body.String()).Equal(
+		`data: {"Type":"found","Link":"https://github.com/me","Method":{"Query":"","StrategyName":"","ProfileURL":""}}` + "\n\n" +
+			`data: {"Type":"done","Link":"","Method":{"Query":"","StrategyName":"","ProfileURL":""}}` + "\n\n")
+}
+
+func TestMethodCollector(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	github := chooseCtxMethod{Query: "a", StrategyName: "github", ProfileURL: "https://github.com/me"}
+	pgp := chooseCtxMethod{Query: "b", StrategyName: "pgp", ProfileURL: "https://me.example.com/key"}
+
+	collected := &methodCollector{}
+	collected.send(eventResponse{Type: "pgp", Link: pgp.ProfileURL, Method: pgp})
+	collected.send(eventResponse{Type: "found", Link: github.ProfileURL})
+	collected.send(eventResponse{Type: "found", Link: "https://flickr.com/me"})
+	collected.send(eventResponse{Type: "error", Link: "https://flickr.com/me"})
+	collected.send(eventResponse{Type: "verified", Link: github.ProfileURL, Method: github})
+	collected.send(eventResponse{Type: "done"})
+
+	assert(collected.failed).False()
+	assert(collected.response.Methods).Equal([]chooseCtxMethod{pgp, github})
+	assert(collected.response.CachedAt).Equal(time.Now().UTC().Format("2 Jan"))
+
+	collected = &methodCollector{}
+	collected.send(eventResponse{Type: "error"})
+	assert(collected.failed).True()
+}
+
+type fakeMethodsStore struct {
+	cached []data.Profile
+}
+
+func (s *fakeMethodsStore) Profile(me string) (data.Profile, error) {
+	return data.Profile{}, errors.New("nope")
+}
+
+func (s *fakeMethodsStore) CacheProfile(profile data.Profile) error {
+	s.cached = append(s.cached, profile)
+	return nil
+}
+
+// slowTransport serves a profile at me.example.com with a link that verifies
+// quickly, and a link that does not respond until the request is cancelled.
+type slowTransport struct{}
+
+func (slowTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+
+	switch r.URL.Host {
+	case "me.example.com":
+		fmt.Fprint(w, `<a rel="me" href="https://fast.example.com/">fast</a><a rel="me" href="https://slow.example.com/">slow</a>`)
+	case "fast.example.com":
+		fmt.Fprint(w, `<a rel="me" href="https://me.example.com/">me</a>`)
+	default:
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	}
+
+	resp := w.Result()
+	resp.Request = r
+	return resp, nil
+}
+
+func TestMethodsWithSlowLink(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	oldTimeout := discoveryTimeout
+	discoveryTimeout = 200 * time.Millisecond
+	defer func() { discoveryTimeout = oldTimeout }()
+
+	const profile = "https://me.example.com/"
+
+	relMe := &microformats.RelMe{
+		Client: &http.Client{Transport: slowTransport{}},
+		NoRedirectClient: &http.Client{
+			Transport: slowTransport{},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		LinkTimeout: time.Minute,
+	}
+	store := &fakeMethodsStore{}
+
+	t.Run("events", func(t *testing.T) {
+		s := httptest.NewServer(MethodEvents(strategy.Strategies{&fakeStrategy{}}, store, relMe))
+		defer s.Close()
+
+		start := time.Now()
+		resp, err := http.Get(s.URL + "?me=" + profile)
+		assert(err).Must.Nil()
+		defer resp.Body.Close()
+
+		var last eventResponse
+		var verified int
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			assert(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &last)).Must.Nil()
+			if last.Type == "verified" {
+				verified++
+			}
+		}
+
+		assert(time.Since(start) < 5*time.Second).True()
+		assert(verified).Equal(1)
+		assert(last.Type).Equal("done")
+	})
+
+	t.Run("json", func(t *testing.T) {
+		s := httptest.NewServer(Methods(strategy.Strategies{&fakeStrategy{}}, store, relMe))
+		defer s.Close()
+
+		start := time.Now()
+		resp, err := http.Get(s.URL + "?me=" + profile)
+		assert(err).Must.Nil()
+		defer resp.Body.Close()
+		assert(resp.StatusCode).Equal(http.StatusOK)
+
+		var v profileResponse
+		assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+
+		assert(time.Since(start) < 5*time.Second).True()
+		assert(v.Methods).Len(1)
+	})
+
+	// the partial results are not cached
+	assert(store.cached).Len(0)
+}
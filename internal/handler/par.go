@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"hawx.me/code/mux"
+	"hawx.me/code/relme-auth/internal/config"
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+type PushedAuthorizationDB interface {
+	Client(clientID, redirectURI string) (data.Client, error)
+	CreatePushedRequest(data.PushedRequest) error
+}
+
+// PushedAuthorization allows a client to send the parameters of an
+// authorization request directly, receiving a "request_uri" that can be given
+// to the authorization endpoint in their place. See RFC 9126.
+func PushedAuthorization(conf config.Config, store PushedAuthorizationDB, generator func() (string, error), expiresIn time.Duration) http.Handler {
+	return mux.Method{
+		"POST": pushedAuthorizationEndpoint(conf, store, generator, expiresIn),
+	}
+}
+
+func pushedAuthorizationEndpoint(conf config.Config, store PushedAuthorizationDB, generator func() (string, error), expiresIn time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			responseType        = r.PostFormValue("response_type")
+			clientID            = r.PostFormValue("client_id")
+			redirectURI         = r.PostFormValue("redirect_uri")
+			state               = r.PostFormValue("state")
+			codeChallenge       = r.PostFormValue("code_challenge")
+			codeChallengeMethod = r.PostFormValue("code_challenge_method")
+			scope               = r.PostFormValue("scope")
+			me                  = r.PostFormValue("me")
+		)
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.PostFormValue("request_uri") != "" {
+			writeJSONError(w, "invalid_request", "The 'request_uri' parameter must not be pushed", http.StatusBadRequest)
+			return
+		}
+
+		clientID = data.ParseClientID(clientID)
+		if clientID == "" {
+			writeJSONError(w, "invalid_request", "The 'client_id' parameter is invalid", http.StatusBadRequest)
+			return
+		}
+
+		if redirectURI == "" || state == "" {
+			writeJSONError(w, "invalid_request", "Missing parameter", http.StatusBadRequest)
+			return
+		}
+
+		if responseType == "" {
+			responseType = "id"
+		}
+		if responseType != "id" && responseType != "code" {
+			writeJSONError(w, "unsupported_response_type", "Unknown 'response_type'", http.StatusBadRequest)
+			return
+		}
+
+		if me != "" {
+			me = data.ParseProfileURL(me)
+			if me == "" {
+				writeJSONError(w, "invalid_request", "The 'me' parameter is invalid", http.StatusBadRequest)
+				return
+			}
+		}
+
+		client, err := store.Client(clientID, redirectURI)
+		if err != nil {
+			log.Println("handler/par failed to get client:", err)
+			writeJSONError(w, "invalid_request", "The 'redirect_uri' parameter is not valid for the client", http.StatusBadRequest)
+			return
+		}
+
+		id, err := generator()
+		if err != nil {
+			log.Println("handler/par could not generate request_uri:", err)
+			writeJSONError(w, "server_error", "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+
+		request := data.PushedRequest{
+			RequestURI:   data.PushedRequestURIPrefix + id,
+			ResponseType: responseType,
+			Me:           me,
+			ClientID:     client.ID,
+			RedirectURI:  redirectURI,
+			State:        state,
+			CreatedAt:    time.Now().UTC(),
+		}
+
+		if responseType == "code" {
+			request.CodeChallenge = codeChallenge
+			request.CodeChallengeMethod = codeChallengeMethod
+			request.Scope = strings.Join(config.ScopeNames(conf.FilterScopes(client.ID, strings.Fields(scope))), " ")
+		}
+
+		if err := store.CreatePushedRequest(request); err != nil {
+			log.Println("handler/par could not persist request:", err)
+			writeJSONError(w, "server_error", "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(pushedAuthorizationResponse{
+			RequestURI: request.RequestURI,
+			ExpiresIn:  int(expiresIn.Seconds()),
+		}); err != nil {
+			log.Println("handler/par failed to write response:", err)
+		}
+	}
+}
+
+type pushedAuthorizationResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int    `json:"expires_in"`
+}
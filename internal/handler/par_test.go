@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/config"
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+type fakePushedAuthorizationStore struct {
+	client  data.Client
+	request data.PushedRequest
+}
+
+func (s *fakePushedAuthorizationStore) Client(clientID, redirectURI string) (data.Client, error) {
+	if clientID == s.client.ID && redirectURI == s.client.RedirectURI {
+		return s.client, nil
+	}
+	return data.Client{}, errors.New("what")
+}
+
+func (s *fakePushedAuthorizationStore) CreatePushedRequest(request data.PushedRequest) error {
+	s.request = request
+	return nil
+}
+
+func TestPushedAuthorization(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakePushedAuthorizationStore{
+		client: data.Client{
+			ID:          "http://client.example.com/",
+			RedirectURI: "http://client.example.com/callback",
+		},
+	}
+
+	s := httptest.NewServer(PushedAuthorization(config.Config{}, store, func() (string, error) { return "abcde", nil }, time.Minute))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"http://client.example.com"},
+		"redirect_uri":          {"http://client.example.com/callback"},
+		"state":                 {"some-value"},
+		"code_challenge":        {"xyz"},
+		"code_challenge_method": {"S256"},
+		"scope":                 {"create update"},
+		"me":                    {"http://me.example.com"},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusCreated)
+	assert(resp.Header.Get("Content-Type")).Equal("application/json")
+
+	var v struct {
+		RequestURI string `json:"request_uri"`
+		ExpiresIn  int    `json:"expires_in"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.RequestURI).Equal("urn:ietf:params:oauth:request_uri:abcde")
+	assert(v.ExpiresIn).Equal(60)
+
+	assert(store.request.RequestURI).Equal("urn:ietf:params:oauth:request_uri:abcde")
+	assert(store.request.ResponseType).Equal("code")
+	assert(store.request.Me).Equal("http://me.example.com/")
+	assert(store.request.ClientID).Equal("http://client.example.com/")
+	assert(store.request.RedirectURI).Equal("http://client.example.com/callback")
+	assert(store.request.State).Equal("some-value")
+	assert(store.request.CodeChallenge).Equal("xyz")
+	assert(store.request.CodeChallengeMethod).Equal("S256")
+	assert(store.request.Scope).Equal("create update")
+}
+
+func TestPushedAuthorizationWithBadParams(t *testing.T) {
+	store := &fakePushedAuthorizationStore{
+		client: data.Client{
+			ID:          "http://client.example.com/",
+			RedirectURI: "http://client.example.com/callback",
+		},
+	}
+
+	s := httptest.NewServer(PushedAuthorization(config.Config{}, store, func() (string, error) { return "abcde", nil }, time.Minute))
+	defer s.Close()
+
+	testCases := map[string]url.Values{
+		"missing client_id": {
+			"redirect_uri": {"http://client.example.com/callback"},
+			"state":        {"some-value"},
+		},
+		"missing state": {
+			"client_id":    {"http://client.example.com/"},
+			"redirect_uri": {"http://client.example.com/callback"},
+		},
+		"untrusted redirect_uri": {
+			"client_id":    {"http://client.example.com/"},
+			"redirect_uri": {"http://evil.example.com/callback"},
+			"state":        {"some-value"},
+		},
+		"unknown response_type": {
+			"response_type": {"token"},
+			"client_id":     {"http://client.example.com/"},
+			"redirect_uri":  {"http://client.example.com/callback"},
+			"state":         {"some-value"},
+		},
+		"nested request_uri": {
+			"client_id":    {"http://client.example.com/"},
+			"redirect_uri": {"http://client.example.com/callback"},
+			"state":        {"some-value"},
+			"request_uri":  {"urn:ietf:params:oauth:request_uri:what"},
+		},
+	}
+
+	for name, form := range testCases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := http.PostForm(s.URL, form)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
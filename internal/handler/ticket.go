@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"hawx.me/code/relme-auth/internal/config"
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+var errTicketRejected = errors.New("ticket endpoint did not accept the ticket")
+
+type TicketDB interface {
+	CreateTicket(data.Ticket) error
+}
+
+type ticketEndpointFinder interface {
+	TicketEndpoint(profile string) (string, error)
+}
+
+// ticketGrant checks the ticket, returning the details to issue a token for.
+// The token is issued to the subject of the ticket, on behalf of the user that
+// created it.
+func ticketGrant(store TokenDB, w http.ResponseWriter, r *http.Request, client tokenClient) (data.Code, bool) {
+	ticket, err := store.RedeemTicket(r.FormValue("ticket"))
+	if err != nil {
+		writeJSONError(w, "invalid_grant", "The ticket provided was not valid", http.StatusBadRequest)
+		return data.Code{}, false
+	}
+
+	if ticket.Expired() {
+		writeJSONError(w, "invalid_grant", "The ticket provided was not valid", http.StatusBadRequest)
+		return data.Code{Me: ticket.Me, ClientID: ticket.Subject}, false
+	}
+
+	return data.Code{
+		Me:       ticket.Me,
+		ClientID: ticket.Subject,
+		Scope:    ticket.Scope,
+		Resource: ticket.Resource,
+	}, true
+}
+
+// ExampleTicket allows the signed-in user to grant another site access to one
+// of their resources. A ticket is sent to the ticket endpoint of the subject,
+// which can exchange it at the token endpoint for an access token. See
+// IndieAuth Ticket Auth.
+func ExampleTicket(
+	baseURL string,
+	conf config.Config,
+	store sessions.Store,
+	generator func(int) (string, error),
+	ticketStore TicketDB,
+	finder ticketEndpointFinder,
+	httpClient *http.Client,
+	templates tmpl,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := store.Get(r, "example-session")
+
+		if r.FormValue("state") != session.Values["state"] {
+			http.Error(w, "state did not match", http.StatusBadRequest)
+			return
+		}
+
+		me, ok := session.Values["me"].(string)
+		if !ok {
+			return
+		}
+
+		subject := data.ParseProfileURL(r.FormValue("subject"))
+		if subject == "" {
+			http.Error(w, "subject is not a valid profile URL", http.StatusBadRequest)
+			return
+		}
+
+		resource := r.FormValue("resource")
+		if resource == "" {
+			resource = me
+		}
+		if !sameHost(me, resource) {
+			http.Error(w, "resource must be on "+me, http.StatusBadRequest)
+			return
+		}
+
+		scope := r.FormValue("scope")
+		if scope == "" {
+			scope = "read"
+		}
+		scopes := conf.FilterScopes(subject, strings.Fields(scope))
+
+		ctx := ticketCtx{
+			ThisURI:  baseURL,
+			Me:       me,
+			Subject:  subject,
+			Resource: resource,
+		}
+
+		if err := sendTicket(generator, ticketStore, finder, httpClient, data.Ticket{
+			Me:        me,
+			Subject:   subject,
+			Resource:  resource,
+			Scope:     strings.Join(config.ScopeNames(scopes), " "),
+			CreatedAt: time.Now().UTC(),
+		}); err != nil {
+			log.Println("handler/ticket could not send ticket:", err)
+			ctx.Error = "The ticket could not be sent to " + subject
+		}
+
+		if err := templates.ExecuteTemplate(w, "page", ctx); err != nil {
+			log.Println("handler/ticket failed to write template:", err)
+		}
+	}
+}
+
+func sendTicket(generator func(int) (string, error), ticketStore TicketDB, finder ticketEndpointFinder, httpClient *http.Client, ticket data.Ticket) error {
+	endpoint, err := finder.TicketEndpoint(ticket.Subject)
+	if err != nil {
+		return err
+	}
+
+	ticket.Ticket, err = generator(32)
+	if err != nil {
+		return err
+	}
+
+	if err := ticketStore.CreateTicket(ticket); err != nil {
+		return err
+	}
+
+	resp, err := httpClient.PostForm(endpoint, url.Values{
+		"ticket":   {ticket.Ticket},
+		"resource": {ticket.Resource},
+		"subject":  {ticket.Subject},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errTicketRejected
+	}
+
+	return nil
+}
+
+func sameHost(a, b string) bool {
+	aURL, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+
+	bURL, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return aURL.Scheme == bURL.Scheme && strings.EqualFold(aURL.Host, bURL.Host)
+}
+
+type ticketCtx struct {
+	ThisURI  string
+	Me       string
+	Subject  string
+	Resource string
+	Error    string
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/config"
+	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/dpop"
+)
+
+type fakeTicketStore struct {
+	ticket data.Ticket
+}
+
+func (s *fakeTicketStore) CreateTicket(ticket data.Ticket) error {
+	s.ticket = ticket
+	return nil
+}
+
+type fakeTicketEndpointFinder map[string]string
+
+func (f fakeTicketEndpointFinder) TicketEndpoint(profile string) (string, error) {
+	if endpoint, ok := f[profile]; ok {
+		return endpoint, nil
+	}
+	return "", errors.New("no endpoint")
+}
+
+type fakeTicketTemplate struct {
+	ctx ticketCtx
+}
+
+func (t *fakeTicketTemplate) ExecuteTemplate(w io.Writer, name string, v interface{}) error {
+	t.ctx = v.(ticketCtx)
+	return nil
+}
+
+func TestTokenWithTicket(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeTokenStore{ticket: data.Ticket{
+		Ticket:    "abcde",
+		Me:        "https://me.example.com/",
+		Subject:   "https://friend.example.com/",
+		Resource:  "https://me.example.com/private",
+		Scope:     "read",
+		ExpiresAt: time.Now().Add(time.Minute),
+	}}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"grant_type": {"ticket"},
+		"ticket":     {"abcde"},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	var v struct {
+		AccessToken string `json:"access_token"`
+		Scope       string `json:"scope"`
+		Me          string `json:"me"`
+		Resource    string `json:"resource"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.AccessToken).Equal("relmeauth_ran8dom_ran24dom")
+	assert(v.Scope).Equal("read")
+	assert(v.Me).Equal("https://me.example.com/")
+	assert(v.Resource).Equal("https://me.example.com/private")
+
+	assert(store.token.ClientID).Equal("https://friend.example.com/")
+	assert(store.token.Resource).Equal("https://me.example.com/private")
+
+	resp, err = http.PostForm(s.URL, url.Values{
+		"grant_type": {"ticket"},
+		"ticket":     {"abcde"},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusBadRequest)
+}
+
+func TestTokenWithExpiredTicket(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeTokenStore{ticket: data.Ticket{
+		Ticket:    "abcde",
+		Me:        "https://me.example.com/",
+		Subject:   "https://friend.example.com/",
+		ExpiresAt: time.Now().Add(-time.Second),
+	}}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"grant_type": {"ticket"},
+		"ticket":     {"abcde"},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusBadRequest)
+
+	var v struct {
+		Error string `json:"error"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.Error).Equal("invalid_grant")
+}
+
+func TestExampleTicket(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var received url.Values
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		received = r.PostForm
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer endpoint.Close()
+
+	cookies := sessions.NewCookieStore([]byte("secret"))
+	store := &fakeTicketStore{}
+	templates := &fakeTicketTemplate{}
+	finder := fakeTicketEndpointFinder{"https://friend.example.com/": endpoint.URL}
+
+	handler := ExampleTicket("http://localhost", config.Config{}, cookies, fakeGenerator, store, finder, http.DefaultClient, templates)
+
+	send := func(values url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/ticket", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		session, _ := cookies.Get(req, "example-session")
+		session.Values["me"] = "https://me.example.com/"
+		session.Values["state"] = "state"
+		w := httptest.NewRecorder()
+		session.Save(req, w)
+		req.AddCookie(w.Result().Cookies()[0])
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(url.Values{
+		"state":    {"state"},
+		"subject":  {"https://friend.example.com"},
+		"resource": {"https://me.example.com/private"},
+	})
+	assert(rec.Code).Equal(http.StatusOK)
+	assert(templates.ctx.Error).Equal("")
+
+	assert(store.ticket.Ticket).Equal("ran32dom")
+	assert(store.ticket.Me).Equal("https://me.example.com/")
+	assert(store.ticket.Subject).Equal("https://friend.example.com/")
+	assert(store.ticket.Resource).Equal("https://me.example.com/private")
+	assert(store.ticket.Scope).Equal("read")
+
+	assert(received.Get("ticket")).Equal("ran32dom")
+	assert(received.Get("subject")).Equal("https://friend.example.com/")
+	assert(received.Get("resource")).Equal("https://me.example.com/private")
+
+	rec = send(url.Values{
+		"state":    {"state"},
+		"subject":  {"https://friend.example.com"},
+		"resource": {"https://someone.example.com/private"},
+	})
+	assert(rec.Code).Equal(http.StatusBadRequest)
+
+	rec = send(url.Values{
+		"state":   {"state"},
+		"subject": {"https://stranger.example.com"},
+	})
+	assert(rec.Code).Equal(http.StatusOK)
+	assert(templates.ctx.Error).Equal("The ticket could not be sent to https://stranger.example.com/")
+}
@@ -5,87 +5,143 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"hawx.me/code/mux"
 	"hawx.me/code/relme-auth/internal/data"
 )
 
 type TokenDB interface {
+	AuditDB
+	TokenUsageDB
 	Code(string) (data.Code, error)
 	Token(string) (data.Token, error)
 	CreateToken(data.Token) error
 	RevokeToken(string) error
+	SignToken(issuer string, token data.Token) (string, time.Time, error)
+	PollDeviceAuthorization(deviceCode string) (data.DeviceAuthorization, error)
+	RedeemTicket(ticket string) (data.Ticket, error)
+	RegisteredClient(clientID string) (data.RegisteredClient, error)
 }
 
-func Token(store TokenDB, generator func(int) (string, error)) http.Handler {
+// TokenUsageDB records when tokens are used, so that users can see which are
+// stale.
+type TokenUsageDB interface {
+	RecordTokenUse(shortToken, ip string, at time.Time)
+}
+
+type proofVerifier interface {
+	Verify(proof, method, uri, accessToken string) (thumbprint string, err error)
+}
+
+// Token issues access tokens in exchange for authorization codes, approved
+// device codes, tickets, other access tokens, or the credentials of a
+// registered client, and allows them to be verified or revoked. If useJWT is
+// true the access tokens issued are signed JWTs that expire, otherwise they are
+// opaque and do not expire.
+//
+// Registered clients must authenticate with their secret, and are only issued
+// tokens with the scopes they were registered with.
+//
+// If the request to issue a token contains a DPoP proof the token is bound to
+// the proof's key, and can then only be used alongside a proof from that key.
+func Token(baseURL string, store TokenDB, generator func(int) (string, error), useJWT bool, proofs proofVerifier) http.Handler {
 	return mux.Method{
-		"POST": tokenEndpoint(store, generator),
-		"GET":  verifyTokenEndpoint(store),
+		"POST": tokenEndpoint(baseURL, store, generator, useJWT, proofs),
+		"GET":  verifyTokenEndpoint(baseURL, store, proofs),
 	}
 }
 
-func tokenEndpoint(store TokenDB, generator func(int) (string, error)) http.HandlerFunc {
+func tokenEndpoint(baseURL string, store TokenDB, generator func(int) (string, error), useJWT bool, proofs proofVerifier) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.FormValue("action") == "revoke" {
-			token := r.FormValue("token")
-			if token != "" {
-				store.RevokeToken(token)
+			if token, err := store.Token(r.FormValue("token")); err == nil {
+				outcome := data.AuditSuccess
+				if err := store.RevokeToken(token.ShortToken); err != nil {
+					log.Println("handler/token could not revoke token:", err)
+					outcome = "error"
+				}
+
+				audit(store, r, data.AuditEvent{
+					Action:   data.AuditTokenRevoke,
+					Me:       token.Me,
+					ClientID: token.ClientID,
+					Outcome:  outcome,
+				})
 			}
 
 			return
 		}
 
-		var (
-			grantType    = r.FormValue("grant_type")
-			code         = r.FormValue("code")
-			clientID     = r.FormValue("client_id")
-			redirectURI  = r.FormValue("redirect_uri")
-			codeVerifier = r.FormValue("code_verifier")
-		)
+		// grant checks the request, returning the details to issue a token for. If
+		// it fails because the code, device authorization or ticket given can't be
+		// used the Code returned has the ClientID it was issued to, otherwise the
+		// Code is empty.
+		var grant func(TokenDB, http.ResponseWriter, *http.Request, tokenClient) (data.Code, bool)
 
-		if grantType != "authorization_code" {
+		switch r.FormValue("grant_type") {
+		case "authorization_code":
+			grant = authorizationCodeGrant
+		case deviceCodeGrantType:
+			grant = deviceCodeGrant
+		case "ticket":
+			grant = ticketGrant
+		case "client_credentials":
+			grant = clientCredentialsGrant
+		case tokenExchangeGrantType:
+			grant = tokenExchangeGrant
+		default:
 			writeJSONError(w, "invalid_request", "The grant_type is not understood", http.StatusBadRequest)
 			return
 		}
 
-		theCode, err := store.Code(code)
-		if err != nil || theCode.ResponseType != "code" {
-			writeJSONError(w, "invalid_request", "The code provided was not valid", http.StatusBadRequest)
-			return
+		var thumbprint string
+		if proof := r.Header.Get("DPoP"); proof != "" {
+			var err error
+			thumbprint, err = proofs.Verify(proof, r.Method, baseURL+"/token", "")
+			if err != nil {
+				writeJSONError(w, "invalid_dpop_proof", "The DPoP proof is not valid", http.StatusBadRequest)
+				return
+			}
 		}
 
-		if theCode.Expired() {
-			writeJSONError(w, "invalid_request", "The auth code has expired (valid for 60 seconds)", http.StatusBadRequest)
+		// Failures are only audited when they involve a registered client, or a
+		// code, device authorization or ticket that exists. Anyone can POST to this
+		// endpoint with whatever client_id they like, and devices poll it until the
+		// user has approved them, so auditing every failure would fill the log with
+		// rows nobody needs.
+		client, ok := authenticateClient(store, w, r)
+		if !ok {
+			if client.Registered != nil {
+				audit(store, r, data.AuditEvent{
+					Action:   data.AuditTokenCreate,
+					ClientID: client.ID,
+					Outcome:  "invalid_client",
+				})
+			}
 			return
 		}
 
-		if theCode.ClientID != data.ParseClientID(clientID) {
-			writeJSONError(w, "invalid_request", "The 'client_id' parameter did not match", http.StatusBadRequest)
-			return
-		}
-		if theCode.RedirectURI != redirectURI {
-			writeJSONError(w, "invalid_request", "The 'redirect_uri' parameter did not match", http.StatusBadRequest)
-			return
-		}
+		theCode, ok := grant(store, w, r, client)
+		if !ok {
+			if theCode.ClientID != "" || client.Registered != nil {
+				clientID := theCode.ClientID
+				if clientID == "" {
+					clientID = client.ID
+				}
 
-		if theCode.CodeChallenge != "" {
-			ok, err := theCode.VerifyChallenge(codeVerifier)
-			if err != nil {
-				writeJSONError(w, "invalid_request", err.Error(), http.StatusBadRequest)
-				return
+				audit(store, r, data.AuditEvent{
+					Action:   data.AuditTokenCreate,
+					Me:       theCode.Me,
+					ClientID: clientID,
+					Outcome:  "invalid_grant",
+				})
 			}
-			if !ok {
-				writeJSONError(w, "invalid_request", "Provided 'code_verifier' does not match initial challenge", http.StatusBadRequest)
-				return
-			}
-		} else if codeVerifier != "" {
-			writeJSONError(w, "invalid_request", "Provided 'code_verifier' but initial request did not contain a challenge", http.StatusBadRequest)
 			return
 		}
 
-		if len(theCode.Scope) == 0 {
-			writeJSONError(w, "invalid_request", "Scopeless code must be exchanged using authorization endpoint", http.StatusBadRequest)
-			return
+		if client.Registered != nil {
+			theCode.Scope = client.Registered.FilterScope(theCode.Scope)
 		}
 
 		token, tokenString, err := data.NewToken(generator, theCode)
@@ -94,6 +150,24 @@ func tokenEndpoint(store TokenDB, generator func(int) (string, error)) http.Hand
 			http.Error(w, "something went wrong", http.StatusInternalServerError)
 			return
 		}
+		token.DPoPThumbprint = thumbprint
+
+		tokenType := "Bearer"
+		if thumbprint != "" {
+			tokenType = "DPoP"
+		}
+
+		var expiresIn int
+		if useJWT {
+			var expiresAt time.Time
+			tokenString, expiresAt, err = store.SignToken(baseURL, token)
+			if err != nil {
+				log.Println("handler/token could not sign token:", err)
+				http.Error(w, "something went wrong", http.StatusInternalServerError)
+				return
+			}
+			expiresIn = int(expiresAt.Sub(token.CreatedAt).Seconds())
+		}
 
 		if err := store.CreateToken(token); err != nil {
 			log.Println("handler/token could not persist token:", err)
@@ -101,21 +175,92 @@ func tokenEndpoint(store TokenDB, generator func(int) (string, error)) http.Hand
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(tokenResponse{
+		audit(store, r, data.AuditEvent{
+			Action:   data.AuditTokenCreate,
+			Me:       token.Me,
+			ClientID: token.ClientID,
+			Outcome:  data.AuditSuccess,
+		})
+
+		response := tokenResponse{
 			AccessToken: tokenString,
-			TokenType:   "Bearer",
+			TokenType:   tokenType,
 			Scope:       token.Scope,
 			Me:          token.Me,
-		})
+			ExpiresIn:   expiresIn,
+			Resource:    token.Resource,
+		}
+		if token.ParentToken != "" {
+			response.IssuedTokenType = accessTokenTokenType
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
 	}
 }
 
-func verifyTokenEndpoint(store TokenDB) http.HandlerFunc {
+// authorizationCodeGrant checks the code, and that it was issued to the
+// client, returning the details to issue a token for.
+func authorizationCodeGrant(store TokenDB, w http.ResponseWriter, r *http.Request, client tokenClient) (data.Code, bool) {
+	var (
+		code         = r.FormValue("code")
+		redirectURI  = r.FormValue("redirect_uri")
+		codeVerifier = r.FormValue("code_verifier")
+	)
+
+	theCode, err := store.Code(code)
+	if err != nil {
+		writeJSONError(w, "invalid_request", "The code provided was not valid", http.StatusBadRequest)
+		return data.Code{}, false
+	}
+
+	if theCode.ResponseType != "code" {
+		writeJSONError(w, "invalid_request", "The code provided was not valid", http.StatusBadRequest)
+		return theCode, false
+	}
+
+	if theCode.Expired() {
+		writeJSONError(w, "invalid_request", "The auth code has expired (valid for 60 seconds)", http.StatusBadRequest)
+		return theCode, false
+	}
+
+	if theCode.ClientID != data.ParseClientID(client.ID) {
+		writeJSONError(w, "invalid_request", "The 'client_id' parameter did not match", http.StatusBadRequest)
+		return theCode, false
+	}
+	if theCode.RedirectURI != redirectURI {
+		writeJSONError(w, "invalid_request", "The 'redirect_uri' parameter did not match", http.StatusBadRequest)
+		return theCode, false
+	}
+
+	if theCode.CodeChallenge != "" {
+		ok, err := theCode.VerifyChallenge(codeVerifier)
+		if err != nil {
+			writeJSONError(w, "invalid_request", err.Error(), http.StatusBadRequest)
+			return theCode, false
+		}
+		if !ok {
+			writeJSONError(w, "invalid_request", "Provided 'code_verifier' does not match initial challenge", http.StatusBadRequest)
+			return theCode, false
+		}
+	} else if codeVerifier != "" {
+		writeJSONError(w, "invalid_request", "Provided 'code_verifier' but initial request did not contain a challenge", http.StatusBadRequest)
+		return theCode, false
+	}
+
+	if len(theCode.Scope) == 0 {
+		writeJSONError(w, "invalid_request", "Scopeless code must be exchanged using authorization endpoint", http.StatusBadRequest)
+		return theCode, false
+	}
+
+	return theCode, true
+}
+
+func verifyTokenEndpoint(baseURL string, store TokenDB, proofs proofVerifier) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authParts := strings.Fields(r.Header.Get("Authorization"))
 
-		if len(authParts) != 2 || authParts[0] != "Bearer" {
+		if len(authParts) != 2 || (authParts[0] != "Bearer" && authParts[0] != "DPoP") {
 			http.Error(w, "", http.StatusUnauthorized)
 			return
 		}
@@ -126,15 +271,37 @@ func verifyTokenEndpoint(store TokenDB) http.HandlerFunc {
 			return
 		}
 
+		bound := token.DPoPThumbprint != ""
+		if bound != (authParts[0] == "DPoP") || !checkProof(proofs, r, baseURL+"/token", authParts[1], token) {
+			w.Header().Set("WWW-Authenticate", `DPoP algs="ES256"`)
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		store.RecordTokenUse(token.ShortToken, data.RemoteIP(r), time.Now().UTC())
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(tokenVerificationResponse{
 			Me:       token.Me,
 			ClientID: token.ClientID,
 			Scope:    token.Scope,
+			Resource: token.Resource,
 		})
 	}
 }
 
+// checkProof returns true if the token is not bound to a key, or if the request
+// contains a DPoP proof from the key the token is bound to.
+func checkProof(proofs proofVerifier, r *http.Request, uri, accessToken string, token data.Token) bool {
+	if token.DPoPThumbprint == "" {
+		return true
+	}
+
+	thumbprint, err := proofs.Verify(r.Header.Get("DPoP"), r.Method, uri, accessToken)
+
+	return err == nil && thumbprint == token.DPoPThumbprint
+}
+
 type meResponse struct {
 	Me string `json:"me"`
 }
@@ -144,10 +311,15 @@ type tokenResponse struct {
 	TokenType   string `json:"token_type"`
 	Scope       string `json:"scope"`
 	Me          string `json:"me"`
+	ExpiresIn   int    `json:"expires_in,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
 }
 
 type tokenVerificationResponse struct {
 	Me       string `json:"me"`
 	ClientID string `json:"client_id"`
 	Scope    string `json:"scope"`
+	Resource string `json:"resource,omitempty"`
 }
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenTokenType   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// tokenExchangeGrant allows a registered client, such as a resource server, to
+// exchange a token it was given for a new token to use with another service. The
+// new token may only have a subset of the scopes of the subject token, and is
+// revoked if the subject token is revoked. See RFC 8693.
+func tokenExchangeGrant(store TokenDB, w http.ResponseWriter, r *http.Request, client tokenClient) (data.Code, bool) {
+	var (
+		subjectToken     = r.FormValue("subject_token")
+		subjectTokenType = r.FormValue("subject_token_type")
+		scope            = r.FormValue("scope")
+		audience         = r.FormValue("audience")
+	)
+
+	if client.Registered == nil {
+		writeJSONError(w, "unauthorized_client", "Only registered clients may exchange tokens", http.StatusBadRequest)
+		return data.Code{}, false
+	}
+
+	if subjectTokenType != accessTokenTokenType {
+		writeJSONError(w, "invalid_request", "The 'subject_token_type' must be "+accessTokenTokenType, http.StatusBadRequest)
+		return data.Code{}, false
+	}
+
+	if requestedType := r.FormValue("requested_token_type"); requestedType != "" && requestedType != accessTokenTokenType {
+		writeJSONError(w, "invalid_request", "Only access tokens can be requested", http.StatusBadRequest)
+		return data.Code{}, false
+	}
+
+	subject, err := store.Token(subjectToken)
+	if err != nil {
+		writeJSONError(w, "invalid_grant", "The subject_token provided was not valid", http.StatusBadRequest)
+		return data.Code{}, false
+	}
+
+	if subject.DPoPThumbprint != "" {
+		writeJSONError(w, "invalid_grant", "The subject_token is bound to a key and can not be exchanged", http.StatusBadRequest)
+		return data.Code{}, false
+	}
+
+	if scope == "" {
+		scope = subject.Scope
+	} else if !isSubset(strings.Fields(scope), strings.Fields(subject.Scope)) {
+		writeJSONError(w, "invalid_scope", "The scope requested exceeds that of the subject_token", http.StatusBadRequest)
+		return data.Code{}, false
+	}
+
+	if audience == "" {
+		audience = r.FormValue("resource")
+	}
+
+	return data.Code{
+		Me:          subject.Me,
+		ClientID:    client.ID,
+		Scope:       scope,
+		Resource:    audience,
+		ParentToken: subject.ShortToken,
+	}, true
+}
+
+func isSubset(as, bs []string) bool {
+	for _, a := range as {
+		found := false
+		for _, b := range bs {
+			if a == b {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
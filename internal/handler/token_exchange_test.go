@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/dpop"
+)
+
+func TestTokenWithTokenExchange(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeTokenStore{
+		client: registeredClient,
+		token: data.Token{
+			ShortToken: "parent",
+			Me:         "https://me.example.com/",
+			ClientID:   "http://client.example.com/",
+			Scope:      "read create",
+		},
+	}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"grant_type":         {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"client_id":          {"http://bot.example.com/"},
+		"client_secret":      {"shh"},
+		"subject_token":      {"parent"},
+		"subject_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"scope":              {"read"},
+		"audience":           {"https://media.example.com/"},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	var v struct {
+		AccessToken     string `json:"access_token"`
+		IssuedTokenType string `json:"issued_token_type"`
+		Scope           string `json:"scope"`
+		Me              string `json:"me"`
+		Resource        string `json:"resource"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.AccessToken).Equal("relmeauth_ran8dom_ran24dom")
+	assert(v.IssuedTokenType).Equal("urn:ietf:params:oauth:token-type:access_token")
+	assert(v.Scope).Equal("read")
+	assert(v.Me).Equal("https://me.example.com/")
+	assert(v.Resource).Equal("https://media.example.com/")
+
+	assert(store.token.ParentToken).Equal("parent")
+	assert(store.token.ClientID).Equal("http://bot.example.com/")
+}
+
+func TestTokenWithBadTokenExchange(t *testing.T) {
+	store := &fakeTokenStore{
+		client: registeredClient,
+		token: data.Token{
+			ShortToken: "parent",
+			Me:         "https://me.example.com/",
+			ClientID:   "http://client.example.com/",
+			Scope:      "read",
+		},
+	}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	testCases := map[string]struct {
+		values url.Values
+		error  string
+	}{
+		"public client": {
+			values: url.Values{"client_id": {"http://other.example.com/"}},
+			error:  "unauthorized_client",
+		},
+		"wrong subject_token_type": {
+			values: url.Values{"client_secret": {"shh"}, "subject_token_type": {"urn:ietf:params:oauth:token-type:id_token"}},
+			error:  "invalid_request",
+		},
+		"unknown subject_token": {
+			values: url.Values{"client_secret": {"shh"}, "subject_token": {"what"}},
+			error:  "invalid_grant",
+		},
+		"wider scope": {
+			values: url.Values{"client_secret": {"shh"}, "scope": {"read create"}},
+			error:  "invalid_scope",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			form := url.Values{
+				"grant_type":         {"urn:ietf:params:oauth:grant-type:token-exchange"},
+				"client_id":          {"http://bot.example.com/"},
+				"subject_token":      {"parent"},
+				"subject_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+			}
+			for k, v := range tc.values {
+				form[k] = v
+			}
+
+			resp, err := http.PostForm(s.URL, form)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			var v struct {
+				Error string `json:"error"`
+			}
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(&v))
+			assert.Equal(t, tc.error, v.Error)
+		})
+	}
+}
@@ -1,24 +1,54 @@
 package handler
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"hawx.me/code/assert"
 	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/dpop"
+	"hawx.me/code/relme-auth/internal/jwt"
+	"hawx.me/code/relme-auth/internal/random"
 )
 
 func fakeGenerator(i int) (string, error) { return fmt.Sprintf("ran%ddom", i), nil }
 
+func fakeProof(key *ecdsa.PrivateKey, method, uri, accessToken string) string {
+	jwk := jwt.PublicKey("", &key.PublicKey)
+	jti, _ := random.String(10)
+
+	claims := map[string]interface{}{
+		"jti": jti,
+		"htm": method,
+		"htu": uri,
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		claims["ath"] = jwt.Hash(accessToken)
+	}
+
+	proof, _ := jwt.Sign(key, jwt.Header{Type: "dpop+jwt", JWK: &jwk}, claims)
+	return proof
+}
+
 type fakeTokenStore struct {
-	code  data.Code
-	token data.Token
+	fakeAuditStore
+	code   data.Code
+	token  data.Token
+	device data.DeviceAuthorization
+	ticket data.Ticket
+	client data.RegisteredClient
+	uses   []string
 }
 
 func (s *fakeTokenStore) Code(code string) (data.Code, error) {
@@ -35,6 +65,10 @@ func (s *fakeTokenStore) Token(t string) (data.Token, error) {
 	return data.Token{}, errors.New("no")
 }
 
+func (s *fakeTokenStore) RecordTokenUse(shortToken, ip string, at time.Time) {
+	s.uses = append(s.uses, shortToken)
+}
+
 func (s *fakeTokenStore) CreateToken(t data.Token) error {
 	s.token = t
 	return nil
@@ -45,6 +79,35 @@ func (s *fakeTokenStore) RevokeToken(t string) error {
 	return nil
 }
 
+func (s *fakeTokenStore) SignToken(issuer string, t data.Token) (string, time.Time, error) {
+	return "signed." + issuer + "." + t.ShortToken, t.CreatedAt.Add(time.Hour), nil
+}
+
+func (s *fakeTokenStore) PollDeviceAuthorization(deviceCode string) (data.DeviceAuthorization, error) {
+	if deviceCode == s.device.DeviceCode {
+		auth := s.device
+		s.device.LastPolledAt = time.Now()
+		return auth, nil
+	}
+	return data.DeviceAuthorization{}, errors.New("no")
+}
+
+func (s *fakeTokenStore) RedeemTicket(ticket string) (data.Ticket, error) {
+	if ticket == s.ticket.Ticket {
+		redeemed := s.ticket
+		s.ticket = data.Ticket{}
+		return redeemed, nil
+	}
+	return data.Ticket{}, errors.New("no")
+}
+
+func (s *fakeTokenStore) RegisteredClient(clientID string) (data.RegisteredClient, error) {
+	if clientID != "" && clientID == s.client.ID {
+		return s.client, nil
+	}
+	return data.RegisteredClient{}, data.ErrUnknownClient
+}
+
 func TestToken(t *testing.T) {
 	assert := assert.Wrap(t)
 
@@ -59,7 +122,9 @@ func TestToken(t *testing.T) {
 		Scope:        "create update",
 	}
 
-	s := httptest.NewServer(Token(&fakeTokenStore{code: code}, fakeGenerator))
+	store := &fakeTokenStore{code: code}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
 	defer s.Close()
 
 	resp, err := http.PostForm(s.URL, url.Values{
@@ -84,6 +149,254 @@ func TestToken(t *testing.T) {
 	assert(v.TokenType).Equal("Bearer")
 	assert(v.Scope).Equal(code.Scope)
 	assert(v.Me).Equal(code.Me)
+
+	assert(store.events).Len(1)
+	assert(store.events[0].Action).Equal(data.AuditTokenCreate)
+	assert(store.events[0].Me).Equal(code.Me)
+	assert(store.events[0].ClientID).Equal(code.ClientID)
+	assert(store.events[0].Outcome).Equal(data.AuditSuccess)
+
+	// a code that doesn't exist, from a client that isn't registered, is not
+	// audited
+	resp, err = http.PostForm(s.URL, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"wrong"},
+		"client_id":    {"http://junk.example.com/"},
+		"redirect_uri": {code.RedirectURI},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusBadRequest)
+	assert(store.events).Len(1)
+
+	resp, err = http.PostForm(s.URL, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code.Code},
+		"client_id":    {"http://other.example.com/"},
+		"redirect_uri": {code.RedirectURI},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusBadRequest)
+
+	assert(store.events).Len(2)
+	assert(store.events[1].Action).Equal(data.AuditTokenCreate)
+	assert(store.events[1].Me).Equal(code.Me)
+	assert(store.events[1].ClientID).Equal(code.ClientID)
+	assert(store.events[1].Outcome).Equal("invalid_grant")
+}
+
+func TestTokenWithDeviceCode(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeTokenStore{device: data.DeviceAuthorization{
+		DeviceCode:   "device",
+		ClientID:     "http://client.example.com/",
+		Scope:        "create",
+		LastPolledAt: time.Now().Add(-time.Minute),
+		ExpiresAt:    time.Now().Add(time.Minute),
+	}}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	poll := func() (int, string) {
+		resp, err := http.PostForm(s.URL, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {"device"},
+			"client_id":   {"http://client.example.com/"},
+		})
+		assert(err).Must.Nil()
+		defer resp.Body.Close()
+
+		var v struct {
+			Error       string `json:"error"`
+			AccessToken string `json:"access_token"`
+			Me          string `json:"me"`
+		}
+		assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+		if v.Error != "" {
+			return resp.StatusCode, v.Error
+		}
+		return resp.StatusCode, v.AccessToken + " " + v.Me
+	}
+
+	code, body := poll()
+	assert(code).Equal(http.StatusBadRequest)
+	assert(body).Equal("authorization_pending")
+
+	code, body = poll()
+	assert(code).Equal(http.StatusBadRequest)
+	assert(body).Equal("slow_down")
+
+	// polling is expected, so isn't audited
+	assert(store.events).Len(0)
+
+	store.device.Me = "https://me.example.com/"
+	store.device.LastPolledAt = time.Now().Add(-time.Minute)
+
+	code, body = poll()
+	assert(code).Equal(http.StatusOK)
+	assert(body).Equal("relmeauth_ran8dom_ran24dom https://me.example.com/")
+	assert(store.token.Scope).Equal("create")
+	assert(store.token.ClientID).Equal("http://client.example.com/")
+}
+
+func TestTokenWithDeniedDeviceCode(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := &fakeTokenStore{device: data.DeviceAuthorization{
+		DeviceCode: "device",
+		ClientID:   "http://client.example.com/",
+		Denied:     true,
+		ExpiresAt:  time.Now().Add(time.Minute),
+	}}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	for clientID, expected := range map[string]string{
+		"http://client.example.com/": "access_denied",
+		"http://other.example.com/":  "invalid_grant",
+	} {
+		resp, err := http.PostForm(s.URL, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {"device"},
+			"client_id":   {clientID},
+		})
+		assert(err).Must.Nil()
+		assert(resp.StatusCode).Equal(http.StatusBadRequest)
+
+		var v struct {
+			Error string `json:"error"`
+		}
+		assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+		assert(v.Error).Equal(expected)
+	}
+
+	assert(store.events).Len(2)
+	for _, event := range store.events {
+		assert(event.ClientID).Equal("http://client.example.com/")
+		assert(event.Outcome).Equal("invalid_grant")
+	}
+}
+
+func TestTokenWithJWT(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	code := data.Code{
+		ClientID:     "http://client.example.com/",
+		RedirectURI:  "http://done.example.com",
+		Me:           "it is me",
+		CreatedAt:    time.Now(),
+		ExpiresAt:    time.Now().Add(time.Minute),
+		Code:         "1234",
+		ResponseType: "code",
+		Scope:        "create update",
+	}
+
+	s := httptest.NewServer(Token("http://localhost", &fakeTokenStore{code: code}, fakeGenerator, true, dpop.New(time.Minute)))
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code.Code},
+		"client_id":    {code.ClientID},
+		"redirect_uri": {code.RedirectURI},
+	})
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	var v struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		Me          string `json:"me"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.AccessToken).Equal("signed.http://localhost.ran8dom")
+	assert(v.TokenType).Equal("Bearer")
+	assert(v.ExpiresIn).Equal(3600)
+	assert(v.Me).Equal(code.Me)
+}
+
+func TestTokenWithDPoP(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	code := data.Code{
+		ClientID:     "http://client.example.com/",
+		RedirectURI:  "http://done.example.com",
+		Me:           "it is me",
+		CreatedAt:    time.Now(),
+		ExpiresAt:    time.Now().Add(time.Minute),
+		Code:         "1234",
+		ResponseType: "code",
+		Scope:        "create update",
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk := jwt.PublicKey("", &key.PublicKey)
+	store := &fakeTokenStore{code: code}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	req, _ := http.NewRequest("POST", s.URL, strings.NewReader(url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code.Code},
+		"client_id":    {code.ClientID},
+		"redirect_uri": {code.RedirectURI},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("DPoP", fakeProof(key, "POST", "http://localhost/token", ""))
+
+	resp, err := http.DefaultClient.Do(req)
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusOK)
+
+	var v struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.AccessToken).Equal("relmeauth_ran8dom_ran24dom")
+	assert(v.TokenType).Equal("DPoP")
+	assert(store.token.DPoPThumbprint).Equal(jwk.Thumbprint())
+}
+
+func TestTokenWithInvalidDPoP(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	code := data.Code{
+		ClientID:     "http://client.example.com/",
+		RedirectURI:  "http://done.example.com",
+		Me:           "it is me",
+		CreatedAt:    time.Now(),
+		ExpiresAt:    time.Now().Add(time.Minute),
+		Code:         "1234",
+		ResponseType: "code",
+		Scope:        "create update",
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	s := httptest.NewServer(Token("http://localhost", &fakeTokenStore{code: code}, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	req, _ := http.NewRequest("POST", s.URL, strings.NewReader(url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code.Code},
+		"client_id":    {code.ClientID},
+		"redirect_uri": {code.RedirectURI},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("DPoP", fakeProof(key, "POST", "http://localhost/other", ""))
+
+	resp, err := http.DefaultClient.Do(req)
+	assert(err).Must.Nil()
+	assert(resp.StatusCode).Equal(http.StatusBadRequest)
+
+	var v jsonError
+	assert(json.NewDecoder(resp.Body).Decode(&v)).Must.Nil()
+	assert(v.Error).Equal("invalid_dpop_proof")
 }
 
 func TestTokenWithPKCE(t *testing.T) {
@@ -102,7 +415,7 @@ func TestTokenWithPKCE(t *testing.T) {
 		Scope:               "create update",
 	}
 
-	s := httptest.NewServer(Token(&fakeTokenStore{code: code}, fakeGenerator))
+	s := httptest.NewServer(Token("http://localhost", &fakeTokenStore{code: code}, fakeGenerator, false, dpop.New(time.Minute)))
 	defer s.Close()
 
 	resp, err := http.PostForm(s.URL, url.Values{
@@ -142,7 +455,7 @@ func TestTokenWithBadParams(t *testing.T) {
 		Scope:        "create update",
 	}
 
-	s := httptest.NewServer(Token(&fakeTokenStore{code: code}, fakeGenerator))
+	s := httptest.NewServer(Token("http://localhost", &fakeTokenStore{code: code}, fakeGenerator, false, dpop.New(time.Minute)))
 	defer s.Close()
 
 	testCases := map[string]url.Values{
@@ -212,7 +525,7 @@ func TestTokenWithBadPKCEParams(t *testing.T) {
 		Scope:               "create update",
 	}
 
-	s := httptest.NewServer(Token(&fakeTokenStore{code: code}, fakeGenerator))
+	s := httptest.NewServer(Token("http://localhost", &fakeTokenStore{code: code}, fakeGenerator, false, dpop.New(time.Minute)))
 	defer s.Close()
 
 	testCases := map[string]url.Values{
@@ -254,7 +567,7 @@ func TestTokenWithUnexpectedPKCEParams(t *testing.T) {
 		Scope:        "create update",
 	}
 
-	s := httptest.NewServer(Token(&fakeTokenStore{code: code}, fakeGenerator))
+	s := httptest.NewServer(Token("http://localhost", &fakeTokenStore{code: code}, fakeGenerator, false, dpop.New(time.Minute)))
 	defer s.Close()
 
 	resp, err := http.PostForm(s.URL, url.Values{
@@ -282,7 +595,7 @@ func TestTokenWithExpiredSession(t *testing.T) {
 		Scope:        "create update",
 	}
 
-	s := httptest.NewServer(Token(&fakeTokenStore{code: code}, fakeGenerator))
+	s := httptest.NewServer(Token("http://localhost", &fakeTokenStore{code: code}, fakeGenerator, false, dpop.New(time.Minute)))
 	defer s.Close()
 
 	resp, err := http.PostForm(s.URL, url.Values{
@@ -308,7 +621,7 @@ func TestRevokeToken(t *testing.T) {
 	}
 	sessionStore := &fakeTokenStore{token: token}
 
-	s := httptest.NewServer(Token(sessionStore, fakeGenerator))
+	s := httptest.NewServer(Token("http://localhost", sessionStore, fakeGenerator, false, dpop.New(time.Minute)))
 	defer s.Close()
 
 	resp, err := http.PostForm(s.URL, url.Values{
@@ -332,7 +645,9 @@ func TestVerifyToken(t *testing.T) {
 		CreatedAt:  time.Now(),
 	}
 
-	s := httptest.NewServer(Token(&fakeTokenStore{token: token}, fakeGenerator))
+	store := &fakeTokenStore{token: token}
+
+	s := httptest.NewServer(Token("http://localhost", store, fakeGenerator, false, dpop.New(time.Minute)))
 	defer s.Close()
 
 	req, _ := http.NewRequest("GET", s.URL, nil)
@@ -352,6 +667,7 @@ func TestVerifyToken(t *testing.T) {
 	assert(v.Me).Equal(token.Me)
 	assert(v.ClientID).Equal(token.ClientID)
 	assert(v.Scope).Equal(token.Scope)
+	assert(store.uses).Equal([]string{"abcde"})
 }
 
 func TestVerifyTokenWithBadParams(t *testing.T) {
@@ -363,7 +679,7 @@ func TestVerifyTokenWithBadParams(t *testing.T) {
 		Scope:      "create update",
 	}
 
-	s := httptest.NewServer(Token(&fakeTokenStore{token: token}, fakeGenerator))
+	s := httptest.NewServer(Token("http://localhost", &fakeTokenStore{token: token}, fakeGenerator, false, dpop.New(time.Minute)))
 	defer s.Close()
 
 	req, _ := http.NewRequest("GET", s.URL, nil)
@@ -386,3 +702,57 @@ func TestVerifyTokenWithBadParams(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyTokenWithDPoP(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk := jwt.PublicKey("", &key.PublicKey)
+
+	token := data.Token{
+		ShortToken:     "abcde",
+		ClientID:       "http://client.example.com",
+		Scope:          "create update",
+		Me:             "it is me",
+		CreatedAt:      time.Now(),
+		DPoPThumbprint: jwk.Thumbprint(),
+	}
+
+	s := httptest.NewServer(Token("http://localhost", &fakeTokenStore{token: token}, fakeGenerator, false, dpop.New(time.Minute)))
+	defer s.Close()
+
+	testCases := map[string]struct {
+		scheme string
+		proof  string
+		status int
+	}{
+		"valid proof": {
+			"DPoP", fakeProof(key, "GET", "http://localhost/token", "abcde"), http.StatusOK,
+		},
+		"bearer scheme": {
+			"Bearer", fakeProof(key, "GET", "http://localhost/token", "abcde"), http.StatusUnauthorized,
+		},
+		"missing proof": {
+			"DPoP", "", http.StatusUnauthorized,
+		},
+		"proof from other key": {
+			"DPoP", fakeProof(other, "GET", "http://localhost/token", "abcde"), http.StatusUnauthorized,
+		},
+		"proof for other token": {
+			"DPoP", fakeProof(key, "GET", "http://localhost/token", "xyz"), http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", s.URL, nil)
+			req.Header.Add("Authorization", tc.scheme+" "+token.ShortToken)
+			if tc.proof != "" {
+				req.Header.Add("DPoP", tc.proof)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+		})
+	}
+}
@@ -1,12 +1,11 @@
 package handler
 
 import (
+	"context"
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"sync"
-	"time"
 
 	"hawx.me/code/relme-auth/internal/data"
 	"hawx.me/code/relme-auth/internal/microformats"
@@ -24,10 +23,12 @@ type WebSocketDB interface {
 // client can request a set of authentication methods for a user.
 func WebSocket(strategies strategy.Strategies, store WebSocketDB, relMe *microformats.RelMe) http.Handler {
 	return &webSocketServer{
-		strategies:  strategies,
-		store:       store,
+		methodFinder: methodFinder{
+			strategies: strategies,
+			store:      store,
+			relMe:      relMe,
+		},
 		connections: map[*conn]struct{}{},
-		relMe:       relMe,
 	}
 }
 
@@ -36,32 +37,30 @@ func (s *webSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 type webSocketServer struct {
-	strategies strategy.Strategies
-	store      WebSocketDB
-	relMe      *microformats.RelMe
+	methodFinder
 
 	mu          sync.RWMutex
 	connections map[*conn]struct{}
 }
 
+// maxDiscoveries is the number of discoveries a single connection may have
+// running at once. Starting a new discovery cancels the previous, but it may
+// take some time to stop.
+const maxDiscoveries = 2
+
 type conn struct {
 	ID  string
 	Err error
 	ws  *websocket.Conn
-}
-
-type profileResponse struct {
-	CachedAt string
-	Methods  []chooseCtxMethod
-}
 
-type eventResponse struct {
-	Type   string
-	Link   string
-	Method chooseCtxMethod
+	mu          sync.Mutex
+	discoveries chan struct{}
 }
 
 func (c *conn) send(msg interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if err := websocket.JSON.Send(c.ws, msg); err != nil {
 		log.Println("handler/websocket failed to send message:", err)
 	}
@@ -69,9 +68,10 @@ func (c *conn) send(msg interface{}) {
 
 func (s *webSocketServer) addConnection(ws *websocket.Conn) *conn {
 	conn := &conn{
-		ID:  "",
-		Err: nil,
-		ws:  ws,
+		ID:          "",
+		Err:         nil,
+		ws:          ws,
+		discoveries: make(chan struct{}, maxDiscoveries),
 	}
 
 	s.mu.Lock()
@@ -96,20 +96,23 @@ func (s *webSocketServer) serve(ws *websocket.Conn) {
 	}
 }
 
-type profileRequest struct {
-	Me          string
-	ClientID    string
-	RedirectURI string
-	Force       bool
-}
-
+// serveConnection reads requests from the connection, starting a discovery for
+// each. Any discovery still running is cancelled when a new request is received
+// or the connection is closed.
 func (s *webSocketServer) serveConnection(conn *conn) error {
+	ctx, cancel := context.WithCancel(conn.ws.Request().Context())
+	defer cancel()
+
+	cancelDiscovery := func() {}
+
 	for {
 		var msg profileRequest
 		if err := websocket.JSON.Receive(conn.ws, &msg); err != nil {
 			return err
 		}
 
+		cancelDiscovery()
+
 		msg.Me = data.ParseProfileURL(msg.Me)
 
 		if profile, ok := s.canUseCache(msg); ok {
@@ -117,144 +120,13 @@ func (s *webSocketServer) serveConnection(conn *conn) error {
 			continue
 		}
 
-		profile := data.Profile{
-			Me:        msg.Me,
-			UpdatedAt: time.Now().UTC(),
-			Methods:   []data.Method{},
-		}
-
-		if err := s.readAllEvents(conn, msg, &profile); err != nil {
-			log.Println("handler/websocket failed to read all events:", err)
-			continue
-		}
-		conn.send(eventResponse{Type: "done"})
-
-		if err := s.store.CacheProfile(profile); err != nil {
-			log.Println("handler/websocket failed to cache profile:", err)
-		}
-	}
-}
-
-type chooseCtxMethod struct {
-	Query        string
-	StrategyName string
-	ProfileURL   string
-}
-
-func (s *webSocketServer) canUseCache(request profileRequest) (profile data.Profile, ok bool) {
-	if request.Force {
-		return
-	}
-
-	profile, err := s.store.Profile(request.Me)
-	if err != nil {
-		return
-	}
-
-	return profile, profile.Me != "" && !profile.Expired()
-}
-
-func (s *webSocketServer) getFromCache(conn *conn, request profileRequest, profile data.Profile) {
-	var methods []chooseCtxMethod
-	cachedAt := profile.UpdatedAt
-
-	for _, method := range profile.Methods {
-		query := url.Values{
-			"me":           {request.Me},
-			"provider":     {method.Provider},
-			"profile":      {method.Profile},
-			"redirect_uri": {request.RedirectURI},
-		}
-
-		methods = append(methods, chooseCtxMethod{
-			Query:        query.Encode(),
-			StrategyName: method.Provider,
-			ProfileURL:   method.Profile,
-		})
-	}
-
-	conn.send(profileResponse{
-		CachedAt: cachedAt.Format("2 Jan"),
-		Methods:  methods,
-	})
-}
-
-func (s *webSocketServer) readAllEvents(conn *conn, request profileRequest, profile *data.Profile) error {
-	meCh := s.relMe.Me(request.Me, s.strategies)
-
-	for {
-		event, ok := <-meCh
-		if !ok {
-			return nil
-		}
-
-		switch event.Type {
-		case microformats.Error:
-			if event.Link == "" {
-				conn.send(eventResponse{Type: "error"})
-				return event.Err
-			}
-
-			conn.send(eventResponse{Type: "error", Link: event.Link})
-
-		case microformats.PGP:
-			if strategy, ok := s.strategies.IsAllowed("pgp"); ok {
-
-				query := url.Values{
-					"me":           {request.Me},
-					"provider":     {strategy.Name()},
-					"profile":      {event.Link},
-					"redirect_uri": {request.RedirectURI},
-				}
-
-				conn.send(eventResponse{
-					Type: "pgp",
-					Link: event.Link,
-					Method: chooseCtxMethod{
-						Query:        query.Encode(),
-						StrategyName: "pgp",
-						ProfileURL:   event.Link,
-					},
-				})
+		discoveryCtx, cancel := context.WithCancel(ctx)
+		cancelDiscovery = cancel
 
-				profile.Methods = append(profile.Methods, data.Method{
-					Provider: strategy.Name(),
-					Profile:  event.Link,
-				})
-			}
-
-		case microformats.Found:
-			if _, ok := s.strategies.IsAllowed(event.Link); ok {
-				conn.send(eventResponse{Type: "found", Link: event.Link})
-			} else {
-				conn.send(eventResponse{Type: "not-supported", Link: event.Link})
-			}
-
-		case microformats.Unverified:
-			conn.send(eventResponse{Type: "unverified", Link: event.Link})
-
-		case microformats.Verified:
-			if strategy, ok := s.strategies.IsAllowed(event.Link); ok {
-				query := url.Values{
-					"me":           {request.Me},
-					"provider":     {strategy.Name()},
-					"profile":      {event.Link},
-					"redirect_uri": {request.RedirectURI},
-				}
-
-				conn.send(eventResponse{Type: "verified", Link: event.Link, Method: chooseCtxMethod{
-					Query:        query.Encode(),
-					StrategyName: strategy.Name(),
-					ProfileURL:   event.Link,
-				}})
-
-				profile.Methods = append(profile.Methods, data.Method{
-					Provider: strategy.Name(),
-					Profile:  event.Link,
-				})
-			} else {
-				conn.send(eventResponse{Type: "not-supported", Link: event.Link})
-			}
-		}
+		conn.discoveries <- struct{}{}
+		go func() {
+			defer func() { <-conn.discoveries }()
+			s.discover(discoveryCtx, conn, msg)
+		}()
 	}
 }
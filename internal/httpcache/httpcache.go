@@ -0,0 +1,224 @@
+// Package httpcache provides a http.RoundTripper that stores responses so they
+// can be reused, honouring Cache-Control, and revalidated with ETag and
+// Last-Modified.
+package httpcache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+type Store interface {
+	CachedResponse(key string) (data.CachedResponse, error)
+	CacheResponse(data.CachedResponse) error
+}
+
+// Transport uses a stored response for a GET request while it is fresh. Once
+// stale the request is made conditional, so that a server can reply with 304
+// Not Modified instead of sending the same page again.
+type Transport struct {
+	Base  http.RoundTripper
+	Store Store
+}
+
+// New returns a Transport that makes requests with base.
+func New(base http.RoundTripper, store Store) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{Base: base, Store: store}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" || req.Header.Get("Authorization") != "" || req.Header.Get("Range") != "" {
+		return t.Base.RoundTrip(req)
+	}
+
+	key := cacheKey(req)
+
+	cached, err := t.Store.CachedResponse(key)
+	hasCached := err == nil
+
+	if hasCached && cached.Fresh() {
+		return toResponse(req, cached), nil
+	}
+
+	if hasCached {
+		req = req.Clone(req.Context())
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	if hasCached && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+
+		// a 304 may update the headers, such as Cache-Control, of the response
+		if cached.Header == nil {
+			cached.Header = http.Header{}
+		}
+		for name, values := range resp.Header {
+			cached.Header[name] = values
+		}
+		cached.CreatedAt = now
+		cached.ExpiresAt = expiresAt(cached.Header, now)
+		if err := t.Store.CacheResponse(cached); err != nil {
+			log.Println("httpcache failed to update response:", err)
+		}
+
+		return toResponse(req, cached), nil
+	}
+
+	if !cacheable(resp) {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if err := t.Store.CacheResponse(data.CachedResponse{
+		Key:          key,
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header,
+		Body:         body,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		CreatedAt:    now,
+		ExpiresAt:    expiresAt(resp.Header, now),
+	}); err != nil {
+		log.Println("httpcache failed to store response:", err)
+	}
+
+	return resp, nil
+}
+
+// cacheKey includes the Accept header, as the same URL may be requested as HTML
+// and JSON.
+func cacheKey(req *http.Request) string {
+	return req.URL.String() + " " + req.Header.Get("Accept")
+}
+
+// keyedHeaders are the request headers a response may vary on and still be
+// stored. Accept is part of the cache key, and Accept-Encoding is set, and the
+// body decoded, by the base transport so is the same for every request.
+var keyedHeaders = map[string]bool{
+	"Accept":          true,
+	"Accept-Encoding": true,
+}
+
+// variesOnlyByKey returns true if the response's Vary header only names
+// headers in keyedHeaders, so that a stored response is only reused for
+// requests it would have been sent for.
+func variesOnlyByKey(header http.Header) bool {
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && !keyedHeaders[http.CanonicalHeaderKey(name)] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// toResponse creates a response for req from one that was stored.
+func toResponse(req *http.Request, cached data.CachedResponse) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(cached.StatusCode) + " " + http.StatusText(cached.StatusCode),
+		StatusCode:    cached.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        cached.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(cached.Body)),
+		ContentLength: int64(len(cached.Body)),
+		Request:       req,
+	}
+}
+
+// cacheable returns true if resp is successful, may be stored, and will be
+// either fresh for some time or can be revalidated.
+func cacheable(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || !variesOnlyByKey(resp.Header) {
+		return false
+	}
+
+	directives := cacheControl(resp.Header)
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+
+	return resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != "" ||
+		expiresAt(resp.Header, time.Now()).After(time.Now())
+}
+
+// expiresAt returns the time the response stops being fresh, using max-age or
+// Expires. If neither are given, or no-cache is, the response must be
+// revalidated every time.
+func expiresAt(header http.Header, now time.Time) time.Time {
+	directives := cacheControl(header)
+
+	if _, ok := directives["no-cache"]; ok {
+		return now
+	}
+
+	if maxAge, ok := directives["max-age"]; ok {
+		if seconds, err := strconv.Atoi(maxAge); err == nil && seconds > 0 {
+			return now.Add(time.Duration(seconds) * time.Second)
+		}
+		return now
+	}
+
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil && expires.After(now) {
+		return expires.UTC()
+	}
+
+	return now
+}
+
+func cacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+
+			parts := strings.SplitN(directive, "=", 2)
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			if len(parts) == 2 {
+				directives[name] = strings.Trim(strings.TrimSpace(parts[1]), `"`)
+			} else {
+				directives[name] = ""
+			}
+		}
+	}
+
+	return directives
+}
@@ -0,0 +1,232 @@
+package httpcache
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+type fakeStore map[string]data.CachedResponse
+
+func (s fakeStore) CachedResponse(key string) (data.CachedResponse, error) {
+	response, ok := s[key]
+	if !ok {
+		return response, sql.ErrNoRows
+	}
+	return response, nil
+}
+
+func (s fakeStore) CacheResponse(response data.CachedResponse) error {
+	s[response.Key] = response
+	return nil
+}
+
+func get(client *http.Client, url string) (int, string) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestTransportWithMaxAge(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		fmt.Fprint(w, "hello")
+	}))
+	defer s.Close()
+
+	client := &http.Client{Transport: New(nil, fakeStore{})}
+
+	status, body := get(client, s.URL)
+	assert(status).Equal(http.StatusOK)
+	assert(body).Equal("hello")
+
+	status, body = get(client, s.URL)
+	assert(status).Equal(http.StatusOK)
+	assert(body).Equal("hello")
+
+	assert(requests).Equal(1)
+}
+
+func TestTransportWithETag(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	requests, notModified := 0, 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "hello")
+	}))
+	defer s.Close()
+
+	client := &http.Client{Transport: New(nil, fakeStore{})}
+
+	for i := 0; i < 3; i++ {
+		status, body := get(client, s.URL)
+		assert(status).Equal(http.StatusOK)
+		assert(body).Equal("hello")
+	}
+
+	assert(requests).Equal(3)
+	assert(notModified).Equal(2)
+}
+
+func TestTransportWithLastModified(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	lastModified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+
+	notModified := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		fmt.Fprint(w, "hello")
+	}))
+	defer s.Close()
+
+	client := &http.Client{Transport: New(nil, fakeStore{})}
+
+	get(client, s.URL)
+	status, body := get(client, s.URL)
+	assert(status).Equal(http.StatusOK)
+	assert(body).Equal("hello")
+	assert(notModified).Equal(1)
+}
+
+func TestTransportWithNoStore(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "hello")
+	}))
+	defer s.Close()
+
+	store := fakeStore{}
+	client := &http.Client{Transport: New(nil, store)}
+
+	get(client, s.URL)
+	get(client, s.URL)
+
+	assert(requests).Equal(2)
+	assert(store).Len(0)
+}
+
+func TestTransportKeysOnAccept(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, r.Header.Get("Accept"))
+	}))
+	defer s.Close()
+
+	client := &http.Client{Transport: New(nil, fakeStore{})}
+
+	for _, accept := range []string{"text/html", "application/json", "text/html"} {
+		req, _ := http.NewRequest("GET", s.URL, nil)
+		req.Header.Set("Accept", accept)
+
+		resp, err := client.Do(req)
+		assert(err).Must.Nil()
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		assert(string(body)).Equal(accept)
+	}
+}
+
+func TestTransportWithVary(t *testing.T) {
+	// only responses that vary on headers in the cache key can be stored
+	testCases := map[string]bool{
+		"Accept":                  true,
+		"accept, Accept-Encoding": true,
+		"*":                       false,
+		"User-Agent":              false,
+		"Accept, Accept-Language": false,
+	}
+
+	for vary, stored := range testCases {
+		t.Run(vary, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("Vary", vary)
+				fmt.Fprint(w, "hello")
+			}))
+			defer s.Close()
+
+			store := fakeStore{}
+			client := &http.Client{Transport: New(nil, store)}
+
+			get(client, s.URL)
+
+			assert.Equal(t, stored, len(store) == 1)
+		})
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := map[string]struct {
+		header   http.Header
+		expected time.Time
+	}{
+		"none": {
+			header:   http.Header{},
+			expected: now,
+		},
+		"max-age": {
+			header:   http.Header{"Cache-Control": {"public, max-age=300"}},
+			expected: now.Add(5 * time.Minute),
+		},
+		"no-cache": {
+			header:   http.Header{"Cache-Control": {"no-cache, max-age=300"}},
+			expected: now,
+		},
+		"expires": {
+			header:   http.Header{"Expires": {now.Add(time.Hour).Format(http.TimeFormat)}},
+			expected: now.Add(time.Hour),
+		},
+		"max-age overrides expires": {
+			header: http.Header{
+				"Cache-Control": {"max-age=60"},
+				"Expires":       {now.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			expected: now.Add(time.Minute),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, expiresAt(tc.header, now))
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// ErrUnsupportedKey is returned when a JWK is not a P-256 public key.
+var ErrUnsupportedKey = errors.New("jwt: unsupported key")
+
+// Key is a JSON Web Key for an EC public key.
+type Key struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+}
+
+// KeySet is a JSON Web Key Set.
+type KeySet struct {
+	Keys []Key `json:"keys"`
+}
+
+// PublicKey returns the JWK representation of key.
+func PublicKey(kid string, key *ecdsa.PublicKey) Key {
+	size := curveSize(key.Curve)
+	x := make([]byte, size)
+	y := make([]byte, size)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	return Key{
+		KeyType:   "EC",
+		Curve:     "P-256",
+		X:         encode(x),
+		Y:         encode(y),
+		KeyID:     kid,
+		Use:       "sig",
+		Algorithm: algorithm,
+	}
+}
+
+// ECDSA returns the public key the JWK represents.
+func (k Key) ECDSA() (*ecdsa.PublicKey, error) {
+	if k.KeyType != "EC" || k.Curve != "P-256" {
+		return nil, ErrUnsupportedKey
+	}
+
+	x, err := decode(k.X)
+	if err != nil {
+		return nil, ErrUnsupportedKey
+	}
+	y, err := decode(k.Y)
+	if err != nil {
+		return nil, ErrUnsupportedKey
+	}
+
+	key := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, ErrUnsupportedKey
+	}
+
+	return key, nil
+}
+
+// Thumbprint returns the base64url encoded SHA-256 thumbprint of the key, as
+// defined by RFC 7638.
+func (k Key) Thumbprint() string {
+	// only the required members, in lexicographic order
+	canonical, _ := json.Marshal(struct {
+		Curve   string `json:"crv"`
+		KeyType string `json:"kty"`
+		X       string `json:"x"`
+		Y       string `json:"y"`
+	}{k.Curve, k.KeyType, k.X, k.Y})
+
+	sum := sha256.Sum256(canonical)
+	return encode(sum[:])
+}
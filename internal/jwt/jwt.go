@@ -0,0 +1,144 @@
+// Package jwt implements the parts of JSON Web Tokens needed to issue and
+// verify access tokens signed with ES256.
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+var (
+	// ErrMalformed is returned when a token can not be decoded.
+	ErrMalformed = errors.New("jwt: malformed token")
+
+	// ErrUnsupportedAlgorithm is returned when a token is not signed with ES256.
+	ErrUnsupportedAlgorithm = errors.New("jwt: unsupported algorithm")
+
+	// ErrInvalidSignature is returned when a token's signature does not match.
+	ErrInvalidSignature = errors.New("jwt: invalid signature")
+)
+
+const algorithm = "ES256"
+
+// Header is the JOSE header of a token.
+type Header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	JWK       *Key   `json:"jwk,omitempty"`
+}
+
+// Looks returns true if s has the shape of a compact serialised token.
+func Looks(s string) bool {
+	return strings.Count(s, ".") == 2
+}
+
+// Sign serialises claims and signs them with key, returning a compact token.
+func Sign(key *ecdsa.PrivateKey, header Header, claims interface{}) (string, error) {
+	header.Algorithm = algorithm
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(headerJSON) + "." + encode(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	size := curveSize(key.Curve)
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+
+	return signingInput + "." + encode(signature), nil
+}
+
+// Parse verifies the signature of token using the key returned by keyFunc for
+// the token's header, then decodes its claims. It does not check any claims.
+func Parse(token string, keyFunc func(Header) (*ecdsa.PublicKey, error), claims interface{}) (Header, error) {
+	var header Header
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, ErrMalformed
+	}
+
+	headerJSON, err := decode(parts[0])
+	if err != nil {
+		return header, ErrMalformed
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return header, ErrMalformed
+	}
+	if header.Algorithm != algorithm {
+		return header, ErrUnsupportedAlgorithm
+	}
+
+	key, err := keyFunc(header)
+	if err != nil {
+		return header, err
+	}
+
+	signature, err := decode(parts[2])
+	if err != nil {
+		return header, ErrMalformed
+	}
+
+	size := curveSize(key.Curve)
+	if len(signature) != 2*size {
+		return header, ErrInvalidSignature
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		return header, ErrInvalidSignature
+	}
+
+	claimsJSON, err := decode(parts[1])
+	if err != nil {
+		return header, ErrMalformed
+	}
+	if err := json.Unmarshal(claimsJSON, claims); err != nil {
+		return header, ErrMalformed
+	}
+
+	return header, nil
+}
+
+func curveSize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+// Hash returns the base64url encoded SHA-256 hash of s.
+func Hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return encode(sum[:])
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,59 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+type testClaims struct {
+	Subject string `json:"sub"`
+}
+
+func TestSignAndParse(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	token, err := Sign(key, Header{Type: "at+jwt", KeyID: "1"}, testClaims{Subject: "me"})
+	assert(err).Must.Nil()
+	assert(Looks(token)).True()
+
+	var claims testClaims
+	header, err := Parse(token, func(h Header) (*ecdsa.PublicKey, error) {
+		return &key.PublicKey, nil
+	}, &claims)
+	assert(err).Nil()
+	assert(header).Equal(Header{Algorithm: "ES256", Type: "at+jwt", KeyID: "1"})
+	assert(claims.Subject).Equal("me")
+}
+
+func TestParseWithWrongKey(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	token, err := Sign(key, Header{}, testClaims{Subject: "me"})
+	assert(err).Must.Nil()
+
+	var claims testClaims
+	_, err = Parse(token, func(h Header) (*ecdsa.PublicKey, error) {
+		return &other.PublicKey, nil
+	}, &claims)
+	assert(err).Equal(ErrInvalidSignature)
+}
+
+func TestParseMalformed(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var claims testClaims
+	_, err := Parse("what", nil, &claims)
+	assert(err).Equal(ErrMalformed)
+
+	_, err = Parse("eyJhbGciOiJub25lIn0.e30.", nil, &claims)
+	assert(err).Equal(ErrUnsupportedAlgorithm)
+}
@@ -14,6 +14,7 @@ var ErrNoApp = errors.New("no h-x-app or h-app could be found")
 type App struct {
 	Name         string
 	URL          string
+	Logo         string
 	RedirectURIs []string
 }
 
@@ -40,6 +41,16 @@ func ParseApp(r io.Reader, baseURL *url.URL) (app App, err error) {
 					app.URL = url
 				}
 			}
+
+			if len(item.Properties["logo"]) == 1 {
+				switch logo := item.Properties["logo"][0].(type) {
+				case string:
+					app.Logo = logo
+				case map[string]string:
+					// an img with alt text
+					app.Logo = logo["value"]
+				}
+			}
 		}
 	}
 
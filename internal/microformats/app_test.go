@@ -12,6 +12,7 @@ type testCase struct {
 	HTML         string
 	ExpectedName string
 	ExpectedURL  string
+	ExpectedLogo string
 }
 
 func TestParseApp(t *testing.T) {
@@ -65,6 +66,16 @@ func TestParseApp(t *testing.T) {
 			ExpectedName: "my app",
 			ExpectedURL:  "",
 		},
+		{
+			Name: "logo",
+			HTML: `<div class="h-app">
+  <img class="u-logo" src="http://host/logo.png" />
+  <a class="p-name u-url" href="http://host">my app</a>
+</div>`,
+			ExpectedName: "my app",
+			ExpectedURL:  "http://host",
+			ExpectedLogo: "http://host/logo.png",
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -75,6 +86,7 @@ func TestParseApp(t *testing.T) {
 			assert.Nil(t, err)
 			assert.Equal(t, testCase.ExpectedName, app.Name)
 			assert.Equal(t, testCase.ExpectedURL, app.URL)
+			assert.Equal(t, testCase.ExpectedLogo, app.Logo)
 		})
 	}
 }
@@ -0,0 +1,46 @@
+package microformats
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ErrNoEndpoint is used to signal when a page does not advertise the requested
+// endpoint.
+var ErrNoEndpoint = errors.New("no endpoint could be found")
+
+// TicketEndpoint takes a profile URL and returns the ticket_endpoint it
+// advertises, either in a Link header or a <link rel="ticket_endpoint"/>
+// element.
+func (me *RelMe) TicketEndpoint(profile string) (string, error) {
+	return me.endpoint(profile, "ticket_endpoint")
+}
+
+func (me *RelMe) endpoint(profile, rel string) (string, error) {
+	req, err := http.NewRequest("GET", profile, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := me.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	links := parseLinkHeader(resp.Header.Values("Link"), resp.Request.URL)
+	if root, err := html.Parse(resp.Body); err == nil {
+		links = append(links, parseRelLinks(root, resp.Request.URL)...)
+	}
+
+	for _, link := range links {
+		if link.has(rel) && (strings.HasPrefix(link.URL, "http://") || strings.HasPrefix(link.URL, "https://")) {
+			return link.URL, nil
+		}
+	}
+
+	return "", ErrNoEndpoint
+}
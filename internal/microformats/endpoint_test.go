@@ -0,0 +1,49 @@
+package microformats
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+func TestTicketEndpoint(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testAPage(nil, []A{{Rel: "ticket_endpoint", Href: "/ticket"}}))
+	}))
+	defer s.Close()
+
+	endpoint, err := client.TicketEndpoint(s.URL)
+	assert(err).Nil()
+	assert(endpoint).Equal(s.URL + "/ticket")
+}
+
+func TestTicketEndpointInLinkHeader(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `<https://tickets.example.com/>; rel="ticket_endpoint"`)
+		fmt.Fprint(w, testAPage(nil, []A{{Rel: "ticket_endpoint", Href: "/ticket"}}))
+	}))
+	defer s.Close()
+
+	endpoint, err := client.TicketEndpoint(s.URL)
+	assert(err).Nil()
+	assert(endpoint).Equal("https://tickets.example.com/")
+}
+
+func TestTicketEndpointWhenMissing(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testAPage([]A{{Rel: "me", Href: "https://example.com"}}, nil))
+	}))
+	defer s.Close()
+
+	_, err := client.TicketEndpoint(s.URL)
+	assert(err).Equal(ErrNoEndpoint)
+}
@@ -0,0 +1,85 @@
+package microformats
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"willnorris.com/go/microformats"
+)
+
+// parseHCard finds the representative h-card of the page, and returns the
+// values of its "url" and "uid" properties that are not the page itself. See
+// http://microformats.org/wiki/representative-h-card-parsing.
+func parseHCard(body []byte, pageURL *url.URL) (links []string) {
+	data := microformats.Parse(bytes.NewReader(body), pageURL)
+
+	card := representativeHCard(data, pageURL.String())
+	if card == nil {
+		return
+	}
+
+	for _, value := range append(stringValues(card, "url"), stringValues(card, "uid")...) {
+		if href, ok := resolve(pageURL, value); ok && !sameURL(href, pageURL.String()) {
+			links = append(links, href)
+		}
+	}
+
+	return
+}
+
+func representativeHCard(data *microformats.Data, pageURL string) *microformats.Microformat {
+	var cards []*microformats.Microformat
+	for _, item := range data.Items {
+		for _, t := range item.Type {
+			if t == "h-card" {
+				cards = append(cards, item)
+				break
+			}
+		}
+	}
+
+	for _, card := range cards {
+		if containsURL(stringValues(card, "uid"), pageURL) && containsURL(stringValues(card, "url"), pageURL) {
+			return card
+		}
+	}
+
+	for _, card := range cards {
+		for _, u := range stringValues(card, "url") {
+			if containsURL(data.Rels["me"], u) {
+				return card
+			}
+		}
+	}
+
+	if len(cards) == 1 && containsURL(stringValues(cards[0], "url"), pageURL) {
+		return cards[0]
+	}
+
+	return nil
+}
+
+func stringValues(item *microformats.Microformat, property string) (values []string) {
+	for _, value := range item.Properties[property] {
+		if s, ok := value.(string); ok {
+			values = append(values, s)
+		}
+	}
+
+	return
+}
+
+func containsURL(list []string, u string) bool {
+	for _, item := range list {
+		if sameURL(item, u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sameURL(a, b string) bool {
+	return strings.TrimRight(a, "/") == strings.TrimRight(b, "/")
+}
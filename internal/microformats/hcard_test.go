@@ -0,0 +1,50 @@
+package microformats
+
+import (
+	"testing"
+
+	"hawx.me/code/assert"
+	"willnorris.com/go/microformats"
+)
+
+func hCard(urls, uids []string) *microformats.Microformat {
+	card := &microformats.Microformat{
+		Type:       []string{"h-card"},
+		Properties: map[string][]interface{}{},
+	}
+	for _, u := range urls {
+		card.Properties["url"] = append(card.Properties["url"], u)
+	}
+	for _, u := range uids {
+		card.Properties["uid"] = append(card.Properties["uid"], u)
+	}
+	return card
+}
+
+func TestRepresentativeHCard(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	const page = "https://example.com/"
+
+	uidAndURL := hCard([]string{"https://example.com", "https://github.com/me"}, []string{"https://example.com/"})
+	relMe := hCard([]string{"https://twitter.com/me"}, nil)
+	only := hCard([]string{"https://example.com/"}, nil)
+	other := hCard([]string{"https://someone.example.org/"}, nil)
+
+	assert(representativeHCard(&microformats.Data{
+		Items: []*microformats.Microformat{other, uidAndURL},
+	}, page)).Equal(uidAndURL)
+
+	assert(representativeHCard(&microformats.Data{
+		Items: []*microformats.Microformat{other, relMe},
+		Rels:  map[string][]string{"me": {"https://twitter.com/me"}},
+	}, page)).Equal(relMe)
+
+	assert(representativeHCard(&microformats.Data{
+		Items: []*microformats.Microformat{only},
+	}, page)).Equal(only)
+
+	assert(representativeHCard(&microformats.Data{
+		Items: []*microformats.Microformat{only, other},
+	}, page) == nil).True()
+}
@@ -0,0 +1,123 @@
+package microformats
+
+import (
+	"encoding/json"
+	"mime"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// isJSON returns true if the Content-Type is for a JSON document, such as
+// JSON-LD or an ActivityPub actor.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// parseJSONProfile returns the links in a JSON-LD document, from "sameAs"
+// properties, or from the "url" and "attachment" properties of an ActivityPub
+// actor. Attachments are usually PropertyValues containing a HTML link.
+func parseJSONProfile(body []byte, pageURL *url.URL) (links []string) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return
+	}
+
+	for _, value := range jsonLinks(doc) {
+		if href, ok := resolve(pageURL, value); ok && !sameURL(href, pageURL.String()) {
+			links = append(links, href)
+		}
+	}
+
+	return
+}
+
+func jsonLinks(doc interface{}) (links []string) {
+	switch v := doc.(type) {
+	case []interface{}:
+		for _, item := range v {
+			links = append(links, jsonLinks(item)...)
+		}
+
+	case map[string]interface{}:
+		links = append(links, jsonStrings(v["sameAs"])...)
+		links = append(links, jsonStrings(v["url"])...)
+
+		if attachments, ok := v["attachment"].([]interface{}); ok {
+			for _, attachment := range attachments {
+				if a, ok := attachment.(map[string]interface{}); ok {
+					if value, ok := a["value"].(string); ok {
+						links = append(links, htmlHrefs(value)...)
+					}
+				}
+			}
+		}
+
+		links = append(links, jsonLinks(v["@graph"])...)
+	}
+
+	return
+}
+
+// jsonStrings reads a property that may be a string, a Link object with an
+// "href", or a list of either.
+func jsonStrings(value interface{}) (values []string) {
+	switch v := value.(type) {
+	case string:
+		values = append(values, v)
+	case map[string]interface{}:
+		if href, ok := v["href"].(string); ok {
+			values = append(values, href)
+		}
+	case []interface{}:
+		for _, item := range v {
+			values = append(values, jsonStrings(item)...)
+		}
+	}
+
+	return
+}
+
+// htmlHrefs returns the hrefs of links in a fragment of HTML, or the value
+// itself if it is a plain URL.
+func htmlHrefs(fragment string) (hrefs []string) {
+	if strings.HasPrefix(fragment, "http://") || strings.HasPrefix(fragment, "https://") {
+		return []string{fragment}
+	}
+
+	root, err := html.Parse(strings.NewReader(fragment))
+	if err != nil {
+		return
+	}
+
+	for _, node := range searchAll(root, func(node *html.Node) bool {
+		return node.Type == html.ElementNode && node.Data == "a"
+	}) {
+		if href := getAttr(node, "href"); href != "" {
+			hrefs = append(hrefs, href)
+		}
+	}
+
+	return
+}
+
+// parseJSONLDScripts returns the links in any JSON-LD embedded in a HTML page.
+func parseJSONLDScripts(root *html.Node, pageURL *url.URL) (links []string) {
+	scripts := searchAll(root, func(node *html.Node) bool {
+		return node.Type == html.ElementNode && node.Data == "script" && getAttr(node, "type") == "application/ld+json"
+	})
+
+	for _, script := range scripts {
+		if script.FirstChild != nil {
+			links = append(links, parseJSONProfile([]byte(script.FirstChild.Data), pageURL)...)
+		}
+	}
+
+	return
+}
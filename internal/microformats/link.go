@@ -0,0 +1,149 @@
+package microformats
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// relLink is a link found on a profile, along with the rel values given to it.
+type relLink struct {
+	URL  string
+	Rels []string
+}
+
+func (l relLink) has(rel string) bool {
+	for _, r := range l.Rels {
+		if r == rel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseLinkHeader reads the links from the values of any Link headers, for
+// example:
+//
+//	Link: <https://github.com/me>; rel="me authn", <https://example.com/key>; rel=pgpkey
+func parseLinkHeader(values []string, base *url.URL) (links []relLink) {
+	for _, value := range values {
+		for _, part := range splitOutsideQuotes(value, ',') {
+			params := splitOutsideQuotes(part, ';')
+			if len(params) < 2 {
+				continue
+			}
+
+			target := strings.TrimSpace(params[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+
+			href, ok := resolve(base, target[1:len(target)-1])
+			if !ok {
+				continue
+			}
+
+			for _, param := range params[1:] {
+				kv := strings.SplitN(param, "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(strings.TrimSpace(kv[0]), "rel") {
+					continue
+				}
+
+				rels := strings.Fields(strings.Trim(strings.TrimSpace(kv[1]), `"`))
+				links = append(links, relLink{URL: href, Rels: rels})
+				break
+			}
+		}
+	}
+
+	return
+}
+
+func splitOutsideQuotes(s string, sep rune) (parts []string) {
+	var inQuotes, inBrackets bool
+	start := 0
+
+	for i, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == '<' && !inQuotes:
+			inBrackets = true
+		case r == '>' && !inQuotes:
+			inBrackets = false
+		case r == sep && !inQuotes && !inBrackets:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// parseRelLinks finds all <a> and <link> elements with a rel attribute.
+func parseRelLinks(root *html.Node, base *url.URL) (links []relLink) {
+	nodes := searchAll(root, func(node *html.Node) bool {
+		return node.Type == html.ElementNode && (node.Data == "a" || node.Data == "link") && getAttr(node, "rel") != ""
+	})
+
+	for _, node := range nodes {
+		if href, ok := resolve(base, getAttr(node, "href")); ok {
+			links = append(links, relLink{URL: href, Rels: strings.Fields(getAttr(node, "rel"))})
+		}
+	}
+
+	return
+}
+
+// resolve returns href as an absolute URL.
+func resolve(base *url.URL, href string) (string, bool) {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return "", false
+	}
+
+	u, err := base.Parse(href)
+	if err != nil {
+		return "", false
+	}
+
+	return u.String(), true
+}
+
+// dedupe removes repeated links, keeping the first of each.
+func dedupe(links []string) (result []string) {
+	seen := map[string]struct{}{}
+
+	for _, link := range links {
+		if _, ok := seen[link]; ok {
+			continue
+		}
+		seen[link] = struct{}{}
+		result = append(result, link)
+	}
+
+	return
+}
+
+// dedupeLinks removes repeated links, keeping the first of each but with the
+// rel values of all.
+func dedupeLinks(links []relLink) (result []relLink) {
+	seen := map[string]int{}
+
+	for _, link := range links {
+		if i, ok := seen[link.URL]; ok {
+			for _, rel := range link.Rels {
+				if !result[i].has(rel) {
+					result[i].Rels = append(result[i].Rels, rel)
+				}
+			}
+			continue
+		}
+		seen[link.URL] = len(result)
+		result = append(result, relLink{URL: link.URL, Rels: append([]string(nil), link.Rels...)})
+	}
+
+	return
+}
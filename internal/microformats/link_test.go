@@ -0,0 +1,33 @@
+package microformats
+
+import (
+	"net/url"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+func TestParseLinkHeader(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	base, _ := url.Parse("https://example.com/profile")
+
+	links := parseLinkHeader([]string{
+		`<https://github.com/me>; rel="me authn", </key>; rel=pgpkey`,
+		`<https://example.com/a,b>; title="x; y"; rel="me"`,
+		`not a link`,
+	}, base)
+
+	if assert(links).Len(3) {
+		assert(links[0].URL).Equal("https://github.com/me")
+		assert(links[0].has("me")).True()
+		assert(links[0].has("authn")).True()
+
+		assert(links[1].URL).Equal("https://example.com/key")
+		assert(links[1].has("pgpkey")).True()
+		assert(links[1].has("me")).False()
+
+		assert(links[2].URL).Equal("https://example.com/a,b")
+		assert(links[2].has("me")).True()
+	}
+}
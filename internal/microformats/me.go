@@ -1,10 +1,15 @@
 package microformats
 
 import (
+	"bytes"
+	"context"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/net/html"
 	"hawx.me/code/relme-auth/internal/strategy"
@@ -29,6 +34,11 @@ const (
 	Unverified
 	// PGP means a pgpkey has been found that can be used for authentication.
 	PGP
+	// Unsupported means a link has been found that no strategy can use.
+	Unsupported
+	// Redirects means the redirects from the profile have been followed, these
+	// are the URLs that links on other profiles are compared to.
+	Redirects
 )
 
 // Event is emitted by Me as new links are found and verified.
@@ -36,79 +46,287 @@ type Event struct {
 	Type EventType
 	Link string
 	Err  error
+
+	// Rels are the rel values given to the link, for Found and Unsupported
+	// events. They are empty for links found in a h-card or JSON profile.
+	Rels []string
+
+	// Redirects are the URLs visited when following Link, for a Redirects event.
+	Redirects []string
+
+	// Checked are the links found on the remote profile, along with the URLs
+	// visited when following each, for Verified, Unverified and Error events.
+	Checked []Followed
+
+	// Backlink is the link on the remote profile that points back to the
+	// profile, for a Verified event.
+	Backlink string
+}
+
+// Followed is a link, and the URLs visited when following its redirects.
+type Followed struct {
+	Link      string
+	Redirects []string
 }
 
 // Me requests profile, then finds all links that can be used to authenticate
-// the user.
-func (client *RelMe) Me(profile string, strategies matching) <-chan Event {
+// the user. Links are verified concurrently so the Verified, Unverified and
+// Error events are emitted in the order verification completes.
+//
+// When ctx is cancelled any requests in progress are stopped, no more events
+// are emitted and the channel is closed.
+func (client *RelMe) Me(ctx context.Context, profile string, strategies matching) <-chan Event {
 	eventCh := make(chan Event)
 
 	go func() {
-		profileLinks, pgpkey, err := client.FindAuth(profile)
+		defer close(eventCh)
+
+		profileLinks, pgpkey, err := client.findAuth(ctx, profile)
 		if err != nil {
-			eventCh <- Event{Type: Error, Err: err}
-			close(eventCh)
+			sendEvent(ctx, eventCh, Event{Type: Error, Err: err})
 			return
 		}
 
-		if pgpkey != "" {
-			eventCh <- Event{Type: PGP, Link: pgpkey}
+		if pgpkey != "" && !sendEvent(ctx, eventCh, Event{Type: PGP, Link: pgpkey}) {
+			return
 		}
 
 		var allowedLinks []string
 		for _, link := range profileLinks {
-			if _, ok := strategies.IsAllowed(link); ok {
-				eventCh <- Event{Type: Found, Link: link}
-				allowedLinks = append(allowedLinks, link)
+			eventType := Unsupported
+			if _, ok := strategies.IsAllowed(link.URL); ok {
+				eventType = Found
+				allowedLinks = append(allowedLinks, link.URL)
+			}
+
+			if !sendEvent(ctx, eventCh, Event{Type: eventType, Link: link.URL, Rels: link.Rels}) {
+				return
 			}
 		}
 
-		for _, link := range allowedLinks {
-			ok, err := client.LinksTo(link, profile)
+		if len(allowedLinks) == 0 {
+			return
+		}
 
-			if err != nil {
-				eventCh <- Event{Type: Error, Link: link, Err: err}
-			} else if ok {
-				eventCh <- Event{Type: Verified, Link: link}
-			} else {
-				eventCh <- Event{Type: Unverified, Link: link}
-			}
+		profileURL, err := url.Parse(profile)
+		if err != nil {
+			sendEvent(ctx, eventCh, Event{Type: Error, Err: err})
+			return
+		}
+
+		// the redirects for profile are the same for every link, so only find them
+		// once
+		profileRedirects, _ := client.follow(ctx, profileURL)
+		if !sendEvent(ctx, eventCh, Event{Type: Redirects, Link: profile, Redirects: profileRedirects}) {
+			return
 		}
 
-		close(eventCh)
+		client.verifyAll(ctx, allowedLinks, profileRedirects, eventCh)
 	}()
 
 	return eventCh
 }
 
+// sendEvent sends event on eventCh, unless ctx is cancelled first in which
+// case false is returned.
+func sendEvent(ctx context.Context, eventCh chan<- Event, event Event) bool {
+	select {
+	case eventCh <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+const (
+	defaultConcurrency = 4
+	defaultLinkTimeout = 5 * time.Second
+)
+
 type RelMe struct {
 	Client           *http.Client
 	NoRedirectClient *http.Client
+
+	// Concurrency is the number of links that will be verified at once, if not
+	// set defaultConcurrency is used.
+	Concurrency int
+
+	// LinkTimeout is how long verifying a single link may take, if not set
+	// defaultLinkTimeout is used.
+	LinkTimeout time.Duration
+}
+
+// verifyAll checks whether each of links links back to a URL in
+// profileRedirects, using a pool of workers, and emits an event for each link
+// as soon as it is known.
+func (client *RelMe) verifyAll(ctx context.Context, links, profileRedirects []string, eventCh chan<- Event) {
+	workers := client.Concurrency
+	if workers <= 0 {
+		workers = defaultConcurrency
+	}
+	if workers > len(links) {
+		workers = len(links)
+	}
+
+	linkCh := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for link := range linkCh {
+				sendEvent(ctx, eventCh, client.verify(ctx, link, profileRedirects))
+			}
+		}()
+	}
+
+feed:
+	for _, link := range links {
+		select {
+		case linkCh <- link:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(linkCh)
+
+	wg.Wait()
+}
+
+func (client *RelMe) verify(ctx context.Context, link string, profileRedirects []string) Event {
+	timeout := client.LinkTimeout
+	if timeout <= 0 {
+		timeout = defaultLinkTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backlink, checked, err := client.linksTo(ctx, link, profileRedirects)
+	if err != nil {
+		return Event{Type: Error, Link: link, Err: err, Checked: checked}
+	}
+	if backlink != "" {
+		return Event{Type: Verified, Link: link, Checked: checked, Backlink: backlink}
+	}
+	return Event{Type: Unverified, Link: link, Checked: checked}
 }
 
 // FindAuth takes a profile URL and returns a list of all hrefs in <a rel="me
 // authn"/> elements on the page that also link back to the profile, if none
 // exist it fallsback to using hrefs in <a rel="me"/> elements as FindVerified
-// does.
-func (me *RelMe) FindAuth(profile string) (links []string, pgpkey string, err error) {
-	req, err := http.NewRequest("GET", profile, nil)
+// does. Redirects are followed as described for Canonical.
+func (me *RelMe) FindAuth(ctx context.Context, profile string) (links []string, pgpkey string, err error) {
+	relLinks, pgpkey, err := me.findAuth(ctx, profile)
+
+	for _, link := range relLinks {
+		links = append(links, link.URL)
+	}
+
+	return
+}
+
+func (me *RelMe) findAuth(ctx context.Context, profile string) (links []relLink, pgpkey string, err error) {
+	_, resp, err := me.fetchProfile(ctx, profile)
 	if err != nil {
 		return
 	}
+	defer resp.Body.Close()
 
-	resp, err := me.Client.Do(req)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return
 	}
-	defer resp.Body.Close()
 
-	return parseProfileLinks(profile, resp.Body)
+	return parseProfileLinks(resp.Request.URL, resp.Header, body)
+}
+
+// Canonical takes a profile URL and returns the URL that should be used to
+// identify the user. Redirects are followed, but the URL is only replaced when
+// the redirect is permanent and stays on the same domain, so a temporary
+// redirect, or a redirect to another site, will not change who the user is.
+func (me *RelMe) Canonical(ctx context.Context, profile string) (string, error) {
+	canonical, resp, err := me.fetchProfile(ctx, profile)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	return canonical, nil
+}
+
+const (
+	maxRedirects = 10
+
+	// profileAccept prefers HTML, but allows sites that only have a JSON
+	// profile, such as an ActivityPub actor, to be read.
+	profileAccept = "text/html, application/xhtml+xml, application/activity+json;q=0.9, application/ld+json;q=0.9, application/json;q=0.8"
+)
+
+func (me *RelMe) fetchProfile(ctx context.Context, profile string) (canonical string, resp *http.Response, err error) {
+	current, err := url.Parse(profile)
+	if err != nil {
+		return
+	}
+
+	canonical = profile
+	permanent := true
+
+	for i := 0; ; i++ {
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, "GET", current.String(), nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("Accept", profileAccept)
+
+		resp, err = me.NoRedirectClient.Do(req)
+		if err != nil {
+			return
+		}
+
+		location := resp.Header.Get("Location")
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 || location == "" || i == maxRedirects {
+			return
+		}
+		resp.Body.Close()
+
+		next, err := current.Parse(location)
+		if err != nil {
+			return canonical, nil, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+			if permanent && sameDomain(current, next) {
+				canonical = next.String()
+			} else {
+				permanent = false
+			}
+		default:
+			permanent = false
+		}
+
+		current = next
+	}
+}
+
+// sameDomain returns true if a and b have the same host, or differ only by a
+// "www." prefix, for example "example.com" and "www.example.com". Other
+// subdomains are not the same, as they may belong to different people.
+func sameDomain(a, b *url.URL) bool {
+	aHost := strings.TrimPrefix(strings.ToLower(a.Hostname()), "www.")
+	bHost := strings.TrimPrefix(strings.ToLower(b.Hostname()), "www.")
+
+	return aHost == bHost
 }
 
 // Find takes a profile URL and returns a list of all hrefs in <a rel="me"/>
 // elements on the page.
-func (me *RelMe) Find(profile string) (links []string, err error) {
-	req, err := http.NewRequest("GET", profile, nil)
+func (me *RelMe) Find(ctx context.Context, profile string) (links []string, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", profile, nil)
 	if err != nil {
 		return
 	}
@@ -119,23 +337,31 @@ func (me *RelMe) Find(profile string) (links []string, err error) {
 	}
 	defer resp.Body.Close()
 
-	return parseLinks(resp.Body)
+	return parseLinks(resp.Request.URL, resp.Header, resp.Body)
 }
 
 // LinksTo takes a remote profile URL and checks whether any of the hrefs in <a
 // rel="me"/> elements match the test URL.
-func (me *RelMe) LinksTo(remote, test string) (ok bool, err error) {
+func (me *RelMe) LinksTo(ctx context.Context, remote, test string) (ok bool, err error) {
 	testURL, err := url.Parse(test)
 	if err != nil {
 		return
 	}
 
-	testRedirects, err := me.follow(testURL)
+	testRedirects, err := me.follow(ctx, testURL)
 	if err != nil {
 		return
 	}
 
-	links, err := me.Find(remote)
+	backlink, _, err := me.linksTo(ctx, remote, testRedirects)
+	return backlink != "", err
+}
+
+// linksTo checks whether any of the hrefs in <a rel="me"/> elements on the
+// remote profile redirect to a URL in testRedirects, returning the href that
+// does. Each href checked is returned with the redirects followed from it.
+func (me *RelMe) linksTo(ctx context.Context, remote string, testRedirects []string) (backlink string, checked []Followed, err error) {
+	links, err := me.Find(ctx, remote)
 	if err != nil {
 		return
 	}
@@ -146,34 +372,41 @@ func (me *RelMe) LinksTo(remote, test string) (ok bool, err error) {
 			continue
 		}
 
-		linkRedirects, err := me.follow(linkURL)
+		linkRedirects, err := me.follow(ctx, linkURL)
 		if err != nil {
 			continue
 		}
+		checked = append(checked, Followed{Link: link, Redirects: linkRedirects})
 
 		if compare(linkRedirects, testRedirects) {
-			return true, nil
+			return link, checked, nil
 		}
 	}
 
-	return false, nil
+	return "", checked, ctx.Err()
 }
 
-func normalizeInPlace(urls []string) {
+func normalize(urls []string) []string {
+	normalized := make([]string, len(urls))
+
 	for i, a := range urls {
+		normalized[i] = a
+
 		aURL, err := url.Parse(a)
 		if err != nil {
 			continue
 		}
 		aURL.Scheme = "https"
 
-		urls[i] = strings.TrimRight(aURL.String(), "/")
+		normalized[i] = strings.TrimRight(aURL.String(), "/")
 	}
+
+	return normalized
 }
 
 func compare(as, bs []string) bool {
-	normalizeInPlace(as)
-	normalizeInPlace(bs)
+	as = normalize(as)
+	bs = normalize(bs)
 
 	for _, a := range as {
 		for _, b := range bs {
@@ -186,14 +419,14 @@ func compare(as, bs []string) bool {
 	return false
 }
 
-func (me *RelMe) follow(remote *url.URL) (redirects []string, err error) {
+func (me *RelMe) follow(ctx context.Context, remote *url.URL) (redirects []string, err error) {
 	previous := map[string]struct{}{}
 	current := remote
 
-	for {
+	for i := 0; i <= maxRedirects; i++ {
 		redirects = append(redirects, current.String())
 
-		req, err := http.NewRequest("GET", current.String(), nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", current.String(), nil)
 		if err != nil {
 			break
 		}
@@ -222,130 +455,72 @@ func (me *RelMe) follow(remote *url.URL) (redirects []string, err error) {
 	return
 }
 
-func parseProfileLinks(profile string, r io.Reader) (links []string, pgpkey string, err error) {
-	root, err := html.Parse(r)
-	if err != nil {
-		return
-	}
-
-	pgpkey, keyWasAuthn := findPGPKey(profile, root)
-	if keyWasAuthn {
-		// only find authn me links
-		rels := searchAll(root, isRelAuthn)
-		for _, node := range rels {
-			links = append(links, getAttr(node, "href"))
+// parseProfileLinks returns the links that can be used to authenticate the
+// user. Links are read from Link headers, and either the elements, h-card and
+// JSON-LD of a HTML page, or the properties of a JSON profile. If any rel="me
+// authn" links exist only they are returned, otherwise all rel="me" links are.
+func parseProfileLinks(pageURL *url.URL, header http.Header, body []byte) (links []relLink, pgpkey string, err error) {
+	rels := parseLinkHeader(header.Values("Link"), pageURL)
+
+	var others []string
+	if isJSON(header.Get("Content-Type")) {
+		others = parseJSONProfile(body, pageURL)
+	} else {
+		root, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			return nil, "", err
 		}
-		return
-	}
-
-	rels := searchAll(root, isRelAuthn)
-	for _, node := range rels {
-		links = append(links, getAttr(node, "href"))
-	}
-	// don't return the key as it wasn't authn
-	if len(links) > 0 {
-		return links, "", nil
-	}
-
-	rels = searchAll(root, isRelMe)
-	for _, node := range rels {
-		links = append(links, getAttr(node, "href"))
-	}
-	return
-}
 
-func parseLinks(r io.Reader) (links []string, err error) {
-	root, err := html.Parse(r)
-	if err != nil {
-		return
+		rels = append(rels, parseRelLinks(root, pageURL)...)
+		others = append(parseHCard(body, pageURL), parseJSONLDScripts(root, pageURL)...)
 	}
 
-	rels := searchAll(root, isRelMe)
-	for _, node := range rels {
-		links = append(links, getAttr(node, "href"))
-	}
-	if len(links) > 0 {
-		return
-	}
+	var authnLinks, meLinks []relLink
+	var keyWasAuthn bool
 
-	return
-}
+	for _, link := range rels {
+		if link.has("pgpkey") && (pgpkey == "" || (link.has("authn") && !keyWasAuthn)) {
+			pgpkey = link.URL
+			keyWasAuthn = link.has("authn")
+		}
 
-func findPGPKey(profile string, root *html.Node) (key string, wasAuthn bool) {
-	searchAll(root, func(node *html.Node) bool {
-		if node.Type == html.ElementNode && (node.Data == "a" || node.Data == "link") {
-			var hasKey, hasAuthn bool
-
-			rels := strings.Fields(getAttr(node, "rel"))
-			for _, rel := range rels {
-				if rel == "pgpkey" {
-					hasKey = true
-				}
-				if rel == "authn" {
-					hasAuthn = true
-				}
-				if hasKey && hasAuthn {
-					key = getAttr(node, "href")
-					wasAuthn = true
-					return true
-				}
-			}
+		if link.has("me") {
+			meLinks = append(meLinks, link)
 
-			if hasKey {
-				key = getAttr(node, "href")
-				wasAuthn = hasAuthn
-				return true
+			if link.has("authn") {
+				authnLinks = append(authnLinks, link)
 			}
 		}
+	}
 
-		return false
-	})
-
-	if key != "" {
-		profileURL, err := url.Parse(profile)
-		if err != nil {
-			return "", false
-		}
-		abspgpkey, err := profileURL.Parse(key)
-		if err != nil {
-			return "", false
-		}
-		key = abspgpkey.String()
+	if keyWasAuthn {
+		return dedupeLinks(authnLinks), pgpkey, nil
 	}
 
-	return
-}
+	// don't return the key as it wasn't authn
+	if len(authnLinks) > 0 {
+		return dedupeLinks(authnLinks), "", nil
+	}
 
-func isRelMe(node *html.Node) bool {
-	if node.Type == html.ElementNode && (node.Data == "a" || node.Data == "link") {
-		rels := strings.Fields(getAttr(node, "rel"))
-		for _, rel := range rels {
-			if rel == "me" {
-				return true
-			}
-		}
+	for _, other := range others {
+		meLinks = append(meLinks, relLink{URL: other})
 	}
 
-	return false
+	return dedupeLinks(meLinks), pgpkey, nil
 }
 
-func isRelAuthn(node *html.Node) bool {
-	var me, authn bool
+// parseLinks returns the rel="me" links from Link headers and a HTML page.
+func parseLinks(pageURL *url.URL, header http.Header, r io.Reader) (links []string, err error) {
+	root, err := html.Parse(r)
+	if err != nil {
+		return
+	}
 
-	if node.Type == html.ElementNode && (node.Data == "a" || node.Data == "link") {
-		rels := strings.Fields(getAttr(node, "rel"))
-		for _, rel := range rels {
-			if rel == "me" {
-				me = true
-			}
-			if rel == "authn" {
-				authn = true
-			}
-			if me && authn {
-				return true
-			}
+	for _, link := range append(parseLinkHeader(header.Values("Link"), pageURL), parseRelLinks(root, pageURL)...) {
+		if link.has("me") {
+			links = append(links, link.URL)
 		}
 	}
 
-	return false
+	return dedupe(links), nil
 }
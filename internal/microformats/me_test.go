@@ -1,9 +1,11 @@
 package microformats
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -92,7 +94,7 @@ func TestMe(t *testing.T) {
 
 	strategies := matchingStrategy([]string{otherSite.URL, missingSite.URL, someSite.URL, "what://localhost/link"})
 
-	eventsCh := client.Me(meSite.URL, strategies)
+	eventsCh := client.Me(context.Background(), meSite.URL, strategies)
 
 	event, ok, timedOut := getEvent(eventsCh)
 	if assert(timedOut).False() {
@@ -132,33 +134,193 @@ func TestMe(t *testing.T) {
 	event, ok, timedOut = getEvent(eventsCh)
 	if assert(timedOut).False() {
 		assert(ok).True()
-		assert(event.Type).Equal(Verified)
-		assert(event.Link).Equal(someSite.URL)
+		assert(event.Type).Equal(Unsupported)
+		assert(event.Link).Equal("http://localhost/unknown")
+		assert(event.Rels).Equal([]string{"me"})
+	}
+
+	event, ok, timedOut = getEvent(eventsCh)
+	if assert(timedOut).False() {
+		assert(ok).True()
+		assert(event.Type).Equal(Redirects)
+		assert(event.Link).Equal(meSite.URL)
+		assert(event.Redirects).Equal([]string{meSite.URL})
+	}
+
+	// links are verified concurrently, so these can arrive in any order
+	verified := map[string]EventType{}
+	for i := 0; i < 4; i++ {
+		event, ok, timedOut = getEvent(eventsCh)
+		if assert(timedOut).False() && assert(ok).True() {
+			verified[event.Link] = event.Type
+			if event.Type == Error {
+				assert(event.Err).NotNil()
+			}
+		}
+	}
+
+	assert(verified).Equal(map[string]EventType{
+		someSite.URL:            Verified,
+		"what://localhost/link": Error,
+		otherSite.URL:           Verified,
+		missingSite.URL:         Unverified,
+	})
+
+	_, ok, timedOut = getEvent(eventsCh)
+	if assert(timedOut).False() {
+		assert(ok).False()
+	}
+}
+
+func TestMeVerifiesInCompletionOrder(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var meSite, slowSite, fastSite *httptest.Server
+
+	slowSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, testAPage([]A{{Rel: "me", Href: meSite.URL}}, []A{}))
+	}))
+	defer slowSite.Close()
+
+	fastSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testAPage([]A{{Rel: "me", Href: meSite.URL}}, []A{}))
+	}))
+	defer fastSite.Close()
+
+	meSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testAPage([]A{
+			{Rel: "me", Href: slowSite.URL},
+			{Rel: "me", Href: fastSite.URL},
+		}, []A{}))
+	}))
+	defer meSite.Close()
+
+	eventsCh := client.Me(context.Background(), meSite.URL, matchingStrategy([]string{slowSite.URL, fastSite.URL}))
+
+	var events []Event
+	for event := range eventsCh {
+		events = append(events, event)
+	}
+
+	if assert(events).Len(5) {
+		assert(events[0].Type).Equal(Found)
+		assert(events[0].Link).Equal(slowSite.URL)
+		assert(events[0].Rels).Equal([]string{"me"})
+		assert(events[1].Type).Equal(Found)
+		assert(events[1].Link).Equal(fastSite.URL)
+		assert(events[2].Type).Equal(Redirects)
+
+		assert(events[3].Type).Equal(Verified)
+		assert(events[3].Link).Equal(fastSite.URL)
+		assert(events[3].Backlink).Equal(meSite.URL)
+		assert(events[3].Checked).Equal([]Followed{{Link: meSite.URL, Redirects: []string{meSite.URL}}})
+
+		assert(events[4].Type).Equal(Verified)
+		assert(events[4].Link).Equal(slowSite.URL)
+	}
+}
+
+func TestMeWithLinkTimeout(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var meSite, slowSite *httptest.Server
+
+	done := make(chan struct{})
+
+	slowSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+		fmt.Fprint(w, testAPage([]A{{Rel: "me", Href: meSite.URL}}, []A{}))
+	}))
+	defer slowSite.Close()
+	defer close(done)
+
+	meSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testAPage([]A{{Rel: "me", Href: slowSite.URL}}, []A{}))
+	}))
+	defer meSite.Close()
+
+	timeoutClient := &RelMe{
+		Client:           client.Client,
+		NoRedirectClient: client.NoRedirectClient,
+		Concurrency:      1,
+		LinkTimeout:      20 * time.Millisecond,
+	}
+
+	eventsCh := timeoutClient.Me(context.Background(), meSite.URL, matchingStrategy([]string{slowSite.URL}))
+
+	event, ok, timedOut := getEvent(eventsCh)
+	if assert(timedOut).False() {
+		assert(ok).True()
+		assert(event.Type).Equal(Found)
+	}
+
+	event, ok, timedOut = getEvent(eventsCh)
+	if assert(timedOut).False() {
+		assert(ok).True()
+		assert(event.Type).Equal(Redirects)
 	}
 
 	event, ok, timedOut = getEvent(eventsCh)
 	if assert(timedOut).False() {
 		assert(ok).True()
 		assert(event.Type).Equal(Error)
-		assert(event.Link).Equal("what://localhost/link")
+		assert(event.Link).Equal(slowSite.URL)
 		assert(event.Err).NotNil()
 	}
+}
 
-	event, ok, timedOut = getEvent(eventsCh)
+func TestMeWhenCancelled(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var meSite, slowSite *httptest.Server
+
+	requested := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	slowSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slowSite.Close()
+	defer close(done)
+
+	meSite = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testAPage([]A{{Rel: "me", Href: slowSite.URL}}, []A{}))
+	}))
+	defer meSite.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	eventsCh := client.Me(ctx, meSite.URL, matchingStrategy([]string{slowSite.URL}))
+
+	event, ok, timedOut := getEvent(eventsCh)
 	if assert(timedOut).False() {
 		assert(ok).True()
-		assert(event.Type).Equal(Verified)
-		assert(event.Link).Equal(otherSite.URL)
+		assert(event.Type).Equal(Found)
 	}
 
 	event, ok, timedOut = getEvent(eventsCh)
 	if assert(timedOut).False() {
 		assert(ok).True()
-		assert(event.Type).Equal(Unverified)
-		assert(event.Link).Equal(missingSite.URL)
+		assert(event.Type).Equal(Redirects)
 	}
 
-	_, ok, timedOut = getEvent(eventsCh)
+	<-requested
+	cancel()
+
+	// an error may be emitted for the link, if it is received before the channel
+	// is closed
+	event, ok, timedOut = getEvent(eventsCh)
+	if ok {
+		assert(event.Type).Equal(Error)
+		_, ok, timedOut = getEvent(eventsCh)
+	}
 	if assert(timedOut).False() {
 		assert(ok).False()
 	}
@@ -187,7 +349,7 @@ func TestFindAuth(t *testing.T) {
 	}))
 	defer me.Close()
 
-	links, pgpkey, err := client.FindAuth(me.URL)
+	links, pgpkey, err := client.FindAuth(context.Background(), me.URL)
 	assert(err).Must.Nil()
 	assert(pgpkey).Equal("")
 
@@ -214,7 +376,7 @@ func TestFindAuthWithPGPKey(t *testing.T) {
 	}))
 	defer me.Close()
 
-	links, pgpkey, err := client.FindAuth(me.URL)
+	links, pgpkey, err := client.FindAuth(context.Background(), me.URL)
 	assert(err).Must.Nil()
 	assert(pgpkey).Equal(me.URL + "/key")
 
@@ -247,7 +409,7 @@ func TestFindAuthWithAuthnPGPKey(t *testing.T) {
 	}))
 	defer me.Close()
 
-	links, pgpkey, err := client.FindAuth(me.URL)
+	links, pgpkey, err := client.FindAuth(context.Background(), me.URL)
 	assert(err).Must.Nil()
 	assert(pgpkey).Equal("http://example.com/key")
 
@@ -280,7 +442,7 @@ func TestFindAuthWithNoneAuthnPGPKey(t *testing.T) {
 	}))
 	defer me.Close()
 
-	links, pgpkey, err := client.FindAuth(me.URL)
+	links, pgpkey, err := client.FindAuth(context.Background(), me.URL)
 	assert(err).Must.Nil()
 	assert(pgpkey).Equal("")
 
@@ -307,7 +469,7 @@ func TestFindAuthWhenNoAuthnRels(t *testing.T) {
 	}))
 	defer me.Close()
 
-	links, _, err := client.FindAuth(me.URL)
+	links, _, err := client.FindAuth(context.Background(), me.URL)
 	assert(err).Must.Nil()
 
 	if assert(links).Len(2) {
@@ -338,7 +500,7 @@ func TestFind(t *testing.T) {
 	}))
 	defer s.Close()
 
-	links, err := client.Find(s.URL)
+	links, err := client.Find(context.Background(), s.URL)
 	assert(err).Must.Nil()
 
 	if assert(links).Len(2) {
@@ -366,17 +528,17 @@ func TestLinksTo(t *testing.T) {
 	}))
 	defer s.Close()
 
-	ok, err := client.LinksTo(s.URL, "https://example.com/a")
+	ok, err := client.LinksTo(context.Background(), s.URL, "https://example.com/a")
 	assert(err).Must.Nil()
 	assert(ok).True()
-	ok, err = client.LinksTo(s.URL, "https://example.com/a/")
+	ok, err = client.LinksTo(context.Background(), s.URL, "https://example.com/a/")
 	assert(err).Must.Nil()
 	assert(ok).True()
-	ok, err = client.LinksTo(s.URL, "http://example.com/a")
+	ok, err = client.LinksTo(context.Background(), s.URL, "http://example.com/a")
 	assert(err).Must.Nil()
 	assert(ok).True()
 
-	ok, err = client.LinksTo(s.URL, "https://example.com/b")
+	ok, err = client.LinksTo(context.Background(), s.URL, "https://example.com/b")
 	assert(err).Must.Nil()
 	assert(ok).False()
 }
@@ -419,12 +581,12 @@ func TestLinksToWithRedirects(t *testing.T) {
 	defer twitter.Close()
 
 	// then we can verify that my homepage links twitter
-	ok, err := client.LinksTo(homepage.URL, twitter.URL)
+	ok, err := client.LinksTo(context.Background(), homepage.URL, twitter.URL)
 	assert(err).Must.Nil()
 	assert(ok).True()
 
 	// and twitter links to my homepage
-	ok, err = client.LinksTo(twitter.URL, homepage.URL)
+	ok, err = client.LinksTo(context.Background(), twitter.URL, homepage.URL)
 	assert(err).Must.Nil()
 	assert(ok).True()
 }
@@ -470,22 +632,152 @@ func TestLinksToWithMoreRedirects(t *testing.T) {
 	defer twitter.Close()
 
 	// then we can verify that my homepage links twitter
-	ok, err := client.LinksTo(homepage.URL, twitter.URL)
+	ok, err := client.LinksTo(context.Background(), homepage.URL, twitter.URL)
 	assert(err).Must.Nil()
 	assert(ok).True()
 
 	// and twitter links to my homepage
-	ok, err = client.LinksTo(twitter.URL, homepage.URL)
+	ok, err = client.LinksTo(context.Background(), twitter.URL, homepage.URL)
 	assert(err).Must.Nil()
 	assert(ok).True()
 
 	// and we can verify that my short homepage links twitter
-	ok, err = client.LinksTo(shortHomepage.URL, twitter.URL)
+	ok, err = client.LinksTo(context.Background(), shortHomepage.URL, twitter.URL)
 	assert(err).Must.Nil()
 	assert(ok).True()
 
 	// and twitter links to my short homepage
-	ok, err = client.LinksTo(twitter.URL, shortHomepage.URL)
+	ok, err = client.LinksTo(context.Background(), twitter.URL, shortHomepage.URL)
 	assert(err).Must.Nil()
 	assert(ok).True()
 }
+
+func TestCanonical(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/older", http.StatusMovedPermanently)
+		case "/older":
+			http.Redirect(w, r, "/new", http.StatusPermanentRedirect)
+		case "/temporary":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/temporary-then-permanent":
+			http.Redirect(w, r, "/old", http.StatusTemporaryRedirect)
+		case "/new":
+			fmt.Fprint(w, testAPage([]A{{Rel: "me authn", Href: "https://github.com/me"}}, nil))
+		}
+	}))
+	defer s.Close()
+
+	testCases := map[string]string{
+		"/old":                      "/new",
+		"/new":                      "/new",
+		"/temporary":                "/temporary",
+		"/temporary-then-permanent": "/temporary-then-permanent",
+	}
+
+	for path, expected := range testCases {
+		canonical, err := client.Canonical(context.Background(), s.URL+path)
+		assert(err).Nil()
+		assert(canonical).Equal(s.URL + expected)
+	}
+
+	links, _, err := client.FindAuth(context.Background(), s.URL+"/temporary")
+	assert(err).Nil()
+	assert(links).Equal([]string{"https://github.com/me"})
+}
+
+func TestSameDomain(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	parse := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+
+	assert(sameDomain(parse("http://example.com"), parse("https://www.example.com/"))).True()
+	assert(sameDomain(parse("https://www.example.com"), parse("https://example.com/"))).True()
+	assert(sameDomain(parse("https://example.com"), parse("https://example.com:8080/"))).True()
+	assert(sameDomain(parse("https://example.com"), parse("https://notexample.com/"))).False()
+	assert(sameDomain(parse("https://example.com"), parse("https://example.org/"))).False()
+	assert(sameDomain(parse("https://alice.host.example"), parse("https://host.example/"))).False()
+	assert(sameDomain(parse("https://host.example"), parse("https://bob.host.example/"))).False()
+	assert(sameDomain(parse("https://www.alice.host.example"), parse("https://alice.host.example/"))).True()
+}
+
+func TestFindAuthWithLinkHeaders(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	me := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `<https://github.com/me>; rel="me"`)
+		w.Header().Add("Link", `<https://flickr.com/me>; rel="me", </key>; rel=pgpkey`)
+		fmt.Fprint(w, testAPage([]A{
+			{Rel: "me", Href: "https://github.com/me"},
+			{Rel: "me", Href: "https://twitter.com/me"},
+		}, []A{}))
+	}))
+	defer me.Close()
+
+	links, pgpkey, err := client.FindAuth(context.Background(), me.URL)
+	assert(err).Must.Nil()
+	assert(pgpkey).Equal(me.URL + "/key")
+	assert(links).Equal([]string{"https://github.com/me", "https://flickr.com/me", "https://twitter.com/me"})
+}
+
+func TestFindAuthWithAuthnLinkHeader(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	me := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `<https://github.com/me>; rel="me authn"`)
+		fmt.Fprint(w, testAPage([]A{{Rel: "me", Href: "https://twitter.com/me"}}, []A{}))
+	}))
+	defer me.Close()
+
+	links, _, err := client.FindAuth(context.Background(), me.URL)
+	assert(err).Must.Nil()
+	assert(links).Equal([]string{"https://github.com/me"})
+}
+
+func TestFindAuthWithJSONLD(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	me := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<!doctype html><html><head>
+<script type="application/ld+json">
+{"@context": "https://schema.org", "@type": "Person", "sameAs": ["https://github.com/me", "https://flickr.com/me"]}
+</script>
+</head><body><a rel="me" href="https://github.com/me">GitHub</a></body></html>`)
+	}))
+	defer me.Close()
+
+	links, _, err := client.FindAuth(context.Background(), me.URL)
+	assert(err).Must.Nil()
+	assert(links).Equal([]string{"https://github.com/me", "https://flickr.com/me"})
+}
+
+func TestFindAuthWithActivityPubActor(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var me *httptest.Server
+	me = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `application/activity+json; charset=utf-8`)
+		fmt.Fprintf(w, `{
+  "@context": ["https://www.w3.org/ns/activitystreams"],
+  "id": "%[1]s",
+  "type": "Person",
+  "url": "%[1]s",
+  "attachment": [
+    {"type": "PropertyValue", "name": "GitHub", "value": "<a href=\"https://github.com/me\" rel=\"me\">github.com/me</a>"},
+    {"type": "PropertyValue", "name": "Flickr", "value": "https://flickr.com/me"},
+    {"type": "PropertyValue", "name": "Pronouns", "value": "they/them"}
+  ]
+}`, me.URL)
+	}))
+	defer me.Close()
+
+	links, _, err := client.FindAuth(context.Background(), me.URL)
+	assert(err).Must.Nil()
+	assert(links).Equal([]string{"https://github.com/me", "https://flickr.com/me"})
+}
@@ -0,0 +1,35 @@
+package random
+
+import "crypto/rand"
+
+// consonants only, so that codes can't spell words and are easy to read aloud
+const userCodeLetters = "BCDFGHJKLMNPQRSTVWXZ"
+
+// maxUnbiased is the largest multiple of len(userCodeLetters) that fits in a
+// byte. Bytes at or above it are discarded, so that every letter is equally
+// likely.
+const maxUnbiased = 256 - 256%len(userCodeLetters)
+
+// UserCode produces a random string of n uppercase characters that is easy for
+// a person to read and type.
+func UserCode(n int) (string, error) {
+	code := make([]byte, 0, n)
+	bytes := make([]byte, n)
+
+	for len(code) < n {
+		if _, err := rand.Read(bytes); err != nil {
+			return "", err
+		}
+		for _, b := range bytes {
+			if int(b) >= maxUnbiased {
+				continue
+			}
+			code = append(code, userCodeLetters[int(b)%len(userCodeLetters)])
+			if len(code) == n {
+				break
+			}
+		}
+	}
+
+	return string(code), nil
+}
@@ -0,0 +1,263 @@
+// Package safehttp provides HTTP clients for fetching URLs that have been given
+// by users. The clients refuse to connect to loopback, link-local, private and
+// other internal addresses, so that they can't be used to reach services that
+// are not public.
+package safehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrBlockedAddress is returned when a request would connect to an address
+	// that is not allowed.
+	ErrBlockedAddress = errors.New("safehttp: address is not allowed")
+
+	// ErrBodyTooLarge is returned when reading more than MaxBodySize bytes from a
+	// response body.
+	ErrBodyTooLarge = errors.New("safehttp: response body too large")
+
+	// ErrTooManyRedirects is returned when a request is redirected
+	// MaxRedirects times.
+	ErrTooManyRedirects = errors.New("safehttp: too many redirects")
+)
+
+// blockedNets are checked after DNS resolution, so a public name can't be used
+// to reach them.
+var blockedNets = parseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local, includes cloud metadata services
+	"172.16.0.0/12",  // private
+	"192.0.0.0/24",   // IETF protocol assignments
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved, includes broadcast
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // IPv4/IPv6 translation
+	"fc00::/7",       // unique local, includes fd00:ec2::254 metadata
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = ipNet
+	}
+	return nets
+}
+
+// Options configures the clients.
+type Options struct {
+	// Allow lists hosts, IP addresses or CIDR ranges that may be connected to
+	// even though they would otherwise be blocked. This is useful for local
+	// development.
+	Allow []string
+
+	// MaxBodySize is the most bytes that can be read from a response body. If
+	// zero there is no limit.
+	MaxBodySize int64
+
+	// MaxRedirects is the most requests that Client will make for a chain of
+	// redirects, counted the same way as the default limit of 10 in net/http.
+	MaxRedirects int
+
+	// Timeout limits the time taken by a request, including reading the body.
+	Timeout time.Duration
+}
+
+// Client returns a http.Client that follows redirects, stopping after
+// MaxRedirects requests.
+func Client(opts Options) (*http.Client, error) {
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= opts.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			return nil
+		},
+		Timeout: opts.Timeout,
+	}, nil
+}
+
+// NoRedirectClient returns a http.Client that returns redirect responses
+// instead of following them.
+func NoRedirectClient(opts Options) (*http.Client, error) {
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: opts.Timeout,
+	}, nil
+}
+
+func newTransport(opts Options) (http.RoundTripper, error) {
+	g, err := newGuard(opts.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	// Proxy is deliberately not set, as the addresses it connects to can't be
+	// checked.
+	var transport http.RoundTripper = &http.Transport{
+		DialContext:           g.dialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if opts.MaxBodySize > 0 {
+		transport = &limitTransport{base: transport, max: opts.MaxBodySize}
+	}
+
+	return transport, nil
+}
+
+type guard struct {
+	hosts map[string]struct{}
+	nets  []*net.IPNet
+}
+
+func newGuard(allow []string) (*guard, error) {
+	g := &guard{hosts: map[string]struct{}{}}
+
+	for _, entry := range allow {
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			g.nets = append(g.nets, ipNet)
+		} else if ip := net.ParseIP(entry); ip != nil {
+			g.nets = append(g.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		} else if entry != "" && !strings.ContainsAny(entry, "/:") {
+			g.hosts[strings.ToLower(entry)] = struct{}{}
+		} else {
+			return nil, fmt.Errorf("safehttp: could not parse allowed host %q", entry)
+		}
+	}
+
+	return g, nil
+}
+
+// allowedIP returns true if ip is not in a blocked range, or has been
+// explicitly allowed.
+func (g *guard) allowedIP(ip net.IP) bool {
+	for _, ipNet := range g.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, ipNet := range blockedNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dialContext connects to addr, but fails if the address that the host
+// resolved to is not allowed. The check is made on the address actually dialled
+// so that a DNS response can't change between checking and connecting.
+func (g *guard) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if _, ok := g.hosts[strings.ToLower(host)]; !ok {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !g.allowedIP(ip) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+			}
+
+			return nil
+		}
+	}
+
+	return dialer.DialContext(ctx, network, addr)
+}
+
+type limitTransport struct {
+	base http.RoundTripper
+	max  int64
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: t.max}
+	return resp, nil
+}
+
+// limitedBody returns ErrBodyTooLarge if more than remaining bytes are
+// available to be read, rather than silently truncating the body.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		var extra [1]byte
+		n, err := b.ReadCloser.Read(extra[:])
+		if n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,176 @@
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestAllowedIP(t *testing.T) {
+	g, _ := newGuard(nil)
+
+	testCases := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::6810": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"::":              false,
+		"fd00:ec2::254":   false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+	}
+
+	for ip, expected := range testCases {
+		t.Run(ip, func(t *testing.T) {
+			assert.Equal(t, expected, g.allowedIP(net.ParseIP(ip)))
+		})
+	}
+}
+
+func TestAllowedIPWithAllowList(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	g, err := newGuard([]string{"10.0.0.0/24", "192.168.1.1"})
+	assert(err).Must.Nil()
+
+	assert(g.allowedIP(net.ParseIP("10.0.0.5"))).True()
+	assert(g.allowedIP(net.ParseIP("10.0.1.5"))).False()
+	assert(g.allowedIP(net.ParseIP("192.168.1.1"))).True()
+	assert(g.allowedIP(net.ParseIP("192.168.1.2"))).False()
+
+	_, err = newGuard([]string{"http://localhost/"})
+	assert(err).NotNil()
+}
+
+func TestClientBlocksLoopback(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "internal")
+	}))
+	defer s.Close()
+
+	client, err := Client(Options{MaxRedirects: 10, Timeout: time.Second})
+	assert(err).Must.Nil()
+
+	_, err = client.Get(s.URL)
+	assert(errors.Is(err, ErrBlockedAddress)).True()
+}
+
+func TestClientWithAllowedHost(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "local")
+	}))
+	defer s.Close()
+
+	client, err := Client(Options{Allow: []string{"127.0.0.1"}, MaxRedirects: 10, Timeout: time.Second})
+	assert(err).Must.Nil()
+
+	resp, err := client.Get(s.URL)
+	assert(err).Must.Nil()
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert(string(body)).Equal("local")
+}
+
+func TestClientWithTooManyRedirects(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Path+"a", http.StatusFound)
+	}))
+	defer s.Close()
+
+	client, err := Client(Options{Allow: []string{"127.0.0.1"}, MaxRedirects: 3, Timeout: time.Second})
+	assert(err).Must.Nil()
+
+	_, err = client.Get(s.URL + "/")
+	assert(errors.Is(err, ErrTooManyRedirects)).True()
+}
+
+func TestClientWithRedirectsAtLimit(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	// redirects /aaa to /aa to /a to /
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Path) > 1 {
+			http.Redirect(w, r, r.URL.Path[:len(r.URL.Path)-1], http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "done")
+	}))
+	defer s.Close()
+
+	client, err := Client(Options{Allow: []string{"127.0.0.1"}, MaxRedirects: 3, Timeout: time.Second})
+	assert(err).Must.Nil()
+
+	// two redirects make three requests, which is allowed
+	resp, err := client.Get(s.URL + "/aa")
+	assert(err).Must.Nil()
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert(string(body)).Equal("done")
+
+	// a third redirect would make a fourth request
+	_, err = client.Get(s.URL + "/aaa")
+	assert(errors.Is(err, ErrTooManyRedirects)).True()
+}
+
+func TestNoRedirectClient(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/other", http.StatusFound)
+	}))
+	defer s.Close()
+
+	client, err := NoRedirectClient(Options{Allow: []string{"127.0.0.1"}, Timeout: time.Second})
+	assert(err).Must.Nil()
+
+	resp, err := client.Get(s.URL)
+	assert(err).Must.Nil()
+	resp.Body.Close()
+	assert(resp.StatusCode).Equal(http.StatusFound)
+}
+
+func TestClientWithMaxBodySize(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("a", len(r.URL.Path)-1))
+	}))
+	defer s.Close()
+
+	client, err := Client(Options{Allow: []string{"127.0.0.1"}, MaxBodySize: 5, Timeout: time.Second})
+	assert(err).Must.Nil()
+
+	resp, err := client.Get(s.URL + "/aaaaa")
+	assert(err).Must.Nil()
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert(err).Nil()
+	assert(string(body)).Equal("aaaaa")
+
+	resp, err = client.Get(s.URL + "/aaaaaa")
+	assert(err).Must.Nil()
+	_, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert(err).Equal(ErrBodyTooLarge)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies that are trusted to say, with the
+// X-Forwarded-For header, which address a request came from.
+type TrustedProxies struct {
+	nets []*net.IPNet
+	unix bool
+}
+
+// ParseTrustedProxies reads a list of IP addresses and CIDR ranges. The entry
+// "unix" trusts requests made to a unix socket.
+func ParseTrustedProxies(entries []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+
+	for _, entry := range entries {
+		if entry == "unix" {
+			proxies.unix = true
+		} else if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			proxies.nets = append(proxies.nets, ipNet)
+		} else if ip := net.ParseIP(entry); ip != nil {
+			proxies.nets = append(proxies.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		} else {
+			return TrustedProxies{}, fmt.Errorf("could not parse trusted proxy %q", entry)
+		}
+	}
+
+	return proxies, nil
+}
+
+// Handler returns a http.Handler that, for requests from a trusted proxy,
+// replaces the RemoteAddr with the client's address from X-Forwarded-For. This
+// is the last address in the header that is not another trusted proxy, so
+// addresses the client added itself are ignored.
+func (p TrustedProxies) Handler(next http.Handler) http.Handler {
+	if len(p.nets) == 0 && !p.unix {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.trusted(r.RemoteAddr) {
+			if client := p.forwardedFor(r.Header.Values("X-Forwarded-For")); client != "" {
+				r.RemoteAddr = net.JoinHostPort(client, "0")
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p TrustedProxies) forwardedFor(headers []string) string {
+	var addrs []string
+	for _, header := range headers {
+		for _, addr := range strings.Split(header, ",") {
+			addrs = append(addrs, strings.TrimSpace(addr))
+		}
+	}
+
+	client := ""
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := net.ParseIP(addrs[i])
+		if ip == nil {
+			break
+		}
+
+		client = ip.String()
+		if !p.trusted(client) {
+			break
+		}
+	}
+
+	return client
+}
+
+// trusted returns true if addr, a host and port or an IP address, is a trusted
+// proxy. An addr that is not an IP address is from a unix socket.
+func (p TrustedProxies) trusted(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return p.unix
+	}
+
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		remoteAddr     string
+		forwardedFor   []string
+		expectedRemote string
+	}{
+		"not from a proxy": {
+			remoteAddr:     "198.51.100.1:1234",
+			forwardedFor:   []string{"203.0.113.1"},
+			expectedRemote: "198.51.100.1:1234",
+		},
+		"from a proxy": {
+			remoteAddr:     "192.0.2.1:1234",
+			forwardedFor:   []string{"203.0.113.1"},
+			expectedRemote: "203.0.113.1:0",
+		},
+		"from a unix socket": {
+			remoteAddr:     "@",
+			forwardedFor:   []string{"203.0.113.1"},
+			expectedRemote: "203.0.113.1:0",
+		},
+		"through many proxies": {
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"203.0.113.1, 10.0.0.2", "192.0.2.1"},
+			expectedRemote: "203.0.113.1:0",
+		},
+		"with an address added by the client": {
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"198.51.100.7, 203.0.113.1"},
+			expectedRemote: "203.0.113.1:0",
+		},
+		"with an ipv6 client": {
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"2001:db8::1"},
+			expectedRemote: "[2001:db8::1]:0",
+		},
+		"with an invalid header": {
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"what"},
+			expectedRemote: "10.0.0.1:1234",
+		},
+		"without a header": {
+			remoteAddr:     "10.0.0.1:1234",
+			expectedRemote: "10.0.0.1:1234",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var remoteAddr string
+			handler := proxies.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				remoteAddr = r.RemoteAddr
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, header := range tc.forwardedFor {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			assert.Equal(t, tc.expectedRemote, remoteAddr)
+		})
+	}
+}
+
+func TestParseTrustedProxiesWithInvalidEntry(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	_, err := ParseTrustedProxies([]string{"proxy.example.com"})
+	assert(err).NotNil()
+}
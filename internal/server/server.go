@@ -4,11 +4,13 @@ import (
 	"html/template"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"hawx.me/code/mux"
 	"hawx.me/code/relme-auth/internal/config"
 	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/dpop"
 	"hawx.me/code/relme-auth/internal/handler"
 	"hawx.me/code/relme-auth/internal/microformats"
 	"hawx.me/code/relme-auth/internal/strategy"
@@ -20,7 +22,13 @@ type DB interface {
 	handler.CallbackDB
 	handler.ChooseDB
 	handler.ContinueDB
+	handler.DeviceDB
 	handler.ExampleDB
+	handler.IntrospectDB
+	handler.JWKSDB
+	handler.MethodsDB
+	handler.PushedAuthorizationDB
+	handler.TicketDB
 	handler.TokenDB
 	handler.VerifyDB
 	handler.WebSocketDB
@@ -42,6 +50,9 @@ func New(
 	cookies *sessions.CookieStore,
 	tokenGenerator func(int) (string, error),
 	noRedirectClient *http.Client,
+	expiry data.Expiry,
+	useJWT bool,
+	locator handler.Locator,
 ) http.Handler {
 	route.Handle("/callback/continue", handler.Continue(database, codeGenerator))
 
@@ -73,29 +84,52 @@ func New(
 		}
 	}
 
+	relMe := &microformats.RelMe{
+		Client:           httpClient,
+		NoRedirectClient: noRedirectClient,
+		Concurrency:      conf.Discovery.Concurrency,
+		LinkTimeout:      time.Duration(conf.Discovery.Timeout) * time.Second,
+	}
+
 	route.Handle("/auth", mux.Method{
-		"GET":  handler.Choose(baseURL, database, strategies, templates["choose.gotmpl"], templates["me.gotmpl"]),
+		"GET":  handler.Choose(baseURL, conf, database, strategies, relMe, templates["choose.gotmpl"], templates["me.gotmpl"]),
 		"POST": handler.Verify(database),
 	})
 	route.Handle("/auth/start", mux.Method{
 		"GET": handler.Auth(database, strategies, httpClient),
 	})
 
-	route.Handle("/token", handler.Token(database, tokenGenerator))
+	route.Handle("/par", handler.PushedAuthorization(conf, database, codeGenerator, expiry.PushedRequest))
+	proofs := dpop.New(5 * time.Minute)
+
+	route.Handle("/token", handler.Token(baseURL, database, tokenGenerator, useJWT, proofs))
+	route.Handle("/device", handler.Device(baseURL, conf, cookies, database, templates["device.gotmpl"]))
+	route.Handle("/device/authorize", handler.DeviceAuthorization(baseURL, conf, database, codeGenerator, expiry.DeviceCode))
+	route.Handle("/device/callback", handler.DeviceCallback(baseURL, cookies, database, templates["device.gotmpl"]))
+	route.Handle("/introspect", handler.Introspect(baseURL, database, proofs))
+	route.Handle("/jwks", handler.JWKS(database))
+	route.Handle("/.well-known/oauth-authorization-server", handler.Metadata(baseURL, conf))
 	route.Handle("/pgp/authorize", handler.PGP(templates["pgp.gotmpl"]))
+	route.Handle("/discovery", handler.Discovery(strategies, relMe, templates["discovery.gotmpl"]))
+	route.Handle("/discovery.json", handler.DiscoveryReport(strategies, relMe))
 
-	route.Handle("/", handler.Example(baseURL, conf, cookies, database, templates["welcome.gotmpl"], templates["account.gotmpl"]))
+	route.Handle("/", handler.Example(baseURL, conf, cookies, database, locator, templates["welcome.gotmpl"], templates["account.gotmpl"]))
 	route.Handle("/sign-in", handler.ExampleSignIn(baseURL, cookies))
 	route.Handle("/redirect", handler.ExampleCallback(baseURL, cookies))
 	route.Handle("/sign-out", handler.ExampleSignOut(baseURL, cookies))
 	route.Handle("/revoke", handler.ExampleRevoke(baseURL, cookies, database))
+	route.Handle("/revoke-client", handler.ExampleRevokeClient(baseURL, cookies, database))
+	route.Handle("/revoke-all", handler.ExampleRevokeAll(baseURL, cookies, database))
+	route.Handle("/remove-login", handler.ExampleRemoveLogin(baseURL, cookies, database))
 	route.Handle("/privacy", handler.ExamplePrivacy(templates["privacy.gotmpl"]))
 	route.Handle("/forget", handler.ExampleForget(baseURL, cookies, database))
-	route.Handle("/generate", handler.ExampleGenerate(baseURL, cookies, tokenGenerator, database, templates["generate.gotmpl"]))
-
-	relMe := &microformats.RelMe{Client: httpClient, NoRedirectClient: noRedirectClient}
+	route.Handle("/export", handler.ExampleExport(baseURL, cookies, database))
+	route.Handle("/generate", handler.ExampleGenerate(baseURL, conf, cookies, tokenGenerator, database, templates["generate.gotmpl"]))
 
+	route.Handle("/ticket", handler.ExampleTicket(baseURL, conf, cookies, tokenGenerator, database, relMe, httpClient, templates["ticket.gotmpl"]))
 	route.Handle("/ws", handler.WebSocket(strategies, database, relMe))
+	route.Handle("/methods", handler.Methods(strategies, database, relMe))
+	route.Handle("/methods/events", handler.MethodEvents(strategies, database, relMe))
 	route.Handle("/public/*path", http.StripPrefix("/public", http.FileServer(http.Dir(webPath+"/static"))))
 
 	return route.Default
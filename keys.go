@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+// signingKeyRotateInterval is how often the keys used to sign JWT access tokens
+// are checked for rotation.
+const signingKeyRotateInterval = time.Hour
+
+// rotateSigningKeys rotates the signing keys immediately and then every
+// interval, until ctx is cancelled.
+func rotateSigningKeys(ctx context.Context, database *data.Database, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := database.RotateSigningKeys(); err != nil {
+			log.Println("could not rotate signing keys:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
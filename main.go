@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"flag"
 	"fmt"
@@ -13,13 +14,17 @@ import (
 	"github.com/gorilla/sessions"
 	"hawx.me/code/relme-auth/internal/config"
 	"hawx.me/code/relme-auth/internal/data"
+	"hawx.me/code/relme-auth/internal/geoip"
+	"hawx.me/code/relme-auth/internal/handler"
+	"hawx.me/code/relme-auth/internal/httpcache"
 	"hawx.me/code/relme-auth/internal/random"
+	"hawx.me/code/relme-auth/internal/safehttp"
 	"hawx.me/code/relme-auth/internal/server"
 	"hawx.me/code/serve"
 )
 
 func printHelp() {
-	fmt.Println(`Usage: relme-auth [options]
+	fmt.Println(`Usage: relme-auth [options] [command]
 
   relme-auth is a web service for authenticating with 3rd party
   auth providers.
@@ -41,14 +46,60 @@ func printHelp() {
     A base64 encoded string to use for authenticating sessions.
     It is recommended to use 32 or 64 bytes for this value.
 
+   --jwt
+    Issue access tokens as signed JWTs that expire after an hour,
+    instead of opaque tokens. The keys used are rotated weekly and
+    published at /jwks.
+
+   --encryption-key KEY
+    A base64 encoded 32 byte key to encrypt sensitive data in
+    the database with, such as the state and code_challenge of
+    requests. To rotate keys give the new key first, followed by
+    the old keys, then run the reencrypt command.
+
    --true
     Use the fake 'true' authentication provider. This should
     only be used locally for testing as it says everyone is
     authenticated!
 
+   --allow-host HOST
+    Allow fetching from HOST even though it is a loopback,
+    private or otherwise internal address. HOST can be a
+    hostname, IP address or CIDR range, and can be repeated.
+    This is useful for local development, for example
+    --allow-host localhost.
+
+   --geoip PATH
+    Show the rough location of sign-ins on the account page,
+    looked up in the CSV file at PATH. This must be in the
+    format of DB-IP's "IP to Country Lite" or "IP to City
+    Lite" databases. Without it locations show as Unknown.
+
+   --trust-proxy PROXY
+    Trust the X-Forwarded-For header of requests from PROXY,
+    when recording the IP address of sign-ins, audit events
+    and token uses. PROXY can be an IP address or CIDR range,
+    or 'unix' for requests to --socket, and can be repeated.
+    Without it the address of the proxy is recorded, and the
+    location from --geoip is Unknown.
+
  DATA
   --db PATH
-    Use the sqlite database at the given path.
+    Use the sqlite database at the given path. Or, if PATH is a
+    postgres:// URL, use that PostgreSQL database instead.
+
+  --reap-interval DURATION='1h'
+    How often to delete expired sessions, logins, cached
+    profiles and other data. Set to 0 to never delete them.
+
+  --audit-retention DURATION='2160h'
+    How long to keep the audit log of sign-ins and tokens being
+    created or revoked. Set to 0 to keep it forever.
+
+  --revoke-unused DURATION
+    Revoke tokens that have not been verified or introspected
+    for this long, checked each --reap-interval. By default
+    tokens are kept however long they go unused.
 
  SERVE
   Will use a systemd.socket if configured to do so.
@@ -57,7 +108,48 @@ func printHelp() {
     Serve on given port.
 
   --socket SOCK
-    Serve at given socket, instead.`)
+    Serve at given socket, instead.
+
+ COMMANDS
+  client add [--scope SCOPE]... [--redirect-uri URL]... CLIENT_ID
+    Register a confidential client, printing the secret it must
+    use to authenticate at the token endpoint. Only the given
+    scopes and redirect URIs will be allowed.
+
+  client list
+    List the registered clients.
+
+  client remove CLIENT_ID
+    Remove a registered client, revoking its tokens.
+
+  audit [--me URL] [--client-id URL] [--action ACTION] [--since DURATION] [--limit N]
+    List the most recent audit events, newest first. ACTION is
+    one of login, continue, logout, token_create, token_revoke,
+    forget or export.
+
+  tokens ME
+    List the tokens issued for ME, with when each was last used
+    and from where.
+
+  migrate status
+    List the database migrations, and whether each has been
+    applied.
+
+  migrate up
+    Apply any migrations that have not been. This also happens
+    when starting the server.
+
+  migrate down
+    Revert the most recently applied migration.
+
+  reencrypt
+    Encrypt all sensitive data with the first --encryption-key,
+    so that older keys are no longer needed.
+
+  import-sqlite PATH
+    Copy all data from the sqlite database at PATH into the
+    database given by --db, which should be empty. This is for
+    moving an existing install to PostgreSQL.`)
 }
 
 func loadTemplates(webPath string) (map[string]*template.Template, error) {
@@ -89,17 +181,58 @@ func loadTemplates(webPath string) (map[string]*template.Template, error) {
 	return templates, nil
 }
 
+// decodeKeys decodes each base64 encoded encryption key.
+func decodeKeys(encoded []string) ([][]byte, error) {
+	keys := make([][]byte, len(encoded))
+	for i, key := range encoded {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = decoded
+	}
+
+	return keys, nil
+}
+
+// openDatabase opens the database at dsn, applying any migrations, and sets the
+// keys used to encrypt and decrypt values in it.
+func openDatabase(dsn string, httpClient *http.Client, cookies sessions.Store, expiry data.Expiry, keys [][]byte) (*data.Database, error) {
+	database, err := data.Open(dsn, httpClient, cookies, expiry)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %w", err)
+	}
+
+	if err := database.SetEncryptionKeys(keys); err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return database, nil
+}
+
 func main() {
 	var (
 		port         = flag.String("port", "8080", "Port to run on")
 		socket       = flag.String("socket", "", "Socket to run on")
 		baseURL      = flag.String("base-url", "http://localhost:8080", "Where this is running")
 		configPath   = flag.String("config", "./config.toml", "Path to config file")
-		dbPath       = flag.String("db", "", "Path to database")
+		dbPath       = flag.String("db", "", "Path to sqlite database, or a postgres:// URL")
 		cookieSecret = flag.String("cookie-secret", "", "Secret to authenticate sessions with")
 		useTrue      = flag.Bool("true", false, "Use the fake 'true' auth provider")
+		useJWT       = flag.Bool("jwt", false, "Issue JWT access tokens")
 		webPath      = flag.String("web-path", "web", "Path to web/ directory")
+		reapInterval = flag.Duration("reap-interval", time.Hour, "How often to delete expired data, 0 to never")
+		auditKeep    = flag.Duration("audit-retention", 90*24*time.Hour, "How long to keep audit events, 0 for forever")
+		revokeUnused = flag.Duration("revoke-unused", 0, "Revoke tokens that have not been used for this long, 0 to never")
+		geoipPath    = flag.String("geoip", "", "Path to a GeoIP CSV database, to show the location of sign-ins")
+		allowHosts   stringsFlag
+		keyFlags     stringsFlag
+		trustProxies stringsFlag
 	)
+	flag.Var(&allowHosts, "allow-host", "Host, IP or CIDR range that may be fetched even though it is internal, can be repeated")
+	flag.Var(&keyFlags, "encryption-key", "Key to encrypt sensitive data with, can be repeated to read data encrypted with older keys")
+	flag.Var(&trustProxies, "trust-proxy", "IP, CIDR range or 'unix' of a reverse proxy whose X-Forwarded-For is trusted, can be repeated")
 	flag.Usage = func() { printHelp() }
 	flag.Parse()
 
@@ -109,14 +242,123 @@ func main() {
 		return
 	}
 
-	httpClient := &http.Client{
-		Timeout: 10 * time.Second,
+	fetchOptions := safehttp.Options{
+		Allow:        allowHosts,
+		MaxBodySize:  5 << 20,
+		MaxRedirects: 10,
+		Timeout:      10 * time.Second,
+	}
+
+	httpClient, err := safehttp.Client(fetchOptions)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	noRedirectClient, err := safehttp.NoRedirectClient(fetchOptions)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	expiry := data.Expiry{
+		Session:       5 * time.Minute,
+		Code:          60 * time.Second,
+		Client:        24 * time.Hour,
+		Profile:       7 * 24 * time.Hour,
+		Login:         8 * time.Hour,
+		PushedRequest: 60 * time.Second,
+		AccessToken:   time.Hour,
+		SigningKey:    7 * 24 * time.Hour,
+		DeviceCode:    10 * time.Minute,
+		Ticket:        time.Hour,
+		HTTPCache:     7 * 24 * time.Hour,
+		Audit:         *auditKeep,
+	}
+
+	encryptionKeys, err := decodeKeys(keyFlags)
+	if err != nil {
+		fmt.Println("could not base64 decode encryption-key:", err)
+		return
+	}
+
+	if flag.Arg(0) == "client" {
+		database, err := openDatabase(*dbPath, httpClient, nil, expiry, encryptionKeys)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer database.Close()
+
+		if err := runClient(database, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "audit" {
+		database, err := openDatabase(*dbPath, httpClient, nil, expiry, encryptionKeys)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer database.Close()
+
+		if err := runAudit(database, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "tokens" {
+		database, err := openDatabase(*dbPath, httpClient, nil, expiry, encryptionKeys)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer database.Close()
+
+		if err := runTokens(database, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "migrate" {
+		database, err := data.Connect(*dbPath, httpClient, nil, expiry)
+		if err != nil {
+			fmt.Println("could not open database:", err)
+			return
+		}
+		defer database.Close()
+
+		if err := runMigrate(database, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "reencrypt" {
+		database, err := openDatabase(*dbPath, httpClient, nil, expiry, encryptionKeys)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer database.Close()
+
+		count, err := database.Reencrypt()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("reencrypted", count, "values")
+		return
 	}
-	noRedirectClient := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-		Timeout: 10 * time.Second,
+
+	if flag.Arg(0) == "import-sqlite" {
+		if err := importSQLite(*dbPath, flag.Arg(1), httpClient, expiry); err != nil {
+			fmt.Println(err)
+		}
+		return
 	}
 
 	codeGenerator := random.Generator(20)
@@ -133,30 +375,80 @@ func main() {
 	cookies.Options.SameSite = http.SameSiteLaxMode
 	cookies.Options.Secure = strings.HasPrefix(*baseURL, "https://")
 
-	database, err := data.Open(*dbPath, httpClient, cookies, data.Expiry{
-		Session: 5 * time.Minute,
-		Code:    60 * time.Second,
-		Client:  24 * time.Hour,
-		Profile: 7 * 24 * time.Hour,
-		Login:   8 * time.Hour,
-	})
+	database, err := openDatabase(*dbPath, httpClient, cookies, expiry, encryptionKeys)
 	if err != nil {
-		fmt.Println("could not open database:", err)
+		fmt.Println(err)
 		return
 	}
 	defer database.Close()
 
+	httpClient.Transport = httpcache.New(httpClient.Transport, database)
+	noRedirectClient.Transport = httpcache.New(noRedirectClient.Transport, database)
+
+	if *useJWT {
+		ctx, stopRotating := context.WithCancel(context.Background())
+		rotating := make(chan struct{})
+		go func() {
+			rotateSigningKeys(ctx, database, signingKeyRotateInterval)
+			close(rotating)
+		}()
+		defer func() {
+			stopRotating()
+			<-rotating
+		}()
+	}
+
 	templates, err := loadTemplates(*webPath)
 	if err != nil {
 		fmt.Println("could not load templates:", err)
 		return
 	}
 
+	var locator handler.Locator
+	if *geoipPath != "" {
+		geoipDatabase, err := geoip.Open(*geoipPath)
+		if err != nil {
+			fmt.Println("could not open geoip database:", err)
+			return
+		}
+		locator = geoipDatabase
+	}
+
+	proxies, err := server.ParseTrustedProxies(trustProxies)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	flushCtx, stopFlushing := context.WithCancel(context.Background())
+	flushing := make(chan struct{})
+	go func() {
+		flushTokenUses(flushCtx, database, tokenUseFlushInterval)
+		close(flushing)
+	}()
+	defer func() {
+		stopFlushing()
+		<-flushing
+	}()
+
+	if *reapInterval > 0 {
+		ctx, stopReaping := context.WithCancel(context.Background())
+		reaping := make(chan struct{})
+		go func() {
+			reap(ctx, database, *reapInterval, *useJWT, *revokeUnused)
+			close(reaping)
+		}()
+		defer func() {
+			stopReaping()
+			<-reaping
+		}()
+	}
+
 	serve.Server(*port, *socket, &http.Server{
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
-		Handler: server.New(
+		Handler: proxies.Handler(server.New(
 			database,
 			codeGenerator,
 			*baseURL,
@@ -168,6 +460,9 @@ func main() {
 			cookies,
 			tokenGenerator,
 			noRedirectClient,
-		),
+			expiry,
+			*useJWT,
+			locator,
+		)),
 	})
 }
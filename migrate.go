@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+// runMigrate manages the database schema, for the "migrate" command.
+func runMigrate(database *data.Database, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected one of: status, up, down")
+	}
+
+	switch args[0] {
+	case "status":
+		migrations, err := database.Migrations()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			switch {
+			case m.Unknown:
+				fmt.Printf("%04d %-30s unknown, applied %s\n", m.Version, "?", m.AppliedAt.Format(time.RFC3339))
+			case m.Applied():
+				fmt.Printf("%04d %-30s applied %s\n", m.Version, m.Name, m.AppliedAt.Format(time.RFC3339))
+			default:
+				fmt.Printf("%04d %-30s pending\n", m.Version, m.Name)
+			}
+		}
+
+	case "up":
+		return database.MigrateUp()
+
+	case "down":
+		m, ok, err := database.MigrateDown()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("no migrations to revert")
+		}
+
+		fmt.Printf("reverted %04d %s\n", m.Version, m.Name)
+
+	default:
+		return errors.New("expected one of: status, up, down")
+	}
+
+	return nil
+}
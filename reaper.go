@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+// reap deletes expired rows from the database every interval, until ctx is
+// cancelled. If revokeUnused is set, tokens that have not been used for that
+// long are revoked too.
+func reap(ctx context.Context, database *data.Database, interval time.Duration, expireTokens bool, revokeUnused time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		reaped, err := database.Reap(time.Now().UTC(), expireTokens)
+		if err != nil {
+			log.Println("could not reap expired rows:", err)
+		} else if reaped.Total() > 0 {
+			log.Println("reaped expired rows:", reaped)
+		}
+
+		if revokeUnused > 0 {
+			revokeUnusedTokens(database, revokeUnused)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func revokeUnusedTokens(database *data.Database, unusedFor time.Duration) {
+	// write any recent uses first, so that tokens still in use are kept
+	if err := database.FlushTokenUses(); err != nil {
+		log.Println("could not write token uses:", err)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	revoked, err := database.RevokeUnused(now.Add(-unusedFor))
+	if err != nil {
+		log.Println("could not revoke unused tokens:", err)
+		return
+	}
+	if len(revoked) == 0 {
+		return
+	}
+
+	log.Println("revoked unused tokens:", len(revoked))
+
+	for _, token := range revoked {
+		if err := database.Audit(data.AuditEvent{
+			Action:    data.AuditTokenRevoke,
+			Me:        token.Me,
+			ClientID:  token.ClientID,
+			Outcome:   "unused",
+			CreatedAt: now,
+		}); err != nil {
+			log.Println("could not record revoking unused token:", err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+// runTokens lists the tokens issued for a user, for the "tokens" command.
+func runTokens(database *data.Database, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a me")
+	}
+
+	tokens, err := database.Tokens(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		lastUsed := "never"
+		if !token.LastUsedAt.IsZero() {
+			lastUsed = token.LastUsedAt.Format(time.RFC3339) + " from " + token.LastUsedIP
+		}
+
+		fmt.Printf("%s\n  client_id: %s\n  scope: %s\n  created: %s\n  last_used: %s\n  uses: %d\n",
+			token.ShortToken,
+			token.ClientID,
+			token.Scope,
+			token.CreatedAt.Format(time.RFC3339),
+			lastUsed,
+			token.UseCount)
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"hawx.me/code/relme-auth/internal/data"
+)
+
+// tokenUseFlushInterval is how often the recorded uses of tokens are written
+// to the database.
+const tokenUseFlushInterval = time.Minute
+
+// flushTokenUses writes the recorded uses of tokens every interval, and once
+// more when ctx is cancelled so that none are lost on shutdown.
+func flushTokenUses(ctx context.Context, database *data.Database, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := database.FlushTokenUses(); err != nil {
+				log.Println("could not write token uses:", err)
+			}
+			return
+		case <-ticker.C:
+			if err := database.FlushTokenUses(); err != nil {
+				log.Println("could not write token uses:", err)
+			}
+		}
+	}
+}
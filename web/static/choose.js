@@ -1,20 +1,102 @@
-const urlParams = new URLSearchParams(window.location.search);
-
 const methods = document.querySelector('.methods');
 const info = document.querySelector('.info');
 const cachedAt = document.querySelector('.cachedAt');
 const refresh = document.getElementById('refresh');
 const loader = document.querySelector('.loader');
 
-var socket = new WebSocket(`${ window.location.protocol === 'https:' ? 'wss:' : 'ws:' }//${ window.location.host }/ws`);
-socket.onopen = function (event) {
-    socket.send(JSON.stringify({
-        me: urlParams.get('me'),
-        clientID: urlParams.get('client_id'),
-        redirectURI: urlParams.get('redirect_uri'),
-        force: false,
-    }));
-};
+// send requests the methods for the user, it is set by whichever way of
+// connecting works: a websocket, then Server-Sent Events, then plain JSON.
+let send;
+
+function requestQuery(force) {
+    return new URLSearchParams({
+        me: methods.dataset.me,
+        client_id: methods.dataset.clientId,
+        redirect_uri: methods.dataset.redirectUri,
+        force: force,
+    }).toString();
+}
+
+function connectWebSocket() {
+    let opened = false;
+    const socket = new WebSocket(`${ window.location.protocol === 'https:' ? 'wss:' : 'ws:' }//${ window.location.host }/ws`);
+
+    socket.onopen = function () {
+        opened = true;
+        send = function (force) {
+            socket.send(JSON.stringify({
+                me: methods.dataset.me,
+                clientID: methods.dataset.clientId,
+                redirectURI: methods.dataset.redirectUri,
+                force: force,
+            }));
+        };
+        send(false);
+    };
+
+    socket.onmessage = function (event) {
+        handleMessage(JSON.parse(event.data));
+    };
+
+    socket.onclose = function () {
+        if (!opened) {
+            connectEventSource();
+        }
+    };
+}
+
+function connectEventSource() {
+    if (!window.EventSource) {
+        useJSON();
+        send(false);
+        return;
+    }
+
+    let source;
+    send = function (force) {
+        if (source) {
+            source.close();
+        }
+
+        let received = false;
+        source = new EventSource('/methods/events?' + requestQuery(force));
+
+        source.onmessage = function (event) {
+            received = true;
+            const profile = JSON.parse(event.data);
+            handleMessage(profile);
+
+            if (!profile.Type || profile.Type === 'done' || (profile.Type === 'error' && profile.Link === '')) {
+                source.close();
+            }
+        };
+
+        source.onerror = function () {
+            source.close();
+            if (!received) {
+                useJSON();
+                send(force);
+            }
+        };
+    };
+    send(false);
+}
+
+function useJSON() {
+    send = function (force) {
+        fetch('/methods?' + requestQuery(force))
+            .then(function (resp) {
+                if (!resp.ok) {
+                    throw new Error(resp.statusText);
+                }
+                return resp.json();
+            })
+            .then(handleMessage)
+            .catch(function () {
+                showError(methods);
+            });
+    };
+}
 
 refresh.onclick = function() {
     while (methods.firstChild) {
@@ -23,20 +105,15 @@ refresh.onclick = function() {
     info.classList.add('loading');
     loader.classList.remove('hide');
 
-    socket.send(JSON.stringify({
-        me: urlParams.get('me'),
-        clientID: urlParams.get('client_id'),
-        redirectURI: urlParams.get('redirect_uri'),
-        force: true,
-    }));
+    if (send) {
+        send(true);
+    }
 };
 
 const elements = {};
 let anyVerified = false;
 
-socket.onmessage = function (event) {
-    const profile = JSON.parse(event.data);
-
+function handleMessage(profile) {
     if (profile.Type) {
         switch (profile.Type) {
         case 'error':
@@ -92,6 +169,8 @@ socket.onmessage = function (event) {
     }
 }
 
+connectWebSocket();
+
 function showError(root) {
     const p = document.createElement('p');
     const text = document.createTextNode('Something went wrong while trying to retrieve possible authentication methods.');